@@ -0,0 +1,421 @@
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"server/auth"
+	"server/database"
+	"server/types"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// removeUser deletes the account and everything belonging to it. Foreign keys
+// are not enforced, so every table is cleared explicitly in one transaction.
+// Stored content is removed afterwards by purgeUserStorage.
+func (app *app) removeUser(id int64) (bool, error) {
+	login, err := app.Query.GetLogin(app.Ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	checksums, err := app.Query.GetFileChecksums(app.Ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	uploads, err := app.Query.GetUserUploads(app.Ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := app.Query.WithTx(tx)
+
+	// Rows referencing files and albums go before the files and albums
+	steps := []func() error{
+		func() error { return query.DeleteUserShares(app.Ctx, id) },
+		func() error { return query.DeleteUserFileTags(app.Ctx, id) },
+		func() error { return query.DeleteUserTags(app.Ctx, id) },
+		func() error { return query.DeleteUserAlbumFiles(app.Ctx, id) },
+		func() error { return query.DeleteUserAlbums(app.Ctx, id) },
+		func() error { return query.DeleteUserFiles(app.Ctx, id) },
+		func() error { return query.DeleteUserUploads(app.Ctx, id) },
+		func() error { return query.DeleteUserAPIKeys(app.Ctx, id) },
+		func() error { _, err := query.DeleteTOTP(app.Ctx, id); return err },
+		func() error { return query.DeleteRecoveryCodes(app.Ctx, id) },
+		func() error { return query.DeletePasswordResets(app.Ctx, id) },
+		func() error { return query.DeleteEmailVerifications(app.Ctx, id) },
+		func() error {
+			return query.ClearInviteCreator(app.Ctx, types.JSONNullInt64{NullInt64: sql.NullInt64{Int64: id, Valid: true}})
+		},
+	}
+
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return false, err
+		}
+	}
+
+	deleted, err := query.DeleteUser(app.Ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	if _, err := database.DeleteUserTokens(app.CACHE, id, ""); err != nil {
+		log.Println(err)
+	}
+
+	if err := database.DeleteUserChallenges(app.CACHE, id); err != nil {
+		log.Println(err)
+	}
+
+	if _, err := auth.Unlock(app.CACHE, auth.LockoutLogin, login); err != nil {
+		log.Println(err)
+	}
+
+	go app.purgeUserStorage(login, checksums, uploads)
+
+	return deleted > 0, nil
+}
+
+// purgeUserStorage removes the content left behind by a deleted user: blobs
+// no other file uses, partial uploads and files from the old per-user layout.
+// Blobs it fails to remove are picked up later by collectBlobs.
+func (app *app) purgeUserStorage(login string, checksums []string, uploads []int64) {
+	// Wait for a PATCH still writing, later ones find the upload gone
+	for _, id := range uploads {
+		lock, _ := uploadLocks.LoadOrStore(id, &sync.Mutex{})
+		lock.(*sync.Mutex).Lock()
+
+		if err := os.Remove(uploadPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Println(err)
+		}
+		uploadLocks.Delete(id)
+
+		lock.(*sync.Mutex).Unlock()
+	}
+
+	for _, checksum := range checksums {
+		if err := app.releaseBlob(checksum); err != nil {
+			log.Println(err)
+		}
+	}
+
+	legacy, err := app.Store.List(app.Ctx, "users/"+login+"/")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, info := range legacy {
+		if err := app.Store.Delete(app.Ctx, info.Key); err != nil {
+			log.Println(err)
+		}
+	}
+
+	log.Printf("Account: -- Removed storage of %s: %d files, %d uploads", login, len(checksums), len(uploads))
+}
+
+// exportAccount writes a zip archive with every file of the user and a
+// metadata.json describing the account, files, albums and shares.
+func (app *app) exportAccount(w io.Writer, id int64) error {
+	archive := zip.NewWriter(w)
+
+	if err := app.writeExport(archive, id); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// writeExport adds the files and metadata.json of the export to archive.
+func (app *app) writeExport(archive *zip.Writer, id int64) error {
+	user, err := app.Query.GetUser(app.Ctx, id)
+	if err != nil {
+		return err
+	}
+
+	email, err := app.Query.GetEmail(app.Ctx, id)
+	if err != nil {
+		return err
+	}
+
+	profile, err := app.Query.GetProfile(app.Ctx, id)
+	if err != nil {
+		return err
+	}
+
+	files, err := app.Query.GetUserFiles(app.Ctx, id)
+	if err != nil {
+		return err
+	}
+
+	albums, err := app.Query.GetAlbums(app.Ctx, id)
+	if err != nil {
+		return err
+	}
+
+	shares, err := app.Query.GetSharedFiles(app.Ctx, database.GetSharedFilesParams{OwnerID: id, IsAdmin: 0})
+	if err != nil {
+		return err
+	}
+
+	if shares == nil {
+		shares = []database.Fileguestshare{}
+	}
+
+	type exportFile struct {
+		database.File
+		Path string   `json:"path"`
+		Tags []string `json:"tags"`
+	}
+
+	type exportAlbum struct {
+		database.Album
+		Files []int64 `json:"files"`
+	}
+
+	metadata := struct {
+		Login      string                    `json:"login"`
+		Email      string                    `json:"email"`
+		Profile    string                    `json:"profile"`
+		ExportedAt time.Time                 `json:"exported_at"`
+		Files      []exportFile              `json:"files"`
+		Albums     []exportAlbum             `json:"albums"`
+		Shares     []database.Fileguestshare `json:"shares"`
+	}{
+		Login:      user.Login,
+		Email:      email.String,
+		Profile:    profile.String,
+		ExportedAt: time.Now().UTC(),
+		Files:      []exportFile{},
+		Albums:     []exportAlbum{},
+		Shares:     shares,
+	}
+
+	for _, file := range files {
+		tagIDs, err := app.Query.GetTagsByFile(app.Ctx, file.ID)
+		if err != nil {
+			return err
+		}
+
+		tags := []string{}
+		for _, tagID := range tagIDs {
+			tag, err := app.Query.GetTagPath(app.Ctx, tagID)
+			if err != nil {
+				return err
+			}
+			tags = append(tags, tag)
+		}
+
+		// Prefix the id, file names do not have to be unique
+		name := path.Join("files", fmt.Sprintf("%d-%s", file.ID, path.Base(file.FileName)))
+
+		if err := app.exportBlob(archive, name, file); err != nil {
+			return err
+		}
+
+		metadata.Files = append(metadata.Files, exportFile{File: file, Path: name, Tags: tags})
+	}
+
+	for _, album := range albums {
+		fileIDs, err := app.Query.GetFileFromAlbum(app.Ctx, album.ID)
+		if err != nil {
+			return err
+		}
+
+		if fileIDs == nil {
+			fileIDs = []int64{}
+		}
+
+		metadata.Albums = append(metadata.Albums, exportAlbum{Album: album, Files: fileIDs})
+	}
+
+	f, err := archive.CreateHeader(&zip.FileHeader{Name: "metadata.json", Method: zip.Deflate, Modified: metadata.ExportedAt})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&metadata)
+}
+
+func (app *app) exportBlob(archive *zip.Writer, name string, file database.File) error {
+	blob, err := app.Store.Get(app.Ctx, blobKey(file.Checksum))
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	header := &zip.FileHeader{Name: name, Method: zip.Store}
+	if file.CreatedAt.Valid {
+		header.Modified = file.CreatedAt.Time
+	}
+
+	// Photos are compressed already, store them as they are
+	f, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, blob)
+	return err
+}
+
+func setExportHeaders(w http.ResponseWriter, login string) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-export.zip"`, path.Base(login)))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func (app *app) exportOwnAccount(w http.ResponseWriter, r *http.Request) {
+	id := r.Context().Value("id").(int64)
+
+	login, err := app.Query.GetLogin(app.Ctx, id)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	setExportHeaders(w, login)
+
+	// The status is sent with the first byte, later errors can only cut the
+	// archive short
+	if err := app.exportAccount(w, id); err != nil {
+		log.Printf("Account: -- Export of user %d failed: %v", id, err)
+	}
+}
+
+// deleteAccount deletes the account of the caller after checking the password
+// and, when enabled, a two-factor code. With export set the response is the
+// account export, and the account is only deleted once it was written.
+func (app *app) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+		Export   bool   `json:"export"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	id := r.Context().Value("id").(int64)
+
+	user, err := app.Query.GetUser(app.Ctx, id)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
+		sendError(w, Error{401, "Wrong password", "Unauthorized"}, err)
+		return
+	}
+
+	totp, err := app.Query.GetTOTP(app.Ctx, id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if err == nil && totp.Confirmed == 1 {
+		ok, err := app.checkTOTP(totp, input.Code)
+		if err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+
+		if !ok {
+			sendError(w, Error{401, "Invalid code", "Unauthorized"}, nil)
+			return
+		}
+	}
+
+	if input.Export {
+		app.exportAndDeleteAccount(w, id, user.Login)
+		return
+	}
+
+	if _, err := app.removeUser(id); err != nil {
+		sendError(w, Error{500, "Could not delete account", "Internal Server Error"}, err)
+		return
+	}
+
+	log.Printf("Account: -- User %s deleted their account", user.Login)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// exportAndDeleteAccount streams the export and deletes the account once it
+// was written. The status is sent before the account is deleted, so the
+// archive ends with deleted.json only when the deletion succeeded. Without
+// it the export is complete but the account still exists.
+func (app *app) exportAndDeleteAccount(w http.ResponseWriter, id int64, login string) {
+	setExportHeaders(w, login)
+
+	archive := zip.NewWriter(w)
+
+	if err := app.writeExport(archive, id); err != nil {
+		log.Printf("Account: -- Export of user %d failed, not deleting: %v", id, err)
+		return
+	}
+
+	if _, err := app.removeUser(id); err != nil {
+		log.Printf("Account: -- Could not delete user %d after export: %v", id, err)
+	} else {
+		log.Printf("Account: -- User %s deleted their account", login)
+
+		if err := writeDeletedMarker(archive); err != nil {
+			log.Println(err)
+			return
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		log.Println(err)
+	}
+}
+
+// writeDeletedMarker adds deleted.json to an export, recording when the
+// account was deleted.
+func writeDeletedMarker(archive *zip.Writer) error {
+	marker := struct {
+		DeletedAt time.Time `json:"deleted_at"`
+	}{
+		DeletedAt: time.Now().UTC(),
+	}
+
+	f, err := archive.CreateHeader(&zip.FileHeader{Name: "deleted.json", Method: zip.Deflate, Modified: marker.DeletedAt})
+	if err != nil {
+		return err
+	}
+
+	return json.NewEncoder(f).Encode(&marker)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"server/auth"
+	"server/database"
+)
+
+// adminUserInput is the body of the admin endpoints acting on one user.
+type adminUserInput struct {
+	UserID int64 `json:"user_id"`
+}
+
+// checkAdminTarget refuses requests where admins act on their own account,
+// so there is always at least one admin left.
+func checkAdminTarget(w http.ResponseWriter, r *http.Request, userID int64) bool {
+	if userID == r.Context().Value("id").(int64) {
+		sendError(w, Error{400, "Admins cannot change their own account here", "Bad Request"}, nil)
+		return false
+	}
+
+	return true
+}
+
+func (app *app) adminListUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := app.Query.GetUsers(app.Ctx)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	// quota is null for users on the server default, the embedded field
+	// would show them as unlimited
+	type user struct {
+		database.GetUsersRow
+		Quota *int64 `json:"quota"`
+		Files int64  `json:"files"`
+		Bytes int64  `json:"bytes"`
+	}
+
+	output := struct {
+		Users []user `json:"users"`
+	}{
+		Users: []user{},
+	}
+
+	for _, u := range users {
+		usage, err := app.Query.GetStorageUsage(app.Ctx, u.ID)
+		if err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+
+		entry := user{GetUsersRow: u, Files: usage.Files, Bytes: usage.Bytes}
+		if u.Quota.Valid {
+			entry.Quota = &u.Quota.Int64
+		}
+
+		output.Users = append(output.Users, entry)
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// adminSetRole promotes a user to admin or demotes them.
+func (app *app) adminSetRole(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		UserID  int64 `json:"user_id"`
+		IsAdmin bool  `json:"is_admin"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	if !checkAdminTarget(w, r, input.UserID) {
+		return
+	}
+
+	params := database.ChangeRoleParams{ID: input.UserID}
+	if input.IsAdmin {
+		params.IsAdmin = 1
+	}
+
+	user, err := app.Query.ChangeRole(app.Ctx, params)
+	if err != nil {
+		sendError(w, Error{404, "User not found", "Not Found"}, err)
+		return
+	}
+
+	log.Printf("Admin: -- Set is_admin of user %d to %d", user.ID, user.IsAdmin)
+
+	output := struct {
+		ID      int64  `json:"id"`
+		Login   string `json:"login"`
+		IsAdmin int64  `json:"is_admin"`
+	}{
+		ID:      user.ID,
+		Login:   user.Login,
+		IsAdmin: user.IsAdmin,
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// setUserDisabled disables or enables an account. Disabling it ends all of
+// its sessions, its API keys are refused while it stays disabled. Enabling
+// does not verify the email address of an account waiting for it.
+func (app *app) setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	input := adminUserInput{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	if !checkAdminTarget(w, r, input.UserID) {
+		return
+	}
+
+	params := database.SetUserDisabledParams{ID: input.UserID}
+	if disabled {
+		params.Disabled = 1
+	}
+
+	updated, err := app.Query.SetUserDisabled(app.Ctx, params)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if updated == 0 {
+		sendError(w, Error{404, "User not found", "Not Found"}, nil)
+		return
+	}
+
+	if disabled {
+		if _, err := database.DeleteUserTokens(app.CACHE, input.UserID, ""); err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+
+		if err := database.DeleteUserChallenges(app.CACHE, input.UserID); err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+	}
+
+	log.Printf("Admin: -- Set disabled of user %d to %d", input.UserID, params.Disabled)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (app *app) adminDisableUser(w http.ResponseWriter, r *http.Request) {
+	app.setUserDisabled(w, r, true)
+}
+
+func (app *app) adminEnableUser(w http.ResponseWriter, r *http.Request) {
+	app.setUserDisabled(w, r, false)
+}
+
+// adminForcePasswordReset replaces the password with a random one, ends every
+// session and sends the user a reset link. Without an email address on file
+// the link is returned so the admin can pass it on.
+func (app *app) adminForcePasswordReset(w http.ResponseWriter, r *http.Request) {
+	input := adminUserInput{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	if !checkAdminTarget(w, r, input.UserID) {
+		return
+	}
+
+	user, err := app.Query.GetUser(app.Ctx, input.UserID)
+	if err != nil {
+		sendError(w, Error{404, "User not found", "Not Found"}, err)
+		return
+	}
+
+	email, err := app.Query.GetEmail(app.Ctx, user.ID)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	password, err := auth.GenerateSecureToken(32)
+	if err != nil {
+		sendError(w, Error{500, "Could not generate a new token", "Internal Server Error"}, err)
+		return
+	}
+
+	if !app.setPassword(w, user.ID, password, "") {
+		return
+	}
+
+	token, err := app.createPasswordReset(user.ID)
+	if err != nil {
+		sendError(w, Error{500, "Could not create reset token", "Internal Server Error"}, err)
+		return
+	}
+
+	log.Printf("Admin: -- Forced password reset of user %d", user.ID)
+
+	output := struct {
+		Mailed   bool   `json:"mailed"`
+		ResetURL string `json:"reset_url,omitempty"`
+	}{}
+
+	if email.Valid && email.String != "" {
+		go app.sendResetMail(user.Login, email.String, token)
+		output.Mailed = true
+	} else {
+		output.ResetURL = app.resetLink(token)
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (app *app) adminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	input := adminUserInput{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	if !checkAdminTarget(w, r, input.UserID) {
+		return
+	}
+
+	deleted, err := app.removeUser(input.UserID)
+	if err != nil {
+		sendError(w, Error{500, "Could not delete user", "Internal Server Error"}, err)
+		return
+	}
+
+	if !deleted {
+		sendError(w, Error{404, "User not found", "Not Found"}, nil)
+		return
+	}
+
+	log.Printf("Admin: -- Deleted user %d", input.UserID)
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"server/auth"
+	"server/database"
+	"server/types"
+)
+
+// createAPIKey issues a long-lived key for scripts. The key is only returned
+// here, the server keeps its hash.
+func (app *app) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		Name      string    `json:"name"`
+		Scopes    []string  `json:"scopes"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	if len(input.Scopes) == 0 {
+		sendError(w, Error{400, "At least one scope is required", "Bad Request"}, nil)
+		return
+	}
+
+	if !input.ExpiresAt.IsZero() && input.ExpiresAt.Before(time.Now()) {
+		sendError(w, Error{400, "Expiration is in the past", "Bad Request"}, nil)
+		return
+	}
+
+	id := r.Context().Value("id").(int64)
+
+	key, row, err := auth.CreateAPIKey(app.Query, id, input.Name, input.Scopes, input.ExpiresAt)
+	if err != nil {
+		sendError(w, Error{400, "Could not create API key", "Bad Request"}, err)
+		return
+	}
+
+	log.Printf("API key: -- Created key %d for user %d", row.ID, id)
+
+	output := struct {
+		ID        int64              `json:"id"`
+		Key       string             `json:"key"`
+		Name      string             `json:"name"`
+		Scopes    string             `json:"scopes"`
+		ExpiresAt types.JSONNullTime `json:"expires_at"`
+	}{
+		ID:        row.ID,
+		Key:       key,
+		Name:      row.Name,
+		Scopes:    row.Scopes,
+		ExpiresAt: row.ExpiresAt,
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (app *app) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := app.Query.GetAPIKeys(app.Ctx, r.Context().Value("id").(int64))
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	output := struct {
+		Keys   []database.GetAPIKeysRow `json:"keys"`
+		Scopes []string                 `json:"available_scopes"`
+	}{
+		Keys:   keys,
+		Scopes: auth.Scopes,
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (app *app) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		KeyID int64 `json:"key_id"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	deleted, err := app.Query.DeleteAPIKey(app.Ctx, database.DeleteAPIKeyParams{
+		ID:     input.KeyID,
+		UserID: r.Context().Value("id").(int64),
+	})
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if deleted == 0 {
+		sendError(w, Error{404, "API key not found", "Not Found"}, nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -4,10 +4,26 @@ import (
 	"crypto/rand"
 	"database/sql"
 	"encoding/base64"
+	"strings"
+	"time"
 
 	"server/database"
 )
 
+var (
+	// IdleTimeout ends sessions that have not been used for this long.
+	// Every authenticated request renews it.
+	IdleTimeout = 7 * 24 * time.Hour
+	// MaxAge ends sessions this long after login, however active.
+	MaxAge = 30 * 24 * time.Hour
+	// ChallengeTimeout is how long a user has to enter the second factor
+	// after the password was accepted.
+	ChallengeTimeout = 5 * time.Minute
+)
+
+// challengeAttempts limits the codes tried against one login challenge.
+const challengeAttempts = 5
+
 func GenerateSecureToken(length int64) (string, error) {
 	buffer := make([]byte, length)
 	_, err := rand.Read(buffer)
@@ -18,21 +34,79 @@ func GenerateSecureToken(length int64) (string, error) {
 	return base64.URLEncoding.EncodeToString(buffer)[:length], nil
 }
 
-func CreateSession(db *sql.DB, id int64) (string, error) {
+// CreateSession starts a session for the user. The user agent and address
+// are only recorded so users can recognise their sessions.
+func CreateSession(db *sql.DB, id int64, userAgent, ip string) (string, error) {
 	token, err := GenerateSecureToken(64)
 	if err != nil {
 		return "", err
 	}
 
-	if err := database.InsertToken(db, id, token); err != nil {
-		return "", nil
+	// Tokens starting like API keys would be checked as API keys
+	for strings.HasPrefix(token, APIKeyPrefix) {
+		if token, err = GenerateSecureToken(64); err != nil {
+			return "", err
+		}
+	}
+
+	if err := database.InsertToken(db, id, token, userAgent, ip); err != nil {
+		return "", err
 	}
 
 	return token, nil
 }
 
 func ValidateSession(db *sql.DB, token string) (int64, error) {
-	id, err := database.GetToken(db, token)
+	now := time.Now()
+	id, err := database.GetToken(db, token, now.Add(-MaxAge), now.Add(-IdleTimeout))
 
 	return id, err
 }
+
+// ListSessions returns the live sessions of the user.
+func ListSessions(db *sql.DB, id int64, currentToken string) ([]database.Session, error) {
+	now := time.Now()
+	return database.GetUserTokens(db, id, currentToken, now.Add(-MaxAge), now.Add(-IdleTimeout))
+}
+
+// SweepSessions deletes every expired session, login challenge and
+// forgotten lockout.
+func SweepSessions(db *sql.DB) (int64, error) {
+	now := time.Now()
+	if _, err := database.DeleteExpiredChallenges(db, now.Add(-ChallengeTimeout), challengeAttempts); err != nil {
+		return 0, err
+	}
+
+	if _, err := database.DeleteStaleLockouts(db, now.Add(-FailureWindow)); err != nil {
+		return 0, err
+	}
+
+	return database.DeleteExpiredTokens(db, now.Add(-MaxAge), now.Add(-IdleTimeout))
+}
+
+// CreateChallenge records a login whose password was accepted but which
+// still needs the second factor. The returned token identifies it in the
+// second step instead of the password.
+func CreateChallenge(db *sql.DB, id int64) (string, error) {
+	token, err := GenerateSecureToken(64)
+	if err != nil {
+		return "", err
+	}
+
+	if err := database.InsertChallenge(db, id, token); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ValidateChallenge returns the user waiting on the challenge. Each call
+// counts as an attempt, so codes cannot be guessed indefinitely.
+func ValidateChallenge(db *sql.DB, token string) (int64, error) {
+	return database.GetChallenge(db, token, time.Now().Add(-ChallengeTimeout), challengeAttempts)
+}
+
+// EndChallenge removes a challenge once the login completed.
+func EndChallenge(db *sql.DB, token string) {
+	database.DeleteChallenge(db, token)
+}
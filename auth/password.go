@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ResetTokenTTL is how long a password reset link stays valid.
+	ResetTokenTTL = time.Hour
+	// VerifyTokenTTL is how long an email verification link stays valid.
+	VerifyTokenTTL = 48 * time.Hour
+)
+
+// MinPasswordLength applies to every new password, at registration too.
+const MinPasswordLength = 8
+
+var ErrWeakPassword = errors.New("password is too short")
+
+// HashPassword validates and hashes a new password with the cost used
+// at registration.
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", ErrWeakPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// NewMailToken returns a token to send to the user, e.g. in a password reset
+// link or as an invite code, and the hash to store in its place.
+func NewMailToken() (string, string, error) {
+	token, err := GenerateSecureToken(43)
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, HashMailToken(token), nil
+}
+
+// HashMailToken returns the value stored in place of a token from
+// NewMailToken.
+func HashMailToken(token string) string {
+	return HashAPIKey(token)
+}
@@ -0,0 +1,100 @@
+package auth
+
+import (
+	"database/sql"
+	"time"
+
+	"server/database"
+)
+
+// Failed logins are counted per login name and per client address. Once a
+// key reaches its limit every further failure locks it for twice as long as
+// the previous one, up to LockoutMax.
+const (
+	LockoutLogin = "login"
+	LockoutIP    = "ip"
+)
+
+var (
+	// LoginAttempts is how many wrong passwords an account takes before it
+	// is locked. Addresses get IPAttempts, since several users may share one.
+	LoginAttempts int64 = 5
+	IPAttempts    int64 = 20
+	// LockoutBase is the first lock, LockoutMax the longest one.
+	LockoutBase = 30 * time.Second
+	LockoutMax  = time.Hour
+	// FailureWindow is how long failures are remembered.
+	FailureWindow = 24 * time.Hour
+)
+
+// LoginLockedUntil returns when the login may be tried again, the zero time
+// when neither the login nor the address are locked. Unknown logins are
+// tracked like existing ones so the answer does not reveal which exist.
+func LoginLockedUntil(db *sql.DB, login, ip string) (time.Time, error) {
+	loginLock, err := database.GetLockedUntil(db, LockoutLogin, login)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	ipLock, err := database.GetLockedUntil(db, LockoutIP, ip)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	lockedUntil := loginLock
+	if ipLock.After(lockedUntil) {
+		lockedUntil = ipLock
+	}
+
+	if !lockedUntil.After(time.Now()) {
+		return time.Time{}, nil
+	}
+
+	return lockedUntil, nil
+}
+
+// LoginFailed counts a failed attempt against the login and the address and
+// locks them when they reach their limit.
+func LoginFailed(db *sql.DB, login, ip string) error {
+	if err := addFailure(db, LockoutLogin, login, LoginAttempts); err != nil {
+		return err
+	}
+
+	return addFailure(db, LockoutIP, ip, IPAttempts)
+}
+
+func addFailure(db *sql.DB, kind, key string, limit int64) error {
+	failures, err := database.AddFailure(db, kind, key, time.Now().Add(-FailureWindow))
+	if err != nil {
+		return err
+	}
+
+	if failures < limit {
+		return nil
+	}
+
+	lock := LockoutMax
+	if shift := failures - limit; shift < 16 {
+		lock = min(LockoutBase<<shift, LockoutMax)
+	}
+
+	return database.SetLockedUntil(db, kind, key, time.Now().Add(lock))
+}
+
+// LoginSucceeded forgets the failures of the login. Those of the address are
+// kept, otherwise one valid account would let an attacker keep guessing
+// others from the same address.
+func LoginSucceeded(db *sql.DB, login string) error {
+	_, err := database.DeleteLockout(db, LockoutLogin, login)
+	return err
+}
+
+// ListLockouts returns logins and addresses with recent failures.
+func ListLockouts(db *sql.DB) ([]database.Lockout, error) {
+	return database.GetLockouts(db, time.Now().Add(-FailureWindow))
+}
+
+// Unlock clears the failures and any lock of the login or address.
+func Unlock(db *sql.DB, kind, key string) (bool, error) {
+	return database.DeleteLockout(db, kind, key)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"server/database"
+	"server/storage"
+)
+
+// blobLocks serialise storing and releasing blobs with the same checksum, so
+// a blob cannot be deleted while another upload decides to reuse it.
+var blobLocks [64]sync.Mutex
+
+func blobLock(checksum string) *sync.Mutex {
+	n, _ := strconv.ParseUint(checksum[:2], 16, 8)
+	return &blobLocks[int(n)%len(blobLocks)]
+}
+
+// validChecksum reports whether s looks like a hex encoded SHA-256 sum.
+func validChecksum(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// blobKey names the blob holding content with the given SHA-256 checksum.
+func blobKey(checksum string) string {
+	return "blobs/" + checksum[:2] + "/" + checksum
+}
+
+// putBlob stores the content unless a blob with the same checksum exists.
+// The caller must hold blobLock(checksum).
+func (app *app) putBlob(checksum string, r io.Reader, size int64) error {
+	_, err := app.Store.Stat(app.Ctx, blobKey(checksum))
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, storage.ErrNotExist) {
+		return err
+	}
+
+	return app.Store.Put(app.Ctx, blobKey(checksum), r, size)
+}
+
+// addFile stores the content and records the file if it fits into the quota
+// of the owner, together with its camera metadata. Files with the same
+// checksum share one blob, the triggers on files maintain blobs.refs.
+func (app *app) addFile(metadata database.AddFileParams, r io.ReadSeeker, size int64) (int64, error) {
+	// The content is at hand, read it before waiting for the locks
+	camera, err := readMetadata(r, &metadata)
+	if err != nil {
+		return 0, err
+	}
+	locate(&metadata)
+
+	userLock := quotaLock(metadata.OwnerID)
+	userLock.Lock()
+	defer userLock.Unlock()
+
+	if err := app.checkQuota(metadata.OwnerID, size); err != nil {
+		return 0, err
+	}
+
+	metadata.Size = size
+
+	lock := blobLock(metadata.Checksum)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := app.putBlob(metadata.Checksum, r, size); err != nil {
+		return 0, err
+	}
+
+	id, err := app.Query.AddFile(app.Ctx, metadata)
+	if err != nil {
+		if err := app.removeUnusedBlob(metadata.Checksum); err != nil {
+			log.Println(err)
+		}
+		return 0, err
+	}
+
+	app.addMetadata(id, camera)
+	app.makeThumbnailsLater(metadata.Checksum)
+
+	return id, nil
+}
+
+// linkFile records a file for content the user already stores, letting
+// clients skip sending it again. Only the user's own files are considered,
+// otherwise knowing a checksum would be enough to obtain someone's photo.
+func (app *app) linkFile(metadata database.AddFileParams) (int64, bool, error) {
+	userLock := quotaLock(metadata.OwnerID)
+	userLock.Lock()
+	defer userLock.Unlock()
+
+	lock := blobLock(metadata.Checksum)
+	lock.Lock()
+	defer lock.Unlock()
+
+	count, err := app.Query.HasFileWithChecksum(app.Ctx, database.HasFileWithChecksumParams{
+		OwnerID:  metadata.OwnerID,
+		Checksum: metadata.Checksum,
+	})
+	if err != nil || count == 0 {
+		return 0, false, err
+	}
+
+	// Every file counts against the quota, even when the blob is shared
+	info, err := app.Store.Stat(app.Ctx, blobKey(metadata.Checksum))
+	if err != nil {
+		return 0, false, err
+	}
+
+	if err := app.checkQuota(metadata.OwnerID, info.Size); err != nil {
+		return 0, false, err
+	}
+
+	metadata.Size = info.Size
+
+	camera, err := app.linkedMetadata(&metadata)
+	if err != nil {
+		return 0, false, err
+	}
+	locate(&metadata)
+
+	id, err := app.Query.AddFile(app.Ctx, metadata)
+	if err != nil {
+		return 0, false, err
+	}
+
+	app.addMetadata(id, camera)
+
+	return id, true, nil
+}
+
+// releaseBlob removes the blob once no file references it anymore. Call it
+// after deleting a file row.
+func (app *app) releaseBlob(checksum string) error {
+	lock := blobLock(checksum)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return app.removeUnusedBlob(checksum)
+}
+
+// removeUnusedBlob deletes the blob and its thumbnails if it has no
+// references, including when it was never recorded at all. The caller must
+// hold blobLock(checksum).
+func (app *app) removeUnusedBlob(checksum string) error {
+	refs, err := app.Query.GetBlobRefs(app.Ctx, checksum)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if refs > 0 {
+		return nil
+	}
+
+	if err := app.Store.Delete(app.Ctx, blobKey(checksum)); err != nil && !errors.Is(err, storage.ErrNotExist) {
+		return err
+	}
+
+	if err := app.removeThumbnails(checksum); err != nil {
+		return err
+	}
+
+	return app.Query.DeleteUnusedBlob(app.Ctx, checksum)
+}
+
+// collectBlobs periodically removes blobs whose files were deleted without
+// going through releaseBlob, e.g. when the storage failed or the server
+// stopped right after the files rows were deleted.
+func (app *app) collectBlobs(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		unused, err := app.Query.GetUnusedBlobs(app.Ctx)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		for _, checksum := range unused {
+			if err := app.releaseBlob(checksum); err != nil {
+				log.Println(err)
+			}
+		}
+
+		if len(unused) > 0 {
+			log.Printf("Cleanup: -- Removed %d unused blobs", len(unused))
+		}
+	}
+}
+
+// migrateLegacyFiles moves files stored under users/<login>/<hex id> by older
+// versions into content addressed blobs.
+func (app *app) migrateLegacyFiles() error {
+	legacy, err := app.Store.List(app.Ctx, "users/")
+	if err != nil {
+		return err
+	}
+
+	for _, info := range legacy {
+		parts := strings.Split(info.Key, "/")
+		id, err := strconv.ParseInt(parts[len(parts)-1], 16, 64)
+		if err != nil {
+			log.Printf("Skipping unknown blob: %s", info.Key)
+			continue
+		}
+
+		file, err := app.Query.GetFile(app.Ctx, id)
+		if err != nil {
+			log.Printf("Skipping blob %s: %v", info.Key, err)
+			continue
+		}
+
+		blob, err := app.Store.Get(app.Ctx, info.Key)
+		if err != nil {
+			return err
+		}
+
+		lock := blobLock(file.Checksum)
+		lock.Lock()
+		err = app.putBlob(file.Checksum, blob, info.Size)
+		lock.Unlock()
+		blob.Close()
+		if err != nil {
+			return err
+		}
+
+		if err := app.Store.Delete(app.Ctx, info.Key); err != nil {
+			return err
+		}
+	}
+
+	if len(legacy) > 0 {
+		log.Printf("Migrated %d files to blob storage", len(legacy))
+	}
+
+	return nil
+}
@@ -4,22 +4,88 @@ import (
 	"database/sql"
 	"errors"
 	"log"
+	"time"
 )
 
+type Lockout struct {
+	Kind        string    `json:"kind"`
+	Key         string    `json:"key"`
+	Failures    int64     `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+type Session struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
 func SetupCache(db *sql.DB) error {
 	_, err := db.Exec(`
-    CREATE TABLE sessions ( id INTEGER PRIMARY KEY AUTOINCREMENT,
+    CREATE TABLE IF NOT EXISTS sessions ( id INTEGER PRIMARY KEY AUTOINCREMENT,
+        user_id INTEGER NOT NULL,
+        token TEXT NOT NULL UNIQUE,
+        created_at INTEGER NOT NULL,   -- Unix time
+        last_seen_at INTEGER NOT NULL, -- Unix time of the last authenticated request
+        user_agent TEXT NOT NULL DEFAULT '',
+        ip TEXT NOT NULL DEFAULT ''
+    )`)
+	if err != nil {
+		return err
+	}
+
+	// Logins waiting for the second factor
+	_, err = db.Exec(`
+    CREATE TABLE IF NOT EXISTS challenges ( token TEXT PRIMARY KEY NOT NULL,
         user_id INTEGER NOT NULL,
-        token TEXT NOT NULL UNIQUE
+        created_at INTEGER NOT NULL,   -- Unix time
+        attempts INTEGER NOT NULL DEFAULT 0
     )`)
+	if err != nil {
+		return err
+	}
 
-	return err
+	// Failed logins per account and per client address
+	_, err = db.Exec(`
+    CREATE TABLE IF NOT EXISTS lockouts ( kind TEXT NOT NULL, -- "login" or "ip"
+        key TEXT NOT NULL,
+        failures INTEGER NOT NULL DEFAULT 0,
+        last_failure INTEGER NOT NULL,  -- Unix time
+        locked_until INTEGER NOT NULL DEFAULT 0, -- Unix time
+        PRIMARY KEY (kind, key)
+    )`)
+	if err != nil {
+		return err
+	}
+
+	// Persistent session databases may predate these columns
+	for _, column := range []string{"user_agent", "ip"} {
+		var count int
+		err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('sessions') WHERE name = ?`, column).Scan(&count)
+		if err != nil {
+			return err
+		}
+
+		if count == 0 {
+			if _, err := db.Exec(`ALTER TABLE sessions ADD COLUMN ` + column + ` TEXT NOT NULL DEFAULT ''`); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
 }
 
-func InsertToken(db *sql.DB, id int64, token string) error {
-	query := `INSERT INTO sessions (user_id, token) VALUES (?, ?)`
+func InsertToken(db *sql.DB, id int64, token, userAgent, ip string) error {
+	query := `INSERT INTO sessions (user_id, token, created_at, last_seen_at, user_agent, ip) VALUES (?, ?, ?, ?, ?, ?)`
 
-	_, err := db.Exec(query, id, token)
+	now := time.Now().Unix()
+	_, err := db.Exec(query, id, token, now, now, userAgent, ip)
 	if err != nil {
 		log.Println(err)
 		return err
@@ -28,12 +94,16 @@ func InsertToken(db *sql.DB, id int64, token string) error {
 	return nil
 }
 
-func GetToken(db *sql.DB, token string) (int64, error) {
+// GetToken returns the owner of the session and marks it as used. Sessions
+// created before createdAfter or last used before seenAfter have expired
+// and are reported as invalid.
+func GetToken(db *sql.DB, token string, createdAfter, seenAfter time.Time) (int64, error) {
 	var id int64
 
 	err := db.QueryRow(`
-        SELECT user_id FROM sessions
-        WHERE token = ?`, token).Scan(&id)
+        UPDATE sessions SET last_seen_at = ?
+        WHERE token = ? AND created_at > ? AND last_seen_at > ?
+        RETURNING user_id`, time.Now().Unix(), token, createdAfter.Unix(), seenAfter.Unix()).Scan(&id)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return -1, errors.New("invalid session")
@@ -47,3 +117,211 @@ func GetToken(db *sql.DB, token string) (int64, error) {
 func DeleteToken(db *sql.DB, token string) {
 	_, _ = db.Exec("DELETE FROM sessions WHERE token = ?", token)
 }
+
+// GetUserTokens lists the live sessions of a user, marking the one that
+// uses currentToken.
+func GetUserTokens(db *sql.DB, userID int64, currentToken string, createdAfter, seenAfter time.Time) ([]Session, error) {
+	rows, err := db.Query(`
+        SELECT id, user_id, user_agent, ip, created_at, last_seen_at, token = ?
+        FROM sessions
+        WHERE user_id = ? AND created_at > ? AND last_seen_at > ?
+        ORDER BY last_seen_at DESC`, currentToken, userID, createdAfter.Unix(), seenAfter.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		var createdAt, lastSeenAt int64
+		if err := rows.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IP, &createdAt, &lastSeenAt, &session.Current); err != nil {
+			return nil, err
+		}
+		session.CreatedAt = time.Unix(createdAt, 0).UTC()
+		session.LastSeenAt = time.Unix(lastSeenAt, 0).UTC()
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// DeleteTokenByID removes one session of the user and reports whether it
+// existed.
+func DeleteTokenByID(db *sql.DB, id, userID int64) (bool, error) {
+	result, err := db.Exec("DELETE FROM sessions WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
+
+// DeleteUserTokens removes every session of the user except the one using
+// keepToken, which may be empty.
+func DeleteUserTokens(db *sql.DB, userID int64, keepToken string) (int64, error) {
+	result, err := db.Exec("DELETE FROM sessions WHERE user_id = ? AND token != ?", userID, keepToken)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteExpiredTokens removes sessions that GetToken would no longer accept.
+func DeleteExpiredTokens(db *sql.DB, createdBefore, seenBefore time.Time) (int64, error) {
+	result, err := db.Exec(`
+        DELETE FROM sessions
+        WHERE created_at <= ? OR last_seen_at <= ?`, createdBefore.Unix(), seenBefore.Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+func InsertChallenge(db *sql.DB, id int64, token string) error {
+	_, err := db.Exec("INSERT INTO challenges (user_id, token, created_at) VALUES (?, ?, ?)", id, token, time.Now().Unix())
+	return err
+}
+
+// GetChallenge returns the user waiting on the challenge and counts the
+// attempt. Challenges created before createdAfter or attempted more than
+// maxAttempts times are reported as invalid.
+func GetChallenge(db *sql.DB, token string, createdAfter time.Time, maxAttempts int) (int64, error) {
+	var id int64
+
+	err := db.QueryRow(`
+        UPDATE challenges SET attempts = attempts + 1
+        WHERE token = ? AND created_at > ? AND attempts < ?
+        RETURNING user_id`, token, createdAfter.Unix(), maxAttempts).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return -1, errors.New("invalid challenge")
+		}
+		return -1, err
+	}
+
+	return id, nil
+}
+
+func DeleteChallenge(db *sql.DB, token string) {
+	_, _ = db.Exec("DELETE FROM challenges WHERE token = ?", token)
+}
+
+// DeleteUserChallenges removes every login of the user waiting for the
+// second factor.
+func DeleteUserChallenges(db *sql.DB, userID int64) error {
+	_, err := db.Exec("DELETE FROM challenges WHERE user_id = ?", userID)
+	return err
+}
+
+// DeleteExpiredChallenges removes challenges that GetChallenge would no
+// longer accept.
+func DeleteExpiredChallenges(db *sql.DB, createdBefore time.Time, maxAttempts int) (int64, error) {
+	result, err := db.Exec(`
+        DELETE FROM challenges
+        WHERE created_at <= ? OR attempts >= ?`, createdBefore.Unix(), maxAttempts)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// GetLockedUntil returns when the lock on key ends, the zero time when it
+// is not locked.
+func GetLockedUntil(db *sql.DB, kind, key string) (time.Time, error) {
+	var lockedUntil int64
+
+	err := db.QueryRow(`
+        SELECT locked_until FROM lockouts
+        WHERE kind = ? AND key = ?`, kind, key).Scan(&lockedUntil)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+
+	if lockedUntil == 0 {
+		return time.Time{}, nil
+	}
+
+	return time.Unix(lockedUntil, 0), nil
+}
+
+// AddFailure counts a failed login against key and returns the number of
+// failures since resetAfter. Older failures are forgotten.
+func AddFailure(db *sql.DB, kind, key string, resetAfter time.Time) (int64, error) {
+	var failures int64
+
+	err := db.QueryRow(`
+        INSERT INTO lockouts (kind, key, failures, last_failure) VALUES (?, ?, 1, ?)
+        ON CONFLICT (kind, key) DO UPDATE SET
+            failures = CASE WHEN last_failure > ? THEN failures + 1 ELSE 1 END,
+            last_failure = excluded.last_failure
+        RETURNING failures`, kind, key, time.Now().Unix(), resetAfter.Unix()).Scan(&failures)
+
+	return failures, err
+}
+
+func SetLockedUntil(db *sql.DB, kind, key string, lockedUntil time.Time) error {
+	_, err := db.Exec("UPDATE lockouts SET locked_until = ? WHERE kind = ? AND key = ?", lockedUntil.Unix(), kind, key)
+	return err
+}
+
+// DeleteLockout forgets the failures of key and reports whether there were
+// any.
+func DeleteLockout(db *sql.DB, kind, key string) (bool, error) {
+	result, err := db.Exec("DELETE FROM lockouts WHERE kind = ? AND key = ?", kind, key)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := result.RowsAffected()
+	return deleted > 0, err
+}
+
+// GetLockouts lists keys with failures since resetAfter or a running lock.
+func GetLockouts(db *sql.DB, resetAfter time.Time) ([]Lockout, error) {
+	rows, err := db.Query(`
+        SELECT kind, key, failures, last_failure, locked_until
+        FROM lockouts
+        WHERE last_failure > ? OR locked_until > ?
+        ORDER BY last_failure DESC`, resetAfter.Unix(), time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lockouts := []Lockout{}
+	for rows.Next() {
+		var lockout Lockout
+		var lastFailure, lockedUntil int64
+		if err := rows.Scan(&lockout.Kind, &lockout.Key, &lockout.Failures, &lastFailure, &lockedUntil); err != nil {
+			return nil, err
+		}
+		lockout.LastFailure = time.Unix(lastFailure, 0).UTC()
+		if lockedUntil != 0 {
+			lockout.LockedUntil = time.Unix(lockedUntil, 0).UTC()
+		}
+		lockouts = append(lockouts, lockout)
+	}
+
+	return lockouts, rows.Err()
+}
+
+// DeleteStaleLockouts removes keys whose failures were forgotten and whose
+// lock has ended.
+func DeleteStaleLockouts(db *sql.DB, resetAfter time.Time) (int64, error) {
+	result, err := db.Exec(`
+        DELETE FROM lockouts
+        WHERE last_failure <= ? AND locked_until <= ?`, resetAfter.Unix(), time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
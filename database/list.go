@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSort = errors.New("invalid sort")
+
+// fileSorts are the orders ListFiles supports. column is what files are
+// sorted by, cursor how a sort key from the previous page is compared to it.
+var fileSorts = map[string]struct{ column, cursor string }{
+	"created_at": {"COALESCE(files.created_at, '')", "?"},
+	"name":       {"files.file_name COLLATE NOCASE", "?"},
+	"size":       {"files.size", "CAST(? AS INTEGER)"},
+}
+
+type ListFilesParams struct {
+	OwnerID int64
+	// Sort is created_at, name or size. Files with the same key are
+	// ordered by id.
+	Sort string
+	Desc bool
+	// From and To limit created_at to [From, To), zero times are open ends.
+	From time.Time
+	To   time.Time
+	// AlbumID limits the files to one album when it is not 0.
+	AlbumID int64
+	// Cond and Args are an optional condition on files.id from the tagquery
+	// package.
+	Cond string
+	Args []any
+	// AfterKey and AfterID are the SortKey and id of the last file of the
+	// previous page. An AfterID of 0 starts at the first page.
+	AfterKey string
+	AfterID  int64
+	Limit    int64
+}
+
+type ListFilesRow struct {
+	File
+	// Tags are the paths of the tags of the file, sorted.
+	Tags []string `json:"tags"`
+	// Metadata is what was read from EXIF and XMP, nil when there was none.
+	Metadata *Filemetadata `json:"metadata"`
+	SortKey  string        `json:"-"`
+}
+
+// ListFiles returns a page of the files of the owner together with their
+// tags and metadata in one query.
+func (q *Queries) ListFiles(ctx context.Context, arg ListFilesParams) ([]ListFilesRow, error) {
+	sort, ok := fileSorts[arg.Sort]
+	if !ok {
+		return nil, ErrInvalidSort
+	}
+
+	order, compare := "ASC", ">"
+	if arg.Desc {
+		order, compare = "DESC", "<"
+	}
+
+	var query strings.Builder
+	query.WriteString(`WITH RECURSIVE tagPaths (id, path) AS (
+  SELECT id, name FROM tags
+  WHERE parent_id = 0 AND (owner_id = ? OR owner_id = 0)
+  UNION ALL
+  SELECT tags.id, tagPaths.path || '/' || tags.name FROM tags
+  JOIN tagPaths ON tags.parent_id = tagPaths.id
+)
+SELECT files.id, files.owner_id, files.file_name, files.title, files.description, files.coordinates, files.checksum, files.created_at, files.size, files.latitude, files.longitude,
+  json_group_array(tagPaths.path) FILTER (WHERE tagPaths.path IS NOT NULL) AS tags,
+  fileMetadata.file_id, fileMetadata.taken_at, fileMetadata.camera_make, fileMetadata.camera_model, fileMetadata.lens, fileMetadata.exposure_time, fileMetadata.f_number, fileMetadata.iso, fileMetadata.focal_length, fileMetadata.orientation, fileMetadata.latitude, fileMetadata.longitude,
+  ` + sort.column + ` AS sort_key
+FROM files
+LEFT JOIN fileTags ON fileTags.file_id = files.id
+LEFT JOIN tagPaths ON tagPaths.id = fileTags.tag_id
+LEFT JOIN fileMetadata ON fileMetadata.file_id = files.id
+WHERE files.owner_id = ?`)
+	args := []any{arg.OwnerID, arg.OwnerID}
+
+	if !arg.From.IsZero() {
+		query.WriteString("\n  AND files.created_at >= ?")
+		args = append(args, arg.From.UTC().Format(sqliteTime))
+	}
+	if !arg.To.IsZero() {
+		query.WriteString("\n  AND files.created_at < ?")
+		args = append(args, arg.To.UTC().Format(sqliteTime))
+	}
+	if arg.AlbumID != 0 {
+		query.WriteString("\n  AND files.id IN (SELECT file_id FROM fileAlbum WHERE album_id = ?)")
+		args = append(args, arg.AlbumID)
+	}
+	if arg.Cond != "" {
+		query.WriteString("\n  AND (" + arg.Cond + ")")
+		args = append(args, arg.Args...)
+	}
+	if arg.AfterID != 0 {
+		query.WriteString("\n  AND (" + sort.column + " " + compare + " " + sort.cursor +
+			" OR (" + sort.column + " = " + sort.cursor + " AND files.id " + compare + " ?))")
+		args = append(args, arg.AfterKey, arg.AfterKey, arg.AfterID)
+	}
+
+	query.WriteString("\nGROUP BY files.id\nORDER BY sort_key " + order + ", files.id " + order + "\nLIMIT ?")
+	args = append(args, arg.Limit)
+
+	rows, err := q.db.QueryContext(ctx, query.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListFilesRow
+	for rows.Next() {
+		var i ListFilesRow
+		var tags string
+		var metadata Filemetadata
+		var metadataID sql.NullInt64
+		if err := rows.Scan(
+			&i.ID,
+			&i.OwnerID,
+			&i.FileName,
+			&i.Title,
+			&i.Description,
+			&i.Coordinates,
+			&i.Checksum,
+			&i.CreatedAt,
+			&i.Size,
+			&i.Latitude,
+			&i.Longitude,
+			&tags,
+			&metadataID,
+			&metadata.TakenAt,
+			&metadata.CameraMake,
+			&metadata.CameraModel,
+			&metadata.Lens,
+			&metadata.ExposureTime,
+			&metadata.FNumber,
+			&metadata.Iso,
+			&metadata.FocalLength,
+			&metadata.Orientation,
+			&metadata.Latitude,
+			&metadata.Longitude,
+			&i.SortKey,
+		); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(tags), &i.Tags); err != nil {
+			return nil, err
+		}
+		slices.Sort(i.Tags)
+		if metadataID.Valid {
+			metadata.FileID = metadataID.Int64
+			i.Metadata = &metadata
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,66 @@
+package database
+
+import "context"
+
+// getFilesInBox looks the box up in the fileLocations R*Tree index first, the
+// index stores 32-bit floats so the exact columns are checked after it.
+const getFilesInBox = `SELECT files.id, files.owner_id, files.file_name, files.title, files.description, files.coordinates, files.checksum, files.created_at, files.size, files.latitude, files.longitude FROM fileLocations
+JOIN files ON files.id = fileLocations.id
+WHERE files.owner_id = ?1
+  AND fileLocations.max_lat >= ?2 AND fileLocations.min_lat <= ?3
+  AND fileLocations.max_lon >= ?4 AND fileLocations.min_lon <= ?5
+  AND files.latitude BETWEEN ?2 AND ?3
+  AND files.longitude BETWEEN ?4 AND ?5
+ORDER BY files.id
+`
+
+type GetFilesInBoxParams struct {
+	OwnerID int64   `json:"owner_id"`
+	MinLat  float64 `json:"min_lat"`
+	MaxLat  float64 `json:"max_lat"`
+	MinLon  float64 `json:"min_lon"`
+	MaxLon  float64 `json:"max_lon"`
+}
+
+// GetFilesInBox returns the located files of the owner inside the box. It is
+// written by hand as sqlc does not know virtual tables.
+func (q *Queries) GetFilesInBox(ctx context.Context, arg GetFilesInBoxParams) ([]File, error) {
+	rows, err := q.db.QueryContext(ctx, getFilesInBox,
+		arg.OwnerID,
+		arg.MinLat,
+		arg.MaxLat,
+		arg.MinLon,
+		arg.MaxLon,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []File
+	for rows.Next() {
+		var i File
+		if err := rows.Scan(
+			&i.ID,
+			&i.OwnerID,
+			&i.FileName,
+			&i.Title,
+			&i.Description,
+			&i.Coordinates,
+			&i.Checksum,
+			&i.CreatedAt,
+			&i.Size,
+			&i.Latitude,
+			&i.Longitude,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
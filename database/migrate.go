@@ -0,0 +1,354 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// migrations upgrade databases created from an older schema.sql, entry i
+// moves the database from user_version i to i+1. New databases are created
+// from schema.sql directly and start at the latest version, so every entry
+// has to leave the database in the same state as the current schema.sql.
+var migrations = []string{
+	// 1: resumable uploads and content addressed blobs
+	`
+    CREATE TABLE IF NOT EXISTS uploads (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      owner_id INTEGER NOT NULL,
+      file_name TEXT NOT NULL,
+      title TEXT,
+      description TEXT,
+      coordinates TEXT,
+      tags TEXT,
+      checksum TEXT,
+      length INTEGER NOT NULL,
+      upload_offset INTEGER NOT NULL DEFAULT 0,
+      created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+      updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+      FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+
+    CREATE TABLE blobs (
+      checksum TEXT PRIMARY KEY NOT NULL,
+      refs INTEGER NOT NULL DEFAULT 0
+    );
+
+    INSERT INTO blobs (checksum, refs)
+    SELECT checksum, COUNT(*) FROM files GROUP BY checksum;
+
+    CREATE TRIGGER files_blob_ref AFTER INSERT ON files
+    BEGIN
+      INSERT INTO blobs (checksum, refs) VALUES (NEW.checksum, 1)
+      ON CONFLICT (checksum) DO UPDATE SET refs = refs + 1;
+    END;
+
+    CREATE TRIGGER files_blob_unref AFTER DELETE ON files
+    BEGIN
+      UPDATE blobs SET refs = refs - 1 WHERE checksum = OLD.checksum;
+    END;
+    `,
+	// 2: personal API keys
+	`
+    CREATE TABLE apiKeys (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      user_id INTEGER NOT NULL,
+      name TEXT NOT NULL,
+      key_hash TEXT NOT NULL UNIQUE,
+      scopes TEXT NOT NULL,
+      created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+      expires_at DATETIME,
+      last_used_at DATETIME,
+      FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+    `,
+	// 3: two-factor authentication
+	`
+    CREATE TABLE totp (
+      user_id INTEGER PRIMARY KEY NOT NULL,
+      secret TEXT NOT NULL,
+      confirmed INTEGER NOT NULL DEFAULT 0,
+      last_step INTEGER NOT NULL DEFAULT 0,
+      created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+      FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+
+    CREATE TABLE recoveryCodes (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      user_id INTEGER NOT NULL,
+      code_hash TEXT NOT NULL,
+      used_at DATETIME,
+      FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+    `,
+	// 4: password reset tokens
+	`
+    CREATE TABLE passwordResets (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      user_id INTEGER NOT NULL,
+      token_hash TEXT NOT NULL UNIQUE,
+      created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+      expires_at DATETIME NOT NULL,
+      used_at DATETIME,
+      FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+    `,
+	// 5: registration modes
+	`
+    ALTER TABLE users ADD COLUMN active INTEGER NOT NULL DEFAULT 1;
+    ALTER TABLE users ADD COLUMN email_verified INTEGER NOT NULL DEFAULT 0;
+
+    CREATE TABLE emailVerifications (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      user_id INTEGER NOT NULL,
+      token_hash TEXT NOT NULL UNIQUE,
+      created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+      expires_at DATETIME NOT NULL,
+      FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+    );
+
+    CREATE TABLE invites (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      code_hash TEXT NOT NULL UNIQUE,
+      created_by INTEGER,
+      note TEXT,
+      max_uses INTEGER NOT NULL DEFAULT 1,
+      uses INTEGER NOT NULL DEFAULT 0,
+      created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+      expires_at DATETIME,
+      FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+    );
+    `,
+	// 6: storage quotas, sizes of existing files are filled in on startup
+	`
+    ALTER TABLE users ADD COLUMN quota INTEGER;
+    ALTER TABLE files ADD COLUMN size INTEGER NOT NULL DEFAULT 0;
+
+    UPDATE files SET size = -1;
+    `,
+	// 7: tags belong to users, a tag used by several users is split into
+	// one per owner. The first owner keeps the id, tags on no file are gone.
+	`
+    DELETE FROM fileTags WHERE file_id NOT IN (SELECT id FROM files);
+
+    CREATE TEMP TABLE tagOwners AS
+    SELECT DISTINCT fileTags.tag_id AS tag_id, files.owner_id AS owner_id
+    FROM fileTags
+    JOIN files ON files.id = fileTags.file_id;
+
+    CREATE TABLE ownedTags (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      owner_id INTEGER NOT NULL DEFAULT 0,
+      name TEXT NOT NULL,
+      UNIQUE (owner_id, name)
+    );
+
+    INSERT INTO ownedTags (id, owner_id, name)
+    SELECT tags.id, MIN(tagOwners.owner_id), tags.name
+    FROM tags
+    JOIN tagOwners ON tagOwners.tag_id = tags.id
+    GROUP BY tags.id;
+
+    INSERT INTO ownedTags (owner_id, name)
+    SELECT tagOwners.owner_id, tags.name
+    FROM tagOwners
+    JOIN tags ON tags.id = tagOwners.tag_id
+    JOIN ownedTags ON ownedTags.id = tags.id
+    WHERE tagOwners.owner_id <> ownedTags.owner_id;
+
+    UPDATE fileTags SET tag_id = (
+      SELECT ownedTags.id
+      FROM files, tags, ownedTags
+      WHERE files.id = fileTags.file_id
+        AND tags.id = fileTags.tag_id
+        AND ownedTags.owner_id = files.owner_id
+        AND ownedTags.name = tags.name
+    );
+
+    DROP TABLE tagOwners;
+    DROP TABLE tags;
+    ALTER TABLE ownedTags RENAME TO tags;
+    `,
+	// 8: nested tags, names with slashes are split into paths on startup
+	`
+    CREATE TABLE nestedTags (
+      id INTEGER PRIMARY KEY AUTOINCREMENT,
+      owner_id INTEGER NOT NULL DEFAULT 0,
+      parent_id INTEGER NOT NULL DEFAULT 0,
+      name TEXT NOT NULL,
+      UNIQUE (owner_id, parent_id, name)
+    );
+
+    INSERT INTO nestedTags (id, owner_id, name)
+    SELECT id, owner_id, name FROM tags;
+
+    DROP TABLE tags;
+    ALTER TABLE nestedTags RENAME TO tags;
+    `,
+	// 9: full-text search over files
+	`
+    CREATE VIRTUAL TABLE fileSearch USING fts5(
+      title, description, file_name,
+      content = 'files', content_rowid = 'id',
+      tokenize = 'unicode61 remove_diacritics 2',
+      prefix = '2 3'
+    );
+
+    CREATE TRIGGER files_search_insert AFTER INSERT ON files
+    BEGIN
+      INSERT INTO fileSearch (rowid, title, description, file_name)
+      VALUES (NEW.id, NEW.title, NEW.description, NEW.file_name);
+    END;
+
+    CREATE TRIGGER files_search_delete AFTER DELETE ON files
+    BEGIN
+      INSERT INTO fileSearch (fileSearch, rowid, title, description, file_name)
+      VALUES ('delete', OLD.id, OLD.title, OLD.description, OLD.file_name);
+    END;
+
+    CREATE TRIGGER files_search_update AFTER UPDATE OF title, description, file_name ON files
+    BEGIN
+      INSERT INTO fileSearch (fileSearch, rowid, title, description, file_name)
+      VALUES ('delete', OLD.id, OLD.title, OLD.description, OLD.file_name);
+      INSERT INTO fileSearch (rowid, title, description, file_name)
+      VALUES (NEW.id, NEW.title, NEW.description, NEW.file_name);
+    END;
+
+    INSERT INTO fileSearch (fileSearch) VALUES ('rebuild');
+    `,
+	// 10: parsed coordinates and a spatial index, locateFiles fills them in
+	// on startup
+	`
+    ALTER TABLE files ADD COLUMN latitude REAL;
+    ALTER TABLE files ADD COLUMN longitude REAL;
+
+    CREATE VIRTUAL TABLE fileLocations USING rtree(
+      id,
+      min_lat, max_lat,
+      min_lon, max_lon
+    );
+
+    CREATE TRIGGER files_location_insert AFTER INSERT ON files
+    WHEN NEW.latitude IS NOT NULL AND NEW.longitude IS NOT NULL
+    BEGIN
+      INSERT INTO fileLocations (id, min_lat, max_lat, min_lon, max_lon)
+      VALUES (NEW.id, NEW.latitude, NEW.latitude, NEW.longitude, NEW.longitude);
+    END;
+
+    CREATE TRIGGER files_location_update AFTER UPDATE OF latitude, longitude ON files
+    BEGIN
+      DELETE FROM fileLocations WHERE id = OLD.id;
+      INSERT INTO fileLocations (id, min_lat, max_lat, min_lon, max_lon)
+      SELECT NEW.id, NEW.latitude, NEW.latitude, NEW.longitude, NEW.longitude
+      WHERE NEW.latitude IS NOT NULL AND NEW.longitude IS NOT NULL;
+    END;
+
+    CREATE TRIGGER files_location_delete AFTER DELETE ON files
+    BEGIN
+      DELETE FROM fileLocations WHERE id = OLD.id;
+    END;
+    `,
+	// 11: camera metadata read on upload
+	`
+    CREATE TABLE fileMetadata (
+      file_id INTEGER PRIMARY KEY NOT NULL,
+      taken_at DATETIME,
+      camera_make TEXT,
+      camera_model TEXT,
+      lens TEXT,
+      exposure_time REAL,
+      f_number REAL,
+      iso INTEGER,
+      focal_length REAL,
+      orientation INTEGER,
+      FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE CASCADE
+    );
+
+    CREATE TRIGGER files_metadata_delete AFTER DELETE ON files
+    BEGIN
+      DELETE FROM fileMetadata WHERE file_id = OLD.id;
+    END;
+    `,
+	// 12: shares, tags, albums and covers go with their file, the ones left
+	// by files deleted before are removed
+	`
+    CREATE TRIGGER files_links_delete AFTER DELETE ON files
+    BEGIN
+      DELETE FROM fileGuestShares WHERE file_id = OLD.id;
+      DELETE FROM fileTags WHERE file_id = OLD.id;
+      DELETE FROM fileAlbum WHERE file_id = OLD.id;
+      UPDATE album SET cover_id = NULL WHERE cover_id = OLD.id;
+    END;
+
+    DELETE FROM fileGuestShares WHERE file_id NOT IN (SELECT id FROM files);
+    DELETE FROM fileTags WHERE file_id NOT IN (SELECT id FROM files);
+    DELETE FROM fileAlbum WHERE file_id NOT IN (SELECT id FROM files);
+    UPDATE album SET cover_id = NULL WHERE cover_id NOT IN (SELECT id FROM files);
+    `,
+	// 13: GPS positions of camera metadata, files read before have none
+	`
+    ALTER TABLE fileMetadata ADD COLUMN latitude REAL;
+    ALTER TABLE fileMetadata ADD COLUMN longitude REAL;
+    `,
+	// 14: accounts disabled by an admin, which used to share active with
+	// unverified ones. Inactive accounts that are verified or were never
+	// sent a verification were disabled.
+	`
+    ALTER TABLE users ADD COLUMN disabled INTEGER NOT NULL DEFAULT 0;
+
+    UPDATE users SET disabled = 1, active = 1
+    WHERE active = 0
+      AND (email_verified = 1 OR id NOT IN (SELECT user_id FROM emailVerifications));
+    `,
+}
+
+// Migrate creates the schema on an empty database or applies the pending
+// migrations to an existing one.
+func Migrate(db *sql.DB, schema string) error {
+	var tables int
+	err := db.QueryRow(`
+        SELECT COUNT(*) FROM sqlite_master
+        WHERE type = 'table' AND name = 'users'`).Scan(&tables)
+	if err != nil {
+		return err
+	}
+
+	if tables == 0 {
+		if _, err := db.Exec(schema); err != nil {
+			return err
+		}
+
+		_, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(migrations)))
+		return err
+	}
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(migrations[version]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+
+		log.Printf("Database migrated to version %d", version+1)
+	}
+
+	return nil
+}
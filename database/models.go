@@ -5,6 +5,8 @@
 package database
 
 import (
+	"time"
+
 	"server/types"
 )
 
@@ -15,15 +17,42 @@ type Album struct {
 	Title   types.JSONNullString `json:"title"`
 }
 
+type Apikey struct {
+	ID         int64              `json:"id"`
+	UserID     int64              `json:"user_id"`
+	Name       string             `json:"name"`
+	KeyHash    string             `json:"key_hash"`
+	Scopes     string             `json:"scopes"`
+	CreatedAt  types.JSONNullTime `json:"created_at"`
+	ExpiresAt  types.JSONNullTime `json:"expires_at"`
+	LastUsedAt types.JSONNullTime `json:"last_used_at"`
+}
+
+type Blob struct {
+	Checksum string `json:"checksum"`
+	Refs     int64  `json:"refs"`
+}
+
+type Emailverification struct {
+	ID        int64              `json:"id"`
+	UserID    int64              `json:"user_id"`
+	TokenHash string             `json:"token_hash"`
+	CreatedAt types.JSONNullTime `json:"created_at"`
+	ExpiresAt time.Time          `json:"expires_at"`
+}
+
 type File struct {
-	ID          int64                `json:"id"`
-	OwnerID     int64                `json:"owner_id"`
-	FileName    string               `json:"file_name"`
-	Title       types.JSONNullString `json:"title"`
-	Description types.JSONNullString `json:"description"`
-	Coordinates types.JSONNullString `json:"coordinates"`
-	Checksum    string               `json:"checksum"`
-	CreatedAt   types.JSONNullTime   `json:"created_at"`
+	ID          int64                 `json:"id"`
+	OwnerID     int64                 `json:"owner_id"`
+	FileName    string                `json:"file_name"`
+	Title       types.JSONNullString  `json:"title"`
+	Description types.JSONNullString  `json:"description"`
+	Coordinates types.JSONNullString  `json:"coordinates"`
+	Checksum    string                `json:"checksum"`
+	CreatedAt   types.JSONNullTime    `json:"created_at"`
+	Size        int64                 `json:"size"`
+	Latitude    types.JSONNullFloat64 `json:"latitude"`
+	Longitude   types.JSONNullFloat64 `json:"longitude"`
 }
 
 type Filealbum struct {
@@ -40,21 +69,98 @@ type Fileguestshare struct {
 	MaxUses   types.JSONNullInt64 `json:"max_uses"`
 }
 
+type Filemetadata struct {
+	FileID       int64                 `json:"file_id"`
+	TakenAt      types.JSONNullTime    `json:"taken_at"`
+	CameraMake   types.JSONNullString  `json:"camera_make"`
+	CameraModel  types.JSONNullString  `json:"camera_model"`
+	Lens         types.JSONNullString  `json:"lens"`
+	ExposureTime types.JSONNullFloat64 `json:"exposure_time"`
+	FNumber      types.JSONNullFloat64 `json:"f_number"`
+	Iso          types.JSONNullInt64   `json:"iso"`
+	FocalLength  types.JSONNullFloat64 `json:"focal_length"`
+	Orientation  types.JSONNullInt64   `json:"orientation"`
+	Latitude     types.JSONNullFloat64 `json:"latitude"`
+	Longitude    types.JSONNullFloat64 `json:"longitude"`
+}
+
+type Filesearch struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	FileName    string `json:"file_name"`
+}
+
 type Filetag struct {
 	FileID int64 `json:"file_id"`
 	TagID  int64 `json:"tag_id"`
 }
 
+type Invite struct {
+	ID        int64                `json:"id"`
+	CodeHash  string               `json:"code_hash"`
+	CreatedBy types.JSONNullInt64  `json:"created_by"`
+	Note      types.JSONNullString `json:"note"`
+	MaxUses   int64                `json:"max_uses"`
+	Uses      int64                `json:"uses"`
+	CreatedAt types.JSONNullTime   `json:"created_at"`
+	ExpiresAt types.JSONNullTime   `json:"expires_at"`
+}
+
+type Passwordreset struct {
+	ID        int64              `json:"id"`
+	UserID    int64              `json:"user_id"`
+	TokenHash string             `json:"token_hash"`
+	CreatedAt types.JSONNullTime `json:"created_at"`
+	ExpiresAt time.Time          `json:"expires_at"`
+	UsedAt    types.JSONNullTime `json:"used_at"`
+}
+
+type Recoverycode struct {
+	ID       int64              `json:"id"`
+	UserID   int64              `json:"user_id"`
+	CodeHash string             `json:"code_hash"`
+	UsedAt   types.JSONNullTime `json:"used_at"`
+}
+
 type Tag struct {
-	ID   int64  `json:"id"`
-	Name string `json:"name"`
+	ID       int64  `json:"id"`
+	OwnerID  int64  `json:"owner_id"`
+	ParentID int64  `json:"parent_id"`
+	Name     string `json:"name"`
+}
+
+type Totp struct {
+	UserID    int64              `json:"user_id"`
+	Secret    string             `json:"secret"`
+	Confirmed int64              `json:"confirmed"`
+	LastStep  int64              `json:"last_step"`
+	CreatedAt types.JSONNullTime `json:"created_at"`
+}
+
+type Upload struct {
+	ID           int64                `json:"id"`
+	OwnerID      int64                `json:"owner_id"`
+	FileName     string               `json:"file_name"`
+	Title        types.JSONNullString `json:"title"`
+	Description  types.JSONNullString `json:"description"`
+	Coordinates  types.JSONNullString `json:"coordinates"`
+	Tags         types.JSONNullString `json:"tags"`
+	Checksum     types.JSONNullString `json:"checksum"`
+	Length       int64                `json:"length"`
+	UploadOffset int64                `json:"upload_offset"`
+	CreatedAt    types.JSONNullTime   `json:"created_at"`
+	UpdatedAt    types.JSONNullTime   `json:"updated_at"`
 }
 
 type User struct {
-	ID       int64                `json:"id"`
-	Login    string               `json:"login"`
-	Password string               `json:"password"`
-	Email    types.JSONNullString `json:"email"`
-	Profile  types.JSONNullString `json:"profile"`
-	IsAdmin  int64                `json:"is_admin"`
+	ID            int64                `json:"id"`
+	Login         string               `json:"login"`
+	Password      string               `json:"password"`
+	Email         types.JSONNullString `json:"email"`
+	Profile       types.JSONNullString `json:"profile"`
+	IsAdmin       int64                `json:"is_admin"`
+	Active        int64                `json:"active"`
+	EmailVerified int64                `json:"email_verified"`
+	Quota         types.JSONNullInt64  `json:"quota"`
+	Disabled      int64                `json:"disabled"`
 }
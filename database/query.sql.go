@@ -7,10 +7,26 @@ package database
 
 import (
 	"context"
+	"strings"
+	"time"
 
 	"server/types"
 )
 
+const activateUser = `-- name: ActivateUser :execrows
+UPDATE users
+SET active = 1, email_verified = 1
+WHERE id = ? AND email_verified = 0 AND disabled = 0
+`
+
+func (q *Queries) ActivateUser(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, activateUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const addAlbum = `-- name: AddAlbum :exec
 INSERT INTO album (
   title, owner_id, cover_id
@@ -32,19 +48,22 @@ func (q *Queries) AddAlbum(ctx context.Context, arg AddAlbumParams) error {
 
 const addFile = `-- name: AddFile :one
 INSERT INTO files (
-  owner_id, file_name, title, description, coordinates, checksum
+  owner_id, file_name, title, description, coordinates, checksum, size, latitude, longitude
 ) VALUES(
-  ?, ?, ?, ?, ?, ?
+  ?, ?, ?, ?, ?, ?, ?, ?, ?
 ) RETURNING id
 `
 
 type AddFileParams struct {
-	OwnerID     int64                `json:"owner_id"`
-	FileName    string               `json:"file_name"`
-	Title       types.JSONNullString `json:"title"`
-	Description types.JSONNullString `json:"description"`
-	Coordinates types.JSONNullString `json:"coordinates"`
-	Checksum    string               `json:"checksum"`
+	OwnerID     int64                 `json:"owner_id"`
+	FileName    string                `json:"file_name"`
+	Title       types.JSONNullString  `json:"title"`
+	Description types.JSONNullString  `json:"description"`
+	Coordinates types.JSONNullString  `json:"coordinates"`
+	Checksum    string                `json:"checksum"`
+	Size        int64                 `json:"size"`
+	Latitude    types.JSONNullFloat64 `json:"latitude"`
+	Longitude   types.JSONNullFloat64 `json:"longitude"`
 }
 
 func (q *Queries) AddFile(ctx context.Context, arg AddFileParams) (int64, error) {
@@ -55,12 +74,53 @@ func (q *Queries) AddFile(ctx context.Context, arg AddFileParams) (int64, error)
 		arg.Description,
 		arg.Coordinates,
 		arg.Checksum,
+		arg.Size,
+		arg.Latitude,
+		arg.Longitude,
 	)
 	var id int64
 	err := row.Scan(&id)
 	return id, err
 }
 
+const addFileMetadata = `-- name: AddFileMetadata :exec
+INSERT INTO fileMetadata (file_id, taken_at, camera_make, camera_model, lens, exposure_time, f_number, iso, focal_length, orientation, latitude, longitude)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+type AddFileMetadataParams struct {
+	FileID       int64                 `json:"file_id"`
+	TakenAt      types.JSONNullTime    `json:"taken_at"`
+	CameraMake   types.JSONNullString  `json:"camera_make"`
+	CameraModel  types.JSONNullString  `json:"camera_model"`
+	Lens         types.JSONNullString  `json:"lens"`
+	ExposureTime types.JSONNullFloat64 `json:"exposure_time"`
+	FNumber      types.JSONNullFloat64 `json:"f_number"`
+	Iso          types.JSONNullInt64   `json:"iso"`
+	FocalLength  types.JSONNullFloat64 `json:"focal_length"`
+	Orientation  types.JSONNullInt64   `json:"orientation"`
+	Latitude     types.JSONNullFloat64 `json:"latitude"`
+	Longitude    types.JSONNullFloat64 `json:"longitude"`
+}
+
+func (q *Queries) AddFileMetadata(ctx context.Context, arg AddFileMetadataParams) error {
+	_, err := q.db.ExecContext(ctx, addFileMetadata,
+		arg.FileID,
+		arg.TakenAt,
+		arg.CameraMake,
+		arg.CameraModel,
+		arg.Lens,
+		arg.ExposureTime,
+		arg.FNumber,
+		arg.Iso,
+		arg.FocalLength,
+		arg.Orientation,
+		arg.Latitude,
+		arg.Longitude,
+	)
+	return err
+}
+
 const addGuestFile = `-- name: AddGuestFile :one
 INSERT INTO fileGuestShares (
   file_id, url, expires_at, max_uses
@@ -98,15 +158,21 @@ func (q *Queries) AddGuestFile(ctx context.Context, arg AddGuestFileParams) (Fil
 
 const addTag = `-- name: AddTag :one
 INSERT INTO tags (
-  name
+  owner_id, parent_id, name
 ) VALUES (
-  ?
+  ?, ?, ?
 )
 RETURNING id
 `
 
-func (q *Queries) AddTag(ctx context.Context, name string) (int64, error) {
-	row := q.db.QueryRowContext(ctx, addTag, name)
+type AddTagParams struct {
+	OwnerID  int64  `json:"owner_id"`
+	ParentID int64  `json:"parent_id"`
+	Name     string `json:"name"`
+}
+
+func (q *Queries) AddTag(ctx context.Context, arg AddTagParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, addTag, arg.OwnerID, arg.ParentID, arg.Name)
 	var id int64
 	err := row.Scan(&id)
 	return id, err
@@ -134,7 +200,7 @@ const changeRole = `-- name: ChangeRole :one
 UPDATE users
 SET is_admin = ?
 WHERE id = ?
-RETURNING id, login, password, email, profile, is_admin
+RETURNING id, login, password, email, profile, is_admin, active, email_verified, quota, disabled
 `
 
 type ChangeRoleParams struct {
@@ -152,120 +218,219 @@ func (q *Queries) ChangeRole(ctx context.Context, arg ChangeRoleParams) (User, e
 		&i.Email,
 		&i.Profile,
 		&i.IsAdmin,
+		&i.Active,
+		&i.EmailVerified,
+		&i.Quota,
+		&i.Disabled,
 	)
 	return i, err
 }
 
-const createUser = `-- name: CreateUser :exec
-INSERT INTO users (
-  login, password, email
-) VALUES(
-  ?, ?, ?
-)
+const clearInviteCreator = `-- name: ClearInviteCreator :exec
+UPDATE invites
+SET created_by = NULL
+WHERE created_by = ?
 `
 
-type CreateUserParams struct {
-	Login    string               `json:"login"`
-	Password string               `json:"password"`
-	Email    types.JSONNullString `json:"email"`
+func (q *Queries) ClearInviteCreator(ctx context.Context, createdBy types.JSONNullInt64) error {
+	_, err := q.db.ExecContext(ctx, clearInviteCreator, createdBy)
+	return err
+}
+
+const confirmTOTP = `-- name: ConfirmTOTP :exec
+UPDATE totp
+SET confirmed = 1, last_step = ?
+WHERE user_id = ?
+`
+
+type ConfirmTOTPParams struct {
+	LastStep int64 `json:"last_step"`
+	UserID   int64 `json:"user_id"`
 }
 
-func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) error {
-	_, err := q.db.ExecContext(ctx, createUser, arg.Login, arg.Password, arg.Email)
+func (q *Queries) ConfirmTOTP(ctx context.Context, arg ConfirmTOTPParams) error {
+	_, err := q.db.ExecContext(ctx, confirmTOTP, arg.LastStep, arg.UserID)
 	return err
 }
 
-const decrementShareUses = `-- name: DecrementShareUses :exec
-UPDATE fileguestshares
-SET max_uses = max_uses - 1
-WHERE id = ? AND max_uses > 0
+const countRecoveryCodes = `-- name: CountRecoveryCodes :one
+SELECT COUNT(*) FROM recoveryCodes
+WHERE user_id = ? AND used_at IS NULL
 `
 
-func (q *Queries) DecrementShareUses(ctx context.Context, id int64) error {
-	_, err := q.db.ExecContext(ctx, decrementShareUses, id)
-	return err
+func (q *Queries) CountRecoveryCodes(ctx context.Context, userID int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countRecoveryCodes, userID)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
 }
 
-const deleteFile = `-- name: DeleteFile :exec
-DELETE FROM files
-WHERE id = ?
+const createAPIKey = `-- name: CreateAPIKey :one
+INSERT INTO apiKeys (
+  user_id, name, key_hash, scopes, expires_at
+) VALUES (
+  ?, ?, ?, ?, ?
+)
+RETURNING id, user_id, name, key_hash, scopes, created_at, expires_at, last_used_at
 `
 
-func (q *Queries) DeleteFile(ctx context.Context, id int64) error {
-	_, err := q.db.ExecContext(ctx, deleteFile, id)
+type CreateAPIKeyParams struct {
+	UserID    int64              `json:"user_id"`
+	Name      string             `json:"name"`
+	KeyHash   string             `json:"key_hash"`
+	Scopes    string             `json:"scopes"`
+	ExpiresAt types.JSONNullTime `json:"expires_at"`
+}
+
+func (q *Queries) CreateAPIKey(ctx context.Context, arg CreateAPIKeyParams) (Apikey, error) {
+	row := q.db.QueryRowContext(ctx, createAPIKey,
+		arg.UserID,
+		arg.Name,
+		arg.KeyHash,
+		arg.Scopes,
+		arg.ExpiresAt,
+	)
+	var i Apikey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.KeyHash,
+		&i.Scopes,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+	)
+	return i, err
+}
+
+const createEmailVerification = `-- name: CreateEmailVerification :exec
+INSERT INTO emailVerifications (
+  user_id, token_hash, expires_at
+) VALUES (
+  ?, ?, ?
+)
+`
+
+type CreateEmailVerificationParams struct {
+	UserID    int64     `json:"user_id"`
+	TokenHash string    `json:"token_hash"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (q *Queries) CreateEmailVerification(ctx context.Context, arg CreateEmailVerificationParams) error {
+	_, err := q.db.ExecContext(ctx, createEmailVerification, arg.UserID, arg.TokenHash, arg.ExpiresAt)
 	return err
 }
 
-const getAlbum = `-- name: GetAlbum :one
-SELECT id, owner_id, cover_id, title FROM album
-WHERE id = ?
+const createInvite = `-- name: CreateInvite :one
+INSERT INTO invites (
+  code_hash, created_by, note, max_uses, expires_at
+) VALUES (
+  ?, ?, ?, ?, ?
+)
+RETURNING id, code_hash, created_by, note, max_uses, uses, created_at, expires_at
 `
 
-func (q *Queries) GetAlbum(ctx context.Context, id int64) (Album, error) {
-	row := q.db.QueryRowContext(ctx, getAlbum, id)
-	var i Album
+type CreateInviteParams struct {
+	CodeHash  string               `json:"code_hash"`
+	CreatedBy types.JSONNullInt64  `json:"created_by"`
+	Note      types.JSONNullString `json:"note"`
+	MaxUses   int64                `json:"max_uses"`
+	ExpiresAt types.JSONNullTime   `json:"expires_at"`
+}
+
+func (q *Queries) CreateInvite(ctx context.Context, arg CreateInviteParams) (Invite, error) {
+	row := q.db.QueryRowContext(ctx, createInvite,
+		arg.CodeHash,
+		arg.CreatedBy,
+		arg.Note,
+		arg.MaxUses,
+		arg.ExpiresAt,
+	)
+	var i Invite
 	err := row.Scan(
 		&i.ID,
-		&i.OwnerID,
-		&i.CoverID,
-		&i.Title,
+		&i.CodeHash,
+		&i.CreatedBy,
+		&i.Note,
+		&i.MaxUses,
+		&i.Uses,
+		&i.CreatedAt,
+		&i.ExpiresAt,
 	)
 	return i, err
 }
 
-const getAlbums = `-- name: GetAlbums :many
-SELECT id, owner_id, cover_id, title FROM album
-WHERE owner_id = ?
+const createPasswordReset = `-- name: CreatePasswordReset :exec
+INSERT INTO passwordResets (
+  user_id, token_hash, expires_at
+) VALUES (
+  ?, ?, ?
+)
 `
 
-func (q *Queries) GetAlbums(ctx context.Context, ownerID int64) ([]Album, error) {
-	rows, err := q.db.QueryContext(ctx, getAlbums, ownerID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []Album
-	for rows.Next() {
-		var i Album
-		if err := rows.Scan(
-			&i.ID,
-			&i.OwnerID,
-			&i.CoverID,
-			&i.Title,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Close(); err != nil {
-		return nil, err
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
+type CreatePasswordResetParams struct {
+	UserID    int64     `json:"user_id"`
+	TokenHash string    `json:"token_hash"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
-const getEmail = `-- name: GetEmail :one
-SELECT email FROM users 
-WHERE id = ? LIMIT 1
+func (q *Queries) CreatePasswordReset(ctx context.Context, arg CreatePasswordResetParams) error {
+	_, err := q.db.ExecContext(ctx, createPasswordReset, arg.UserID, arg.TokenHash, arg.ExpiresAt)
+	return err
+}
+
+const createRecoveryCode = `-- name: CreateRecoveryCode :exec
+INSERT INTO recoveryCodes (
+  user_id, code_hash
+) VALUES (
+  ?, ?
+)
 `
 
-func (q *Queries) GetEmail(ctx context.Context, id int64) (types.JSONNullString, error) {
-	row := q.db.QueryRowContext(ctx, getEmail, id)
-	var email types.JSONNullString
-	err := row.Scan(&email)
-	return email, err
+type CreateRecoveryCodeParams struct {
+	UserID   int64  `json:"user_id"`
+	CodeHash string `json:"code_hash"`
 }
 
-const getFile = `-- name: GetFile :one
-SELECT id, owner_id, file_name, title, description, coordinates, checksum, created_at FROM files
-WHERE id = ?
+func (q *Queries) CreateRecoveryCode(ctx context.Context, arg CreateRecoveryCodeParams) error {
+	_, err := q.db.ExecContext(ctx, createRecoveryCode, arg.UserID, arg.CodeHash)
+	return err
+}
+
+const createUpload = `-- name: CreateUpload :one
+INSERT INTO uploads (
+  owner_id, file_name, title, description, coordinates, tags, checksum, length
+) VALUES (
+  ?, ?, ?, ?, ?, ?, ?, ?
+)
+RETURNING id, owner_id, file_name, title, description, coordinates, tags, checksum, length, upload_offset, created_at, updated_at
 `
 
-func (q *Queries) GetFile(ctx context.Context, id int64) (File, error) {
-	row := q.db.QueryRowContext(ctx, getFile, id)
-	var i File
+type CreateUploadParams struct {
+	OwnerID     int64                `json:"owner_id"`
+	FileName    string               `json:"file_name"`
+	Title       types.JSONNullString `json:"title"`
+	Description types.JSONNullString `json:"description"`
+	Coordinates types.JSONNullString `json:"coordinates"`
+	Tags        types.JSONNullString `json:"tags"`
+	Checksum    types.JSONNullString `json:"checksum"`
+	Length      int64                `json:"length"`
+}
+
+func (q *Queries) CreateUpload(ctx context.Context, arg CreateUploadParams) (Upload, error) {
+	row := q.db.QueryRowContext(ctx, createUpload,
+		arg.OwnerID,
+		arg.FileName,
+		arg.Title,
+		arg.Description,
+		arg.Coordinates,
+		arg.Tags,
+		arg.Checksum,
+		arg.Length,
+	)
+	var i Upload
 	err := row.Scan(
 		&i.ID,
 		&i.OwnerID,
@@ -273,137 +438,1244 @@ func (q *Queries) GetFile(ctx context.Context, id int64) (File, error) {
 		&i.Title,
 		&i.Description,
 		&i.Coordinates,
+		&i.Tags,
 		&i.Checksum,
+		&i.Length,
+		&i.UploadOffset,
 		&i.CreatedAt,
+		&i.UpdatedAt,
 	)
 	return i, err
 }
 
-const getFileFromAlbum = `-- name: GetFileFromAlbum :many
-SELECT file_id
-FROM fileAlbum
-WHERE album_id = ?
+const createUser = `-- name: CreateUser :one
+INSERT INTO users (
+  login, password, email, active
+) VALUES(
+  ?, ?, ?, ?
+) RETURNING id
 `
 
-func (q *Queries) GetFileFromAlbum(ctx context.Context, albumID int64) ([]int64, error) {
-	rows, err := q.db.QueryContext(ctx, getFileFromAlbum, albumID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []int64
-	for rows.Next() {
-		var file_id int64
-		if err := rows.Scan(&file_id); err != nil {
-			return nil, err
-		}
-		items = append(items, file_id)
-	}
-	if err := rows.Close(); err != nil {
-		return nil, err
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
+type CreateUserParams struct {
+	Login    string               `json:"login"`
+	Password string               `json:"password"`
+	Email    types.JSONNullString `json:"email"`
+	Active   int64                `json:"active"`
 }
 
-const getFileOwner = `-- name: GetFileOwner :one
-SELECT owner_id FROM files
-WHERE id = ?
+func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, createUser,
+		arg.Login,
+		arg.Password,
+		arg.Email,
+		arg.Active,
+	)
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const decrementShareUses = `-- name: DecrementShareUses :exec
+UPDATE fileguestshares
+SET max_uses = max_uses - 1
+WHERE id = ? AND max_uses > 0
 `
 
-func (q *Queries) GetFileOwner(ctx context.Context, id int64) (int64, error) {
-	row := q.db.QueryRowContext(ctx, getFileOwner, id)
-	var owner_id int64
-	err := row.Scan(&owner_id)
-	return owner_id, err
+func (q *Queries) DecrementShareUses(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, decrementShareUses, id)
+	return err
 }
 
-const getFiles = `-- name: GetFiles :many
-SELECT id, file_name, checksum, created_at FROM files 
-WHERE owner_id = ?
+const deleteAPIKey = `-- name: DeleteAPIKey :execrows
+DELETE FROM apiKeys
+WHERE id = ? AND user_id = ?
 `
 
-type GetFilesRow struct {
-	ID        int64              `json:"id"`
-	FileName  string             `json:"file_name"`
-	Checksum  string             `json:"checksum"`
-	CreatedAt types.JSONNullTime `json:"created_at"`
+type DeleteAPIKeyParams struct {
+	ID     int64 `json:"id"`
+	UserID int64 `json:"user_id"`
 }
 
-func (q *Queries) GetFiles(ctx context.Context, ownerID int64) ([]GetFilesRow, error) {
-	rows, err := q.db.QueryContext(ctx, getFiles, ownerID)
+func (q *Queries) DeleteAPIKey(ctx context.Context, arg DeleteAPIKeyParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteAPIKey, arg.ID, arg.UserID)
 	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []GetFilesRow
-	for rows.Next() {
-		var i GetFilesRow
-		if err := rows.Scan(
-			&i.ID,
-			&i.FileName,
-			&i.Checksum,
-			&i.CreatedAt,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Close(); err != nil {
-		return nil, err
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
+		return 0, err
 	}
-	return items, nil
+	return result.RowsAffected()
 }
 
-const getFilesByTag = `-- name: GetFilesByTag :many
-SELECT files.id, files.owner_id, files.file_name, files.title, files.description, files.coordinates, files.checksum, files.created_at FROM fileTags
-LEFT JOIN files ON files.id = fileTags.file_id
-WHERE fileTags.tag_id = ? AND files.owner_id = ?
+const deleteEmailVerifications = `-- name: DeleteEmailVerifications :exec
+DELETE FROM emailVerifications
+WHERE user_id = ?
 `
 
-type GetFilesByTagParams struct {
-	TagID   int64 `json:"tag_id"`
-	OwnerID int64 `json:"owner_id"`
+func (q *Queries) DeleteEmailVerifications(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteEmailVerifications, userID)
+	return err
 }
 
-type GetFilesByTagRow struct {
-	ID          types.JSONNullInt64  `json:"id"`
-	OwnerID     types.JSONNullInt64  `json:"owner_id"`
-	FileName    types.JSONNullString `json:"file_name"`
-	Title       types.JSONNullString `json:"title"`
-	Description types.JSONNullString `json:"description"`
-	Coordinates types.JSONNullString `json:"coordinates"`
-	Checksum    types.JSONNullString `json:"checksum"`
-	CreatedAt   types.JSONNullTime   `json:"created_at"`
+const deleteFile = `-- name: DeleteFile :exec
+DELETE FROM files
+WHERE id = ?
+`
+
+func (q *Queries) DeleteFile(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, deleteFile, id)
+	return err
+}
+
+const deleteInvite = `-- name: DeleteInvite :execrows
+DELETE FROM invites
+WHERE id = ?
+`
+
+func (q *Queries) DeleteInvite(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteInvite, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deletePasswordResets = `-- name: DeletePasswordResets :exec
+DELETE FROM passwordResets
+WHERE user_id = ?
+`
+
+func (q *Queries) DeletePasswordResets(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deletePasswordResets, userID)
+	return err
+}
+
+const deleteRecoveryCodes = `-- name: DeleteRecoveryCodes :exec
+DELETE FROM recoveryCodes
+WHERE user_id = ?
+`
+
+func (q *Queries) DeleteRecoveryCodes(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteRecoveryCodes, userID)
+	return err
+}
+
+const deleteTOTP = `-- name: DeleteTOTP :execrows
+DELETE FROM totp
+WHERE user_id = ?
+`
+
+func (q *Queries) DeleteTOTP(ctx context.Context, userID int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteTOTP, userID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteTag = `-- name: DeleteTag :execrows
+DELETE FROM tags
+WHERE id = ? AND owner_id = ?
+`
+
+type DeleteTagParams struct {
+	ID      int64 `json:"id"`
+	OwnerID int64 `json:"owner_id"`
+}
+
+func (q *Queries) DeleteTag(ctx context.Context, arg DeleteTagParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteTag, arg.ID, arg.OwnerID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteTagFiles = `-- name: DeleteTagFiles :exec
+DELETE FROM fileTags
+WHERE tag_id = ?
+`
+
+func (q *Queries) DeleteTagFiles(ctx context.Context, tagID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteTagFiles, tagID)
+	return err
+}
+
+const deleteUnusedBlob = `-- name: DeleteUnusedBlob :exec
+DELETE FROM blobs
+WHERE checksum = ? AND refs <= 0
+`
+
+func (q *Queries) DeleteUnusedBlob(ctx context.Context, checksum string) error {
+	_, err := q.db.ExecContext(ctx, deleteUnusedBlob, checksum)
+	return err
+}
+
+const deleteUnusedTags = `-- name: DeleteUnusedTags :execrows
+DELETE FROM tags
+WHERE tags.owner_id = ? AND tags.owner_id <> 0
+  AND tags.id NOT IN (SELECT fileTags.tag_id FROM fileTags JOIN files ON files.id = fileTags.file_id)
+  AND tags.id NOT IN (SELECT parents.parent_id FROM tags AS parents)
+`
+
+func (q *Queries) DeleteUnusedTags(ctx context.Context, ownerID int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUnusedTags, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteUpload = `-- name: DeleteUpload :exec
+DELETE FROM uploads
+WHERE id = ?
+`
+
+func (q *Queries) DeleteUpload(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUpload, id)
+	return err
+}
+
+const deleteUser = `-- name: DeleteUser :execrows
+DELETE FROM users
+WHERE id = ?
+`
+
+func (q *Queries) DeleteUser(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteUserAPIKeys = `-- name: DeleteUserAPIKeys :exec
+DELETE FROM apiKeys
+WHERE user_id = ?
+`
+
+func (q *Queries) DeleteUserAPIKeys(ctx context.Context, userID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserAPIKeys, userID)
+	return err
+}
+
+const deleteUserAlbumFiles = `-- name: DeleteUserAlbumFiles :exec
+DELETE FROM fileAlbum
+WHERE file_id IN (SELECT files.id FROM files WHERE files.owner_id = ?1)
+   OR album_id IN (SELECT album.id FROM album WHERE album.owner_id = ?1)
+`
+
+func (q *Queries) DeleteUserAlbumFiles(ctx context.Context, ownerID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserAlbumFiles, ownerID)
+	return err
+}
+
+const deleteUserAlbums = `-- name: DeleteUserAlbums :exec
+DELETE FROM album
+WHERE owner_id = ?
+`
+
+func (q *Queries) DeleteUserAlbums(ctx context.Context, ownerID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserAlbums, ownerID)
+	return err
+}
+
+const deleteUserFileTags = `-- name: DeleteUserFileTags :exec
+DELETE FROM fileTags
+WHERE file_id IN (SELECT id FROM files WHERE owner_id = ?)
+`
+
+func (q *Queries) DeleteUserFileTags(ctx context.Context, ownerID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserFileTags, ownerID)
+	return err
+}
+
+const deleteUserFiles = `-- name: DeleteUserFiles :exec
+DELETE FROM files
+WHERE owner_id = ?
+`
+
+func (q *Queries) DeleteUserFiles(ctx context.Context, ownerID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserFiles, ownerID)
+	return err
+}
+
+const deleteUserShares = `-- name: DeleteUserShares :exec
+DELETE FROM fileGuestShares
+WHERE file_id IN (SELECT id FROM files WHERE owner_id = ?)
+`
+
+func (q *Queries) DeleteUserShares(ctx context.Context, ownerID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserShares, ownerID)
+	return err
+}
+
+const deleteUserTags = `-- name: DeleteUserTags :exec
+DELETE FROM tags
+WHERE owner_id = ? AND owner_id <> 0
+`
+
+func (q *Queries) DeleteUserTags(ctx context.Context, ownerID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserTags, ownerID)
+	return err
+}
+
+const deleteUserUploads = `-- name: DeleteUserUploads :exec
+DELETE FROM uploads
+WHERE owner_id = ?
+`
+
+func (q *Queries) DeleteUserUploads(ctx context.Context, ownerID int64) error {
+	_, err := q.db.ExecContext(ctx, deleteUserUploads, ownerID)
+	return err
+}
+
+const getAPIKeyByHash = `-- name: GetAPIKeyByHash :one
+SELECT id, user_id, name, key_hash, scopes, created_at, expires_at, last_used_at FROM apiKeys
+WHERE key_hash = ?
+`
+
+func (q *Queries) GetAPIKeyByHash(ctx context.Context, keyHash string) (Apikey, error) {
+	row := q.db.QueryRowContext(ctx, getAPIKeyByHash, keyHash)
+	var i Apikey
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.Name,
+		&i.KeyHash,
+		&i.Scopes,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.LastUsedAt,
+	)
+	return i, err
+}
+
+const getAPIKeys = `-- name: GetAPIKeys :many
+SELECT id, name, scopes, created_at, expires_at, last_used_at FROM apiKeys
+WHERE user_id = ?
+`
+
+type GetAPIKeysRow struct {
+	ID         int64              `json:"id"`
+	Name       string             `json:"name"`
+	Scopes     string             `json:"scopes"`
+	CreatedAt  types.JSONNullTime `json:"created_at"`
+	ExpiresAt  types.JSONNullTime `json:"expires_at"`
+	LastUsedAt types.JSONNullTime `json:"last_used_at"`
+}
+
+func (q *Queries) GetAPIKeys(ctx context.Context, userID int64) ([]GetAPIKeysRow, error) {
+	rows, err := q.db.QueryContext(ctx, getAPIKeys, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetAPIKeysRow
+	for rows.Next() {
+		var i GetAPIKeysRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Name,
+			&i.Scopes,
+			&i.CreatedAt,
+			&i.ExpiresAt,
+			&i.LastUsedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAlbum = `-- name: GetAlbum :one
+SELECT id, owner_id, cover_id, title FROM album
+WHERE id = ?
+`
+
+func (q *Queries) GetAlbum(ctx context.Context, id int64) (Album, error) {
+	row := q.db.QueryRowContext(ctx, getAlbum, id)
+	var i Album
+	err := row.Scan(
+		&i.ID,
+		&i.OwnerID,
+		&i.CoverID,
+		&i.Title,
+	)
+	return i, err
+}
+
+const getAlbums = `-- name: GetAlbums :many
+SELECT id, owner_id, cover_id, title FROM album
+WHERE owner_id = ?
+`
+
+func (q *Queries) GetAlbums(ctx context.Context, ownerID int64) ([]Album, error) {
+	rows, err := q.db.QueryContext(ctx, getAlbums, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Album
+	for rows.Next() {
+		var i Album
+		if err := rows.Scan(
+			&i.ID,
+			&i.OwnerID,
+			&i.CoverID,
+			&i.Title,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getBlobRefs = `-- name: GetBlobRefs :one
+SELECT refs FROM blobs
+WHERE checksum = ?
+`
+
+func (q *Queries) GetBlobRefs(ctx context.Context, checksum string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getBlobRefs, checksum)
+	var refs int64
+	err := row.Scan(&refs)
+	return refs, err
+}
+
+const getChildTag = `-- name: GetChildTag :one
+SELECT id, owner_id, parent_id, name
+FROM tags
+WHERE parent_id = ? AND name = ? AND (owner_id = ? OR owner_id = 0)
+ORDER BY owner_id DESC
+LIMIT 1
+`
+
+type GetChildTagParams struct {
+	ParentID int64  `json:"parent_id"`
+	Name     string `json:"name"`
+	OwnerID  int64  `json:"owner_id"`
+}
+
+func (q *Queries) GetChildTag(ctx context.Context, arg GetChildTagParams) (Tag, error) {
+	row := q.db.QueryRowContext(ctx, getChildTag, arg.ParentID, arg.Name, arg.OwnerID)
+	var i Tag
+	err := row.Scan(
+		&i.ID,
+		&i.OwnerID,
+		&i.ParentID,
+		&i.Name,
+	)
+	return i, err
+}
+
+const getChildTags = `-- name: GetChildTags :many
+SELECT id, owner_id, parent_id, name
+FROM tags
+WHERE parent_id = ?
+`
+
+func (q *Queries) GetChildTags(ctx context.Context, parentID int64) ([]Tag, error) {
+	rows, err := q.db.QueryContext(ctx, getChildTags, parentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Tag
+	for rows.Next() {
+		var i Tag
+		if err := rows.Scan(
+			&i.ID,
+			&i.OwnerID,
+			&i.ParentID,
+			&i.Name,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getEmail = `-- name: GetEmail :one
+SELECT email FROM users 
+WHERE id = ? LIMIT 1
+`
+
+func (q *Queries) GetEmail(ctx context.Context, id int64) (types.JSONNullString, error) {
+	row := q.db.QueryRowContext(ctx, getEmail, id)
+	var email types.JSONNullString
+	err := row.Scan(&email)
+	return email, err
+}
+
+const getFile = `-- name: GetFile :one
+SELECT id, owner_id, file_name, title, description, coordinates, checksum, created_at, size, latitude, longitude FROM files
+WHERE id = ?
+`
+
+func (q *Queries) GetFile(ctx context.Context, id int64) (File, error) {
+	row := q.db.QueryRowContext(ctx, getFile, id)
+	var i File
+	err := row.Scan(
+		&i.ID,
+		&i.OwnerID,
+		&i.FileName,
+		&i.Title,
+		&i.Description,
+		&i.Coordinates,
+		&i.Checksum,
+		&i.CreatedAt,
+		&i.Size,
+		&i.Latitude,
+		&i.Longitude,
+	)
+	return i, err
+}
+
+const getFileChecksums = `-- name: GetFileChecksums :many
+SELECT checksum FROM files
+WHERE owner_id = ?
+`
+
+func (q *Queries) GetFileChecksums(ctx context.Context, ownerID int64) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, getFileChecksums, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var checksum string
+		if err := rows.Scan(&checksum); err != nil {
+			return nil, err
+		}
+		items = append(items, checksum)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFileFromAlbum = `-- name: GetFileFromAlbum :many
+SELECT file_id
+FROM fileAlbum
+WHERE album_id = ?
+`
+
+func (q *Queries) GetFileFromAlbum(ctx context.Context, albumID int64) ([]int64, error) {
+	rows, err := q.db.QueryContext(ctx, getFileFromAlbum, albumID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var file_id int64
+		if err := rows.Scan(&file_id); err != nil {
+			return nil, err
+		}
+		items = append(items, file_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFileMetadata = `-- name: GetFileMetadata :one
+SELECT file_id, taken_at, camera_make, camera_model, lens, exposure_time, f_number, iso, focal_length, orientation, latitude, longitude FROM fileMetadata
+WHERE file_id = ?
+`
+
+func (q *Queries) GetFileMetadata(ctx context.Context, fileID int64) (Filemetadata, error) {
+	row := q.db.QueryRowContext(ctx, getFileMetadata, fileID)
+	var i Filemetadata
+	err := row.Scan(
+		&i.FileID,
+		&i.TakenAt,
+		&i.CameraMake,
+		&i.CameraModel,
+		&i.Lens,
+		&i.ExposureTime,
+		&i.FNumber,
+		&i.Iso,
+		&i.FocalLength,
+		&i.Orientation,
+		&i.Latitude,
+		&i.Longitude,
+	)
+	return i, err
+}
+
+const getFileOwner = `-- name: GetFileOwner :one
+SELECT owner_id FROM files
+WHERE id = ?
+`
+
+func (q *Queries) GetFileOwner(ctx context.Context, id int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getFileOwner, id)
+	var owner_id int64
+	err := row.Scan(&owner_id)
+	return owner_id, err
+}
+
+const getFiles = `-- name: GetFiles :many
+SELECT id, file_name, checksum, created_at FROM files 
+WHERE owner_id = ?
+`
+
+type GetFilesRow struct {
+	ID        int64              `json:"id"`
+	FileName  string             `json:"file_name"`
+	Checksum  string             `json:"checksum"`
+	CreatedAt types.JSONNullTime `json:"created_at"`
+}
+
+func (q *Queries) GetFiles(ctx context.Context, ownerID int64) ([]GetFilesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getFiles, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetFilesRow
+	for rows.Next() {
+		var i GetFilesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.FileName,
+			&i.Checksum,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFilesByTag = `-- name: GetFilesByTag :many
+SELECT files.id, files.owner_id, files.file_name, files.title, files.description, files.coordinates, files.checksum, files.created_at, files.size, files.latitude, files.longitude FROM files
+JOIN fileTags ON fileTags.file_id = files.id
+WHERE fileTags.tag_id = ? AND files.owner_id = ?
+`
+
+type GetFilesByTagParams struct {
+	TagID   int64 `json:"tag_id"`
+	OwnerID int64 `json:"owner_id"`
+}
+
+func (q *Queries) GetFilesByTag(ctx context.Context, arg GetFilesByTagParams) ([]File, error) {
+	rows, err := q.db.QueryContext(ctx, getFilesByTag, arg.TagID, arg.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []File
+	for rows.Next() {
+		var i File
+		if err := rows.Scan(
+			&i.ID,
+			&i.OwnerID,
+			&i.FileName,
+			&i.Title,
+			&i.Description,
+			&i.Coordinates,
+			&i.Checksum,
+			&i.CreatedAt,
+			&i.Size,
+			&i.Latitude,
+			&i.Longitude,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFilesByTagTree = `-- name: GetFilesByTagTree :many
+WITH RECURSIVE subtree (id) AS (
+  SELECT tags.id FROM tags
+  WHERE tags.id = ?
+  UNION
+  SELECT tags.id FROM tags
+  JOIN subtree ON tags.parent_id = subtree.id
+)
+SELECT files.id, files.owner_id, files.file_name, files.title, files.description, files.coordinates, files.checksum, files.created_at, files.size, files.latitude, files.longitude FROM files
+WHERE files.owner_id = ? AND files.id IN (
+  SELECT file_id FROM fileTags
+  WHERE tag_id IN (SELECT id FROM subtree)
+)
+`
+
+type GetFilesByTagTreeParams struct {
+	ID      int64 `json:"id"`
+	OwnerID int64 `json:"owner_id"`
+}
+
+func (q *Queries) GetFilesByTagTree(ctx context.Context, arg GetFilesByTagTreeParams) ([]File, error) {
+	rows, err := q.db.QueryContext(ctx, getFilesByTagTree, arg.ID, arg.OwnerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []File
+	for rows.Next() {
+		var i File
+		if err := rows.Scan(
+			&i.ID,
+			&i.OwnerID,
+			&i.FileName,
+			&i.Title,
+			&i.Description,
+			&i.Coordinates,
+			&i.Checksum,
+			&i.CreatedAt,
+			&i.Size,
+			&i.Latitude,
+			&i.Longitude,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFilesMetadata = `-- name: GetFilesMetadata :many
+SELECT file_id, taken_at, camera_make, camera_model, lens, exposure_time, f_number, iso, focal_length, orientation, latitude, longitude FROM fileMetadata
+WHERE file_id IN (/*SLICE:file_ids*/?)
+`
+
+func (q *Queries) GetFilesMetadata(ctx context.Context, fileIds []int64) ([]Filemetadata, error) {
+	query := getFilesMetadata
+	var queryParams []interface{}
+	if len(fileIds) > 0 {
+		for _, v := range fileIds {
+			queryParams = append(queryParams, v)
+		}
+		query = strings.Replace(query, "/*SLICE:file_ids*/?", strings.Repeat(",?", len(fileIds))[1:], 1)
+	} else {
+		query = strings.Replace(query, "/*SLICE:file_ids*/?", "NULL", 1)
+	}
+	rows, err := q.db.QueryContext(ctx, query, queryParams...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Filemetadata
+	for rows.Next() {
+		var i Filemetadata
+		if err := rows.Scan(
+			&i.FileID,
+			&i.TakenAt,
+			&i.CameraMake,
+			&i.CameraModel,
+			&i.Lens,
+			&i.ExposureTime,
+			&i.FNumber,
+			&i.Iso,
+			&i.FocalLength,
+			&i.Orientation,
+			&i.Latitude,
+			&i.Longitude,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getInvites = `-- name: GetInvites :many
+SELECT id, created_by, note, max_uses, uses, created_at, expires_at FROM invites
+ORDER BY id DESC
+`
+
+type GetInvitesRow struct {
+	ID        int64                `json:"id"`
+	CreatedBy types.JSONNullInt64  `json:"created_by"`
+	Note      types.JSONNullString `json:"note"`
+	MaxUses   int64                `json:"max_uses"`
+	Uses      int64                `json:"uses"`
+	CreatedAt types.JSONNullTime   `json:"created_at"`
+	ExpiresAt types.JSONNullTime   `json:"expires_at"`
+}
+
+func (q *Queries) GetInvites(ctx context.Context) ([]GetInvitesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getInvites)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetInvitesRow
+	for rows.Next() {
+		var i GetInvitesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedBy,
+			&i.Note,
+			&i.MaxUses,
+			&i.Uses,
+			&i.CreatedAt,
+			&i.ExpiresAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getIsAdmin = `-- name: GetIsAdmin :one
+SELECT is_admin FROM users 
+WHERE id = ? LIMIT 1
+`
+
+func (q *Queries) GetIsAdmin(ctx context.Context, id int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getIsAdmin, id)
+	var is_admin int64
+	err := row.Scan(&is_admin)
+	return is_admin, err
+}
+
+const getLocatedFiles = `-- name: GetLocatedFiles :many
+SELECT id, owner_id, file_name, title, description, coordinates, checksum, created_at, size, latitude, longitude FROM files
+WHERE owner_id = ? AND latitude IS NOT NULL AND longitude IS NOT NULL
+ORDER BY created_at, id
+`
+
+func (q *Queries) GetLocatedFiles(ctx context.Context, ownerID int64) ([]File, error) {
+	rows, err := q.db.QueryContext(ctx, getLocatedFiles, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []File
+	for rows.Next() {
+		var i File
+		if err := rows.Scan(
+			&i.ID,
+			&i.OwnerID,
+			&i.FileName,
+			&i.Title,
+			&i.Description,
+			&i.Coordinates,
+			&i.Checksum,
+			&i.CreatedAt,
+			&i.Size,
+			&i.Latitude,
+			&i.Longitude,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLogin = `-- name: GetLogin :one
+SELECT login FROM users 
+WHERE id = ? LIMIT 1
+`
+
+func (q *Queries) GetLogin(ctx context.Context, id int64) (string, error) {
+	row := q.db.QueryRowContext(ctx, getLogin, id)
+	var login string
+	err := row.Scan(&login)
+	return login, err
+}
+
+const getMetadataByChecksum = `-- name: GetMetadataByChecksum :one
+SELECT filemetadata.file_id, filemetadata.taken_at, filemetadata.camera_make, filemetadata.camera_model, filemetadata.lens, filemetadata.exposure_time, filemetadata.f_number, filemetadata.iso, filemetadata.focal_length, filemetadata.orientation, filemetadata.latitude, filemetadata.longitude FROM fileMetadata
+JOIN files ON files.id = fileMetadata.file_id
+WHERE files.owner_id = ? AND files.checksum = ?
+ORDER BY files.id
+LIMIT 1
+`
+
+type GetMetadataByChecksumParams struct {
+	OwnerID  int64  `json:"owner_id"`
+	Checksum string `json:"checksum"`
+}
+
+func (q *Queries) GetMetadataByChecksum(ctx context.Context, arg GetMetadataByChecksumParams) (Filemetadata, error) {
+	row := q.db.QueryRowContext(ctx, getMetadataByChecksum, arg.OwnerID, arg.Checksum)
+	var i Filemetadata
+	err := row.Scan(
+		&i.FileID,
+		&i.TakenAt,
+		&i.CameraMake,
+		&i.CameraModel,
+		&i.Lens,
+		&i.ExposureTime,
+		&i.FNumber,
+		&i.Iso,
+		&i.FocalLength,
+		&i.Orientation,
+		&i.Latitude,
+		&i.Longitude,
+	)
+	return i, err
+}
+
+const getPassword = `-- name: GetPassword :one
+SELECT password FROM users 
+WHERE id = ? LIMIT 1
+`
+
+func (q *Queries) GetPassword(ctx context.Context, id int64) (string, error) {
+	row := q.db.QueryRowContext(ctx, getPassword, id)
+	var password string
+	err := row.Scan(&password)
+	return password, err
+}
+
+const getPendingUploadBytes = `-- name: GetPendingUploadBytes :one
+SELECT CAST(COALESCE(SUM(length), 0) AS INTEGER) AS bytes FROM uploads
+WHERE owner_id = ?
+`
+
+func (q *Queries) GetPendingUploadBytes(ctx context.Context, ownerID int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getPendingUploadBytes, ownerID)
+	var bytes int64
+	err := row.Scan(&bytes)
+	return bytes, err
+}
+
+const getProfile = `-- name: GetProfile :one
+SELECT profile FROM users 
+WHERE id = ? LIMIT 1
+`
+
+func (q *Queries) GetProfile(ctx context.Context, id int64) (types.JSONNullString, error) {
+	row := q.db.QueryRowContext(ctx, getProfile, id)
+	var profile types.JSONNullString
+	err := row.Scan(&profile)
+	return profile, err
+}
+
+const getQuota = `-- name: GetQuota :one
+SELECT quota FROM users
+WHERE id = ?
+`
+
+func (q *Queries) GetQuota(ctx context.Context, id int64) (types.JSONNullInt64, error) {
+	row := q.db.QueryRowContext(ctx, getQuota, id)
+	var quota types.JSONNullInt64
+	err := row.Scan(&quota)
+	return quota, err
+}
+
+const getRole = `-- name: GetRole :one
+SELECT is_admin FROM users 
+WHERE id = ? LIMIT 1
+`
+
+func (q *Queries) GetRole(ctx context.Context, id int64) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getRole, id)
+	var is_admin int64
+	err := row.Scan(&is_admin)
+	return is_admin, err
+}
+
+const getShareDownload = `-- name: GetShareDownload :one
+SELECT files.id, files.owner_id, files.file_name, files.title, files.description, files.coordinates, files.checksum, files.created_at, files.size, files.latitude, files.longitude FROM fileGuestShares
+LEFT JOIN files ON files.id = fileGuestShares.file_id
+WHERE fileGuestShares.url = ? AND fileGuestShares.id = ?
+`
+
+type GetShareDownloadParams struct {
+	Url string `json:"url"`
+	ID  int64  `json:"id"`
+}
+
+type GetShareDownloadRow struct {
+	ID          types.JSONNullInt64   `json:"id"`
+	OwnerID     types.JSONNullInt64   `json:"owner_id"`
+	FileName    types.JSONNullString  `json:"file_name"`
+	Title       types.JSONNullString  `json:"title"`
+	Description types.JSONNullString  `json:"description"`
+	Coordinates types.JSONNullString  `json:"coordinates"`
+	Checksum    types.JSONNullString  `json:"checksum"`
+	CreatedAt   types.JSONNullTime    `json:"created_at"`
+	Size        types.JSONNullInt64   `json:"size"`
+	Latitude    types.JSONNullFloat64 `json:"latitude"`
+	Longitude   types.JSONNullFloat64 `json:"longitude"`
+}
+
+func (q *Queries) GetShareDownload(ctx context.Context, arg GetShareDownloadParams) (GetShareDownloadRow, error) {
+	row := q.db.QueryRowContext(ctx, getShareDownload, arg.Url, arg.ID)
+	var i GetShareDownloadRow
+	err := row.Scan(
+		&i.ID,
+		&i.OwnerID,
+		&i.FileName,
+		&i.Title,
+		&i.Description,
+		&i.Coordinates,
+		&i.Checksum,
+		&i.CreatedAt,
+		&i.Size,
+		&i.Latitude,
+		&i.Longitude,
+	)
+	return i, err
+}
+
+const getShareUseCount = `-- name: GetShareUseCount :one
+SELECT max_uses FROM fileGuestShares
+WHERE id = ?
+`
+
+func (q *Queries) GetShareUseCount(ctx context.Context, id int64) (types.JSONNullInt64, error) {
+	row := q.db.QueryRowContext(ctx, getShareUseCount, id)
+	var max_uses types.JSONNullInt64
+	err := row.Scan(&max_uses)
+	return max_uses, err
+}
+
+const getSharedFiles = `-- name: GetSharedFiles :many
+SELECT fileguestshares.id, fileguestshares.file_id, fileguestshares.url, fileguestshares.created_at, fileguestshares.expires_at, fileguestshares.max_uses FROM fileGuestShares
+LEFT JOIN files ON files.id = fileGuestShares.file_id
+WHERE files.owner_id = ?1 OR ?2 = 1
+`
+
+type GetSharedFilesParams struct {
+	OwnerID int64       `json:"owner_id"`
+	IsAdmin interface{} `json:"is_admin"`
+}
+
+func (q *Queries) GetSharedFiles(ctx context.Context, arg GetSharedFilesParams) ([]Fileguestshare, error) {
+	rows, err := q.db.QueryContext(ctx, getSharedFiles, arg.OwnerID, arg.IsAdmin)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Fileguestshare
+	for rows.Next() {
+		var i Fileguestshare
+		if err := rows.Scan(
+			&i.ID,
+			&i.FileID,
+			&i.Url,
+			&i.CreatedAt,
+			&i.ExpiresAt,
+			&i.MaxUses,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSlashTags = `-- name: GetSlashTags :many
+SELECT id, owner_id, parent_id, name
+FROM tags
+WHERE name LIKE '%/%'
+`
+
+func (q *Queries) GetSlashTags(ctx context.Context) ([]Tag, error) {
+	rows, err := q.db.QueryContext(ctx, getSlashTags)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Tag
+	for rows.Next() {
+		var i Tag
+		if err := rows.Scan(
+			&i.ID,
+			&i.OwnerID,
+			&i.ParentID,
+			&i.Name,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getStaleUploads = `-- name: GetStaleUploads :many
+SELECT id FROM uploads
+WHERE updated_at < datetime('now', ?1)
+`
+
+func (q *Queries) GetStaleUploads(ctx context.Context, age interface{}) ([]int64, error) {
+	rows, err := q.db.QueryContext(ctx, getStaleUploads, age)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-func (q *Queries) GetFilesByTag(ctx context.Context, arg GetFilesByTagParams) ([]GetFilesByTagRow, error) {
-	rows, err := q.db.QueryContext(ctx, getFilesByTag, arg.TagID, arg.OwnerID)
+const getStorageUsage = `-- name: GetStorageUsage :one
+SELECT COUNT(*) AS files, CAST(COALESCE(SUM(size), 0) AS INTEGER) AS bytes FROM files
+WHERE owner_id = ?
+`
+
+type GetStorageUsageRow struct {
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (q *Queries) GetStorageUsage(ctx context.Context, ownerID int64) (GetStorageUsageRow, error) {
+	row := q.db.QueryRowContext(ctx, getStorageUsage, ownerID)
+	var i GetStorageUsageRow
+	err := row.Scan(&i.Files, &i.Bytes)
+	return i, err
+}
+
+const getTOTP = `-- name: GetTOTP :one
+SELECT user_id, secret, confirmed, last_step, created_at FROM totp
+WHERE user_id = ?
+`
+
+func (q *Queries) GetTOTP(ctx context.Context, userID int64) (Totp, error) {
+	row := q.db.QueryRowContext(ctx, getTOTP, userID)
+	var i Totp
+	err := row.Scan(
+		&i.UserID,
+		&i.Secret,
+		&i.Confirmed,
+		&i.LastStep,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getTagById = `-- name: GetTagById :one
+SELECT id, owner_id, parent_id, name
+FROM tags
+WHERE id = ?
+`
+
+func (q *Queries) GetTagById(ctx context.Context, id int64) (Tag, error) {
+	row := q.db.QueryRowContext(ctx, getTagById, id)
+	var i Tag
+	err := row.Scan(
+		&i.ID,
+		&i.OwnerID,
+		&i.ParentID,
+		&i.Name,
+	)
+	return i, err
+}
+
+const getTagDescendants = `-- name: GetTagDescendants :many
+WITH RECURSIVE subtree (id) AS (
+  SELECT tags.id FROM tags
+  WHERE tags.id = ?
+  UNION
+  SELECT tags.id FROM tags
+  JOIN subtree ON tags.parent_id = subtree.id
+)
+SELECT id FROM subtree
+`
+
+func (q *Queries) GetTagDescendants(ctx context.Context, id int64) ([]int64, error) {
+	rows, err := q.db.QueryContext(ctx, getTagDescendants, id)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []GetFilesByTagRow
+	var items []int64
 	for rows.Next() {
-		var i GetFilesByTagRow
-		if err := rows.Scan(
-			&i.ID,
-			&i.OwnerID,
-			&i.FileName,
-			&i.Title,
-			&i.Description,
-			&i.Coordinates,
-			&i.Checksum,
-			&i.CreatedAt,
-		); err != nil {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
 			return nil, err
 		}
-		items = append(items, i)
+		items = append(items, id)
 	}
 	if err := rows.Close(); err != nil {
 		return nil, err
@@ -414,91 +1686,208 @@ func (q *Queries) GetFilesByTag(ctx context.Context, arg GetFilesByTagParams) ([
 	return items, nil
 }
 
-const getIsAdmin = `-- name: GetIsAdmin :one
-SELECT is_admin FROM users 
-WHERE id = ? LIMIT 1
+const getTagPath = `-- name: GetTagPath :one
+WITH RECURSIVE ancestors (id, parent_id, path) AS (
+  SELECT id, parent_id, name FROM tags
+  WHERE tags.id = ?
+  UNION ALL
+  SELECT tags.id, tags.parent_id, tags.name || '/' || ancestors.path FROM tags
+  JOIN ancestors ON tags.id = ancestors.parent_id
+)
+SELECT CAST(path AS TEXT) AS path FROM ancestors
+WHERE parent_id = 0
 `
 
-func (q *Queries) GetIsAdmin(ctx context.Context, id int64) (int64, error) {
-	row := q.db.QueryRowContext(ctx, getIsAdmin, id)
-	var is_admin int64
-	err := row.Scan(&is_admin)
-	return is_admin, err
+func (q *Queries) GetTagPath(ctx context.Context, id int64) (string, error) {
+	row := q.db.QueryRowContext(ctx, getTagPath, id)
+	var path string
+	err := row.Scan(&path)
+	return path, err
 }
 
-const getLogin = `-- name: GetLogin :one
-SELECT login FROM users 
-WHERE id = ? LIMIT 1
+const getTags = `-- name: GetTags :many
+WITH RECURSIVE tagPaths (id, path) AS (
+  SELECT id, name FROM tags
+  WHERE parent_id = 0 AND (owner_id = ?1 OR owner_id = 0)
+  UNION ALL
+  SELECT tags.id, tagPaths.path || '/' || tags.name FROM tags
+  JOIN tagPaths ON tags.parent_id = tagPaths.id
+  WHERE tags.owner_id = ?1 OR tags.owner_id = 0
+)
+SELECT tags.id, tags.owner_id, tags.parent_id, tags.name, CAST(tagPaths.path AS TEXT) AS path, COUNT(files.id) AS file_count
+FROM tags
+JOIN tagPaths ON tagPaths.id = tags.id
+LEFT JOIN fileTags ON fileTags.tag_id = tags.id
+LEFT JOIN files ON files.id = fileTags.file_id AND files.owner_id = ?1
+GROUP BY tags.id
+ORDER BY path
 `
 
-func (q *Queries) GetLogin(ctx context.Context, id int64) (string, error) {
-	row := q.db.QueryRowContext(ctx, getLogin, id)
-	var login string
-	err := row.Scan(&login)
-	return login, err
+type GetTagsRow struct {
+	ID        int64  `json:"id"`
+	OwnerID   int64  `json:"owner_id"`
+	ParentID  int64  `json:"parent_id"`
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	FileCount int64  `json:"file_count"`
 }
 
-const getPassword = `-- name: GetPassword :one
-SELECT password FROM users 
-WHERE id = ? LIMIT 1
+func (q *Queries) GetTags(ctx context.Context, ownerID int64) ([]GetTagsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getTags, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetTagsRow
+	for rows.Next() {
+		var i GetTagsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.OwnerID,
+			&i.ParentID,
+			&i.Name,
+			&i.Path,
+			&i.FileCount,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTagsByFile = `-- name: GetTagsByFile :many
+SELECT tag_id 
+FROM fileTags
+WHERE file_id = ?
 `
 
-func (q *Queries) GetPassword(ctx context.Context, id int64) (string, error) {
-	row := q.db.QueryRowContext(ctx, getPassword, id)
-	var password string
-	err := row.Scan(&password)
-	return password, err
+func (q *Queries) GetTagsByFile(ctx context.Context, fileID int64) ([]int64, error) {
+	rows, err := q.db.QueryContext(ctx, getTagsByFile, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var tag_id int64
+		if err := rows.Scan(&tag_id); err != nil {
+			return nil, err
+		}
+		items = append(items, tag_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const getProfile = `-- name: GetProfile :one
-SELECT profile FROM users 
-WHERE id = ? LIMIT 1
+const getUnlocatedFiles = `-- name: GetUnlocatedFiles :many
+SELECT id, coordinates FROM files
+WHERE latitude IS NULL AND coordinates IS NOT NULL AND coordinates <> ''
 `
 
-func (q *Queries) GetProfile(ctx context.Context, id int64) (types.JSONNullString, error) {
-	row := q.db.QueryRowContext(ctx, getProfile, id)
-	var profile types.JSONNullString
-	err := row.Scan(&profile)
-	return profile, err
+type GetUnlocatedFilesRow struct {
+	ID          int64                `json:"id"`
+	Coordinates types.JSONNullString `json:"coordinates"`
 }
 
-const getRole = `-- name: GetRole :one
-SELECT is_admin FROM users 
-WHERE id = ? LIMIT 1
+func (q *Queries) GetUnlocatedFiles(ctx context.Context) ([]GetUnlocatedFilesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getUnlocatedFiles)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetUnlocatedFilesRow
+	for rows.Next() {
+		var i GetUnlocatedFilesRow
+		if err := rows.Scan(&i.ID, &i.Coordinates); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUnsizedChecksums = `-- name: GetUnsizedChecksums :many
+SELECT DISTINCT checksum FROM files
+WHERE size < 0
 `
 
-func (q *Queries) GetRole(ctx context.Context, id int64) (int64, error) {
-	row := q.db.QueryRowContext(ctx, getRole, id)
-	var is_admin int64
-	err := row.Scan(&is_admin)
-	return is_admin, err
+func (q *Queries) GetUnsizedChecksums(ctx context.Context) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, getUnsizedChecksums)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var checksum string
+		if err := rows.Scan(&checksum); err != nil {
+			return nil, err
+		}
+		items = append(items, checksum)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const getShareDownload = `-- name: GetShareDownload :one
-SELECT files.id, files.owner_id, files.file_name, files.title, files.description, files.coordinates, files.checksum, files.created_at FROM fileGuestShares
-LEFT JOIN files ON files.id = fileGuestShares.file_id
-WHERE fileGuestShares.url = ? AND fileGuestShares.id = ?
+const getUnusedBlobs = `-- name: GetUnusedBlobs :many
+SELECT checksum FROM blobs
+WHERE refs <= 0
 `
 
-type GetShareDownloadParams struct {
-	Url string `json:"url"`
-	ID  int64  `json:"id"`
+func (q *Queries) GetUnusedBlobs(ctx context.Context) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, getUnusedBlobs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var checksum string
+		if err := rows.Scan(&checksum); err != nil {
+			return nil, err
+		}
+		items = append(items, checksum)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-type GetShareDownloadRow struct {
-	ID          types.JSONNullInt64  `json:"id"`
-	OwnerID     types.JSONNullInt64  `json:"owner_id"`
-	FileName    types.JSONNullString `json:"file_name"`
-	Title       types.JSONNullString `json:"title"`
-	Description types.JSONNullString `json:"description"`
-	Coordinates types.JSONNullString `json:"coordinates"`
-	Checksum    types.JSONNullString `json:"checksum"`
-	CreatedAt   types.JSONNullTime   `json:"created_at"`
-}
+const getUpload = `-- name: GetUpload :one
+SELECT id, owner_id, file_name, title, description, coordinates, tags, checksum, length, upload_offset, created_at, updated_at FROM uploads
+WHERE id = ?
+`
 
-func (q *Queries) GetShareDownload(ctx context.Context, arg GetShareDownloadParams) (GetShareDownloadRow, error) {
-	row := q.db.QueryRowContext(ctx, getShareDownload, arg.Url, arg.ID)
-	var i GetShareDownloadRow
+func (q *Queries) GetUpload(ctx context.Context, id int64) (Upload, error) {
+	row := q.db.QueryRowContext(ctx, getUpload, id)
+	var i Upload
 	err := row.Scan(
 		&i.ID,
 		&i.OwnerID,
@@ -506,51 +1895,98 @@ func (q *Queries) GetShareDownload(ctx context.Context, arg GetShareDownloadPara
 		&i.Title,
 		&i.Description,
 		&i.Coordinates,
+		&i.Tags,
 		&i.Checksum,
+		&i.Length,
+		&i.UploadOffset,
 		&i.CreatedAt,
+		&i.UpdatedAt,
+	)
+	return i, err
+}
+
+const getUser = `-- name: GetUser :one
+SELECT id, login, password, is_admin, active, disabled FROM users 
+WHERE id = ? LIMIT 1
+`
+
+type GetUserRow struct {
+	ID       int64  `json:"id"`
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	IsAdmin  int64  `json:"is_admin"`
+	Active   int64  `json:"active"`
+	Disabled int64  `json:"disabled"`
+}
+
+func (q *Queries) GetUser(ctx context.Context, id int64) (GetUserRow, error) {
+	row := q.db.QueryRowContext(ctx, getUser, id)
+	var i GetUserRow
+	err := row.Scan(
+		&i.ID,
+		&i.Login,
+		&i.Password,
+		&i.IsAdmin,
+		&i.Active,
+		&i.Disabled,
+	)
+	return i, err
+}
+
+const getUserByLogin = `-- name: GetUserByLogin :one
+SELECT id, login, password, is_admin, active, disabled FROM users 
+WHERE login = ? LIMIT 1
+`
+
+type GetUserByLoginRow struct {
+	ID       int64  `json:"id"`
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	IsAdmin  int64  `json:"is_admin"`
+	Active   int64  `json:"active"`
+	Disabled int64  `json:"disabled"`
+}
+
+func (q *Queries) GetUserByLogin(ctx context.Context, login string) (GetUserByLoginRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserByLogin, login)
+	var i GetUserByLoginRow
+	err := row.Scan(
+		&i.ID,
+		&i.Login,
+		&i.Password,
+		&i.IsAdmin,
+		&i.Active,
+		&i.Disabled,
 	)
 	return i, err
 }
 
-const getShareUseCount = `-- name: GetShareUseCount :one
-SELECT max_uses FROM fileGuestShares
-WHERE id = ?
-`
-
-func (q *Queries) GetShareUseCount(ctx context.Context, id int64) (types.JSONNullInt64, error) {
-	row := q.db.QueryRowContext(ctx, getShareUseCount, id)
-	var max_uses types.JSONNullInt64
-	err := row.Scan(&max_uses)
-	return max_uses, err
-}
-
-const getSharedFiles = `-- name: GetSharedFiles :many
-SELECT fileguestshares.id, fileguestshares.file_id, fileguestshares.url, fileguestshares.created_at, fileguestshares.expires_at, fileguestshares.max_uses FROM fileGuestShares
-LEFT JOIN files ON files.id = fileGuestShares.file_id
-WHERE files.owner_id = ? OR ? = 1
+const getUserFiles = `-- name: GetUserFiles :many
+SELECT id, owner_id, file_name, title, description, coordinates, checksum, created_at, size, latitude, longitude FROM files
+WHERE owner_id = ?
 `
 
-type GetSharedFilesParams struct {
-	OwnerID int64       `json:"owner_id"`
-	IsAdmin interface{} `json:"column_2"`
-}
-
-func (q *Queries) GetSharedFiles(ctx context.Context, arg GetSharedFilesParams) ([]Fileguestshare, error) {
-	rows, err := q.db.QueryContext(ctx, getSharedFiles, arg.OwnerID, arg.IsAdmin)
+func (q *Queries) GetUserFiles(ctx context.Context, ownerID int64) ([]File, error) {
+	rows, err := q.db.QueryContext(ctx, getUserFiles, ownerID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Fileguestshare
+	var items []File
 	for rows.Next() {
-		var i Fileguestshare
+		var i File
 		if err := rows.Scan(
 			&i.ID,
-			&i.FileID,
-			&i.Url,
+			&i.OwnerID,
+			&i.FileName,
+			&i.Title,
+			&i.Description,
+			&i.Coordinates,
+			&i.Checksum,
 			&i.CreatedAt,
-			&i.ExpiresAt,
-			&i.MaxUses,
+			&i.Size,
+			&i.Latitude,
+			&i.Longitude,
 		); err != nil {
 			return nil, err
 		}
@@ -565,47 +2001,69 @@ func (q *Queries) GetSharedFiles(ctx context.Context, arg GetSharedFilesParams)
 	return items, nil
 }
 
-const getTagById = `-- name: GetTagById :one
-SELECT id, name
-FROM tags
-WHERE id = ?
+const getUserUploads = `-- name: GetUserUploads :many
+SELECT id FROM uploads
+WHERE owner_id = ?
 `
 
-func (q *Queries) GetTagById(ctx context.Context, id int64) (Tag, error) {
-	row := q.db.QueryRowContext(ctx, getTagById, id)
-	var i Tag
-	err := row.Scan(&i.ID, &i.Name)
-	return i, err
+func (q *Queries) GetUserUploads(ctx context.Context, ownerID int64) ([]int64, error) {
+	rows, err := q.db.QueryContext(ctx, getUserUploads, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const getTagByName = `-- name: GetTagByName :one
-SELECT id, name
-FROM tags
-WHERE name = ?
+const getUsers = `-- name: GetUsers :many
+SELECT id, login, email, is_admin, active, email_verified, quota, disabled FROM users
+ORDER BY id
 `
 
-func (q *Queries) GetTagByName(ctx context.Context, name string) (Tag, error) {
-	row := q.db.QueryRowContext(ctx, getTagByName, name)
-	var i Tag
-	err := row.Scan(&i.ID, &i.Name)
-	return i, err
+type GetUsersRow struct {
+	ID            int64                `json:"id"`
+	Login         string               `json:"login"`
+	Email         types.JSONNullString `json:"email"`
+	IsAdmin       int64                `json:"is_admin"`
+	Active        int64                `json:"active"`
+	EmailVerified int64                `json:"email_verified"`
+	Quota         types.JSONNullInt64  `json:"quota"`
+	Disabled      int64                `json:"disabled"`
 }
 
-const getTags = `-- name: GetTags :many
-select id, name
-FROM tags
-`
-
-func (q *Queries) GetTags(ctx context.Context) ([]Tag, error) {
-	rows, err := q.db.QueryContext(ctx, getTags)
+func (q *Queries) GetUsers(ctx context.Context) ([]GetUsersRow, error) {
+	rows, err := q.db.QueryContext(ctx, getUsers)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []Tag
+	var items []GetUsersRow
 	for rows.Next() {
-		var i Tag
-		if err := rows.Scan(&i.ID, &i.Name); err != nil {
+		var i GetUsersRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Login,
+			&i.Email,
+			&i.IsAdmin,
+			&i.Active,
+			&i.EmailVerified,
+			&i.Quota,
+			&i.Disabled,
+		); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -619,25 +2077,40 @@ func (q *Queries) GetTags(ctx context.Context) ([]Tag, error) {
 	return items, nil
 }
 
-const getTagsByFile = `-- name: GetTagsByFile :many
-SELECT tag_id 
-FROM fileTags
-WHERE file_id = ?
+const getUsersByEmail = `-- name: GetUsersByEmail :many
+SELECT id, login, email, active, email_verified, disabled FROM users
+WHERE email = ?
 `
 
-func (q *Queries) GetTagsByFile(ctx context.Context, fileID int64) ([]int64, error) {
-	rows, err := q.db.QueryContext(ctx, getTagsByFile, fileID)
+type GetUsersByEmailRow struct {
+	ID            int64                `json:"id"`
+	Login         string               `json:"login"`
+	Email         types.JSONNullString `json:"email"`
+	Active        int64                `json:"active"`
+	EmailVerified int64                `json:"email_verified"`
+	Disabled      int64                `json:"disabled"`
+}
+
+func (q *Queries) GetUsersByEmail(ctx context.Context, email types.JSONNullString) ([]GetUsersByEmailRow, error) {
+	rows, err := q.db.QueryContext(ctx, getUsersByEmail, email)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []int64
+	var items []GetUsersByEmailRow
 	for rows.Next() {
-		var tag_id int64
-		if err := rows.Scan(&tag_id); err != nil {
+		var i GetUsersByEmailRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Login,
+			&i.Email,
+			&i.Active,
+			&i.EmailVerified,
+			&i.Disabled,
+		); err != nil {
 			return nil, err
 		}
-		items = append(items, tag_id)
+		items = append(items, i)
 	}
 	if err := rows.Close(); err != nil {
 		return nil, err
@@ -648,56 +2121,183 @@ func (q *Queries) GetTagsByFile(ctx context.Context, fileID int64) ([]int64, err
 	return items, nil
 }
 
-const getUser = `-- name: GetUser :one
-SELECT id, login, password, is_admin FROM users 
-WHERE id = ? LIMIT 1
+const hasFileWithChecksum = `-- name: HasFileWithChecksum :one
+SELECT COUNT(*) FROM files
+WHERE owner_id = ? AND checksum = ?
 `
 
-type GetUserRow struct {
-	ID       int64  `json:"id"`
-	Login    string `json:"login"`
-	Password string `json:"password"`
-	IsAdmin  int64  `json:"is_admin"`
+type HasFileWithChecksumParams struct {
+	OwnerID  int64  `json:"owner_id"`
+	Checksum string `json:"checksum"`
 }
 
-func (q *Queries) GetUser(ctx context.Context, id int64) (GetUserRow, error) {
-	row := q.db.QueryRowContext(ctx, getUser, id)
-	var i GetUserRow
-	err := row.Scan(
-		&i.ID,
-		&i.Login,
-		&i.Password,
-		&i.IsAdmin,
-	)
-	return i, err
+func (q *Queries) HasFileWithChecksum(ctx context.Context, arg HasFileWithChecksumParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, hasFileWithChecksum, arg.OwnerID, arg.Checksum)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
 }
 
-const getUserByLogin = `-- name: GetUserByLogin :one
-SELECT id, login, password, is_admin FROM users 
-WHERE login = ? LIMIT 1
+const moveFileTags = `-- name: MoveFileTags :exec
+UPDATE OR IGNORE filetags
+SET tag_id = ?1
+WHERE tag_id = ?2
 `
 
-type GetUserByLoginRow struct {
-	ID       int64  `json:"id"`
-	Login    string `json:"login"`
-	Password string `json:"password"`
-	IsAdmin  int64  `json:"is_admin"`
+type MoveFileTagsParams struct {
+	IntoID int64 `json:"into_id"`
+	FromID int64 `json:"from_id"`
 }
 
-func (q *Queries) GetUserByLogin(ctx context.Context, login string) (GetUserByLoginRow, error) {
-	row := q.db.QueryRowContext(ctx, getUserByLogin, login)
-	var i GetUserByLoginRow
-	err := row.Scan(
-		&i.ID,
-		&i.Login,
-		&i.Password,
-		&i.IsAdmin,
-	)
-	return i, err
+func (q *Queries) MoveFileTags(ctx context.Context, arg MoveFileTagsParams) error {
+	_, err := q.db.ExecContext(ctx, moveFileTags, arg.IntoID, arg.FromID)
+	return err
+}
+
+const renameTag = `-- name: RenameTag :execrows
+UPDATE tags
+SET name = ?
+WHERE id = ? AND owner_id = ?
+`
+
+type RenameTagParams struct {
+	Name    string `json:"name"`
+	ID      int64  `json:"id"`
+	OwnerID int64  `json:"owner_id"`
+}
+
+func (q *Queries) RenameTag(ctx context.Context, arg RenameTagParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, renameTag, arg.Name, arg.ID, arg.OwnerID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setFileLocation = `-- name: SetFileLocation :exec
+UPDATE files
+SET latitude = ?, longitude = ?
+WHERE id = ?
+`
+
+type SetFileLocationParams struct {
+	Latitude  types.JSONNullFloat64 `json:"latitude"`
+	Longitude types.JSONNullFloat64 `json:"longitude"`
+	ID        int64                 `json:"id"`
+}
+
+func (q *Queries) SetFileLocation(ctx context.Context, arg SetFileLocationParams) error {
+	_, err := q.db.ExecContext(ctx, setFileLocation, arg.Latitude, arg.Longitude, arg.ID)
+	return err
+}
+
+const setQuota = `-- name: SetQuota :execrows
+UPDATE users
+SET quota = ?
+WHERE id = ?
+`
+
+type SetQuotaParams struct {
+	Quota types.JSONNullInt64 `json:"quota"`
+	ID    int64               `json:"id"`
+}
+
+func (q *Queries) SetQuota(ctx context.Context, arg SetQuotaParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setQuota, arg.Quota, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const setSizeByChecksum = `-- name: SetSizeByChecksum :exec
+UPDATE files
+SET size = ?
+WHERE checksum = ?
+`
+
+type SetSizeByChecksumParams struct {
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+func (q *Queries) SetSizeByChecksum(ctx context.Context, arg SetSizeByChecksumParams) error {
+	_, err := q.db.ExecContext(ctx, setSizeByChecksum, arg.Size, arg.Checksum)
+	return err
+}
+
+const setTOTPSecret = `-- name: SetTOTPSecret :exec
+INSERT INTO totp (
+  user_id, secret
+) VALUES (
+  ?, ?
+)
+ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, confirmed = 0, last_step = 0
+`
+
+type SetTOTPSecretParams struct {
+	UserID int64  `json:"user_id"`
+	Secret string `json:"secret"`
+}
+
+func (q *Queries) SetTOTPSecret(ctx context.Context, arg SetTOTPSecretParams) error {
+	_, err := q.db.ExecContext(ctx, setTOTPSecret, arg.UserID, arg.Secret)
+	return err
+}
+
+const setTagParent = `-- name: SetTagParent :exec
+UPDATE tags
+SET parent_id = ?
+WHERE id = ?
+`
+
+type SetTagParentParams struct {
+	ParentID int64 `json:"parent_id"`
+	ID       int64 `json:"id"`
+}
+
+func (q *Queries) SetTagParent(ctx context.Context, arg SetTagParentParams) error {
+	_, err := q.db.ExecContext(ctx, setTagParent, arg.ParentID, arg.ID)
+	return err
+}
+
+const setUploadOffset = `-- name: SetUploadOffset :exec
+UPDATE uploads
+SET upload_offset = ?, updated_at = CURRENT_TIMESTAMP
+WHERE id = ?
+`
+
+type SetUploadOffsetParams struct {
+	UploadOffset int64 `json:"upload_offset"`
+	ID           int64 `json:"id"`
+}
+
+func (q *Queries) SetUploadOffset(ctx context.Context, arg SetUploadOffsetParams) error {
+	_, err := q.db.ExecContext(ctx, setUploadOffset, arg.UploadOffset, arg.ID)
+	return err
+}
+
+const setUserDisabled = `-- name: SetUserDisabled :execrows
+UPDATE users
+SET disabled = ?
+WHERE id = ?
+`
+
+type SetUserDisabledParams struct {
+	Disabled int64 `json:"disabled"`
+	ID       int64 `json:"id"`
+}
+
+func (q *Queries) SetUserDisabled(ctx context.Context, arg SetUserDisabledParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setUserDisabled, arg.Disabled, arg.ID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
 const tagsConnect = `-- name: TagsConnect :exec
-INSERT INTO fileTags (
+INSERT OR IGNORE INTO fileTags (
   file_id, tag_id
 ) VALUES (
   ?, ?
@@ -714,11 +2314,56 @@ func (q *Queries) TagsConnect(ctx context.Context, arg TagsConnectParams) error
 	return err
 }
 
+const touchAPIKey = `-- name: TouchAPIKey :exec
+UPDATE apikeys
+SET last_used_at = CURRENT_TIMESTAMP
+WHERE id = ?
+`
+
+func (q *Queries) TouchAPIKey(ctx context.Context, id int64) error {
+	_, err := q.db.ExecContext(ctx, touchAPIKey, id)
+	return err
+}
+
+const untagFile = `-- name: UntagFile :execrows
+DELETE FROM fileTags
+WHERE file_id = ? AND tag_id = ?
+`
+
+type UntagFileParams struct {
+	FileID int64 `json:"file_id"`
+	TagID  int64 `json:"tag_id"`
+}
+
+func (q *Queries) UntagFile(ctx context.Context, arg UntagFileParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, untagFile, arg.FileID, arg.TagID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updatePassword = `-- name: UpdatePassword :exec
+UPDATE users
+SET password = ?
+WHERE id = ?
+`
+
+type UpdatePasswordParams struct {
+	Password string `json:"password"`
+	ID       int64  `json:"id"`
+}
+
+func (q *Queries) UpdatePassword(ctx context.Context, arg UpdatePasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updatePassword, arg.Password, arg.ID)
+	return err
+}
+
 const updateUser = `-- name: UpdateUser :one
 UPDATE users
 SET email = ?, profile = ?
 WHERE id = ?
-RETURNING id, login, password, email, profile, is_admin
+RETURNING id, login, password, email, profile, is_admin, active, email_verified, quota, disabled
 `
 
 type UpdateUserParams struct {
@@ -737,6 +2382,105 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.Email,
 		&i.Profile,
 		&i.IsAdmin,
+		&i.Active,
+		&i.EmailVerified,
+		&i.Quota,
+		&i.Disabled,
 	)
 	return i, err
 }
+
+const useEmailVerification = `-- name: UseEmailVerification :one
+DELETE FROM emailVerifications
+WHERE token_hash = ?
+RETURNING user_id, expires_at
+`
+
+type UseEmailVerificationRow struct {
+	UserID    int64     `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (q *Queries) UseEmailVerification(ctx context.Context, tokenHash string) (UseEmailVerificationRow, error) {
+	row := q.db.QueryRowContext(ctx, useEmailVerification, tokenHash)
+	var i UseEmailVerificationRow
+	err := row.Scan(&i.UserID, &i.ExpiresAt)
+	return i, err
+}
+
+const useInvite = `-- name: UseInvite :one
+UPDATE invites
+SET uses = uses + 1
+WHERE code_hash = ? AND uses < max_uses
+RETURNING id, expires_at
+`
+
+type UseInviteRow struct {
+	ID        int64              `json:"id"`
+	ExpiresAt types.JSONNullTime `json:"expires_at"`
+}
+
+func (q *Queries) UseInvite(ctx context.Context, codeHash string) (UseInviteRow, error) {
+	row := q.db.QueryRowContext(ctx, useInvite, codeHash)
+	var i UseInviteRow
+	err := row.Scan(&i.ID, &i.ExpiresAt)
+	return i, err
+}
+
+const usePasswordReset = `-- name: UsePasswordReset :one
+UPDATE passwordresets
+SET used_at = CURRENT_TIMESTAMP
+WHERE token_hash = ? AND used_at IS NULL
+RETURNING user_id, expires_at
+`
+
+type UsePasswordResetRow struct {
+	UserID    int64     `json:"user_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (q *Queries) UsePasswordReset(ctx context.Context, tokenHash string) (UsePasswordResetRow, error) {
+	row := q.db.QueryRowContext(ctx, usePasswordReset, tokenHash)
+	var i UsePasswordResetRow
+	err := row.Scan(&i.UserID, &i.ExpiresAt)
+	return i, err
+}
+
+const useRecoveryCode = `-- name: UseRecoveryCode :execrows
+UPDATE recoverycodes
+SET used_at = CURRENT_TIMESTAMP
+WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+`
+
+type UseRecoveryCodeParams struct {
+	UserID   int64  `json:"user_id"`
+	CodeHash string `json:"code_hash"`
+}
+
+func (q *Queries) UseRecoveryCode(ctx context.Context, arg UseRecoveryCodeParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, useRecoveryCode, arg.UserID, arg.CodeHash)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const useTOTPStep = `-- name: UseTOTPStep :execrows
+UPDATE totp
+SET last_step = ?
+WHERE user_id = ? AND last_step < ?
+`
+
+type UseTOTPStepParams struct {
+	LastStep   int64 `json:"last_step"`
+	UserID     int64 `json:"user_id"`
+	LastStep_2 int64 `json:"last_step_2"`
+}
+
+func (q *Queries) UseTOTPStep(ctx context.Context, arg UseTOTPStepParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, useTOTPStep, arg.LastStep, arg.UserID, arg.LastStep_2)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
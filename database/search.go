@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"html"
+	"strings"
+	"time"
+)
+
+// sqliteTime is how CURRENT_TIMESTAMP stores created_at, in UTC.
+const sqliteTime = "2006-01-02 15:04:05"
+
+// GetFilesByCondition returns the files of the owner matching cond, an SQL
+// condition on files.id built by the tagquery package. It is written by hand
+// as sqlc cannot generate queries with a variable WHERE clause.
+func (q *Queries) GetFilesByCondition(ctx context.Context, ownerID int64, cond string, args ...any) ([]File, error) {
+	query := `SELECT files.id, files.owner_id, files.file_name, files.title, files.description, files.coordinates, files.checksum, files.created_at, files.size, files.latitude, files.longitude FROM files
+WHERE files.owner_id = ? AND (` + cond + `)
+ORDER BY files.id`
+
+	rows, err := q.db.QueryContext(ctx, query, append([]any{ownerID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []File
+	for rows.Next() {
+		var i File
+		if err := rows.Scan(
+			&i.ID,
+			&i.OwnerID,
+			&i.FileName,
+			&i.Title,
+			&i.Description,
+			&i.Coordinates,
+			&i.Checksum,
+			&i.CreatedAt,
+			&i.Size,
+			&i.Latitude,
+			&i.Longitude,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// snippetStart and snippetEnd delimit the matched terms in snippets, private
+// use characters so the text around them can be escaped before they become
+// <mark> tags.
+const (
+	snippetStart = "\ue000"
+	snippetEnd   = "\ue001"
+)
+
+var snippetMarks = strings.NewReplacer(snippetStart, "<mark>", snippetEnd, "</mark>")
+
+// highlight escapes the snippet and marks its matched terms.
+func highlight(snippet string) string {
+	return snippetMarks.Replace(html.EscapeString(snippet))
+}
+
+type SearchFilesParams struct {
+	OwnerID int64
+	// Match is an FTS5 query over title, description and file_name.
+	Match string
+	// From and To limit created_at to [From, To), zero times are open ends.
+	From time.Time
+	To   time.Time
+	// Cond and Args are an optional condition on files.id from the tagquery
+	// package.
+	Cond   string
+	Args   []any
+	Limit  int64
+	Offset int64
+}
+
+type SearchFilesRow struct {
+	File
+	// Metadata is what was read from EXIF and XMP, nil when there was none.
+	Metadata *Filemetadata `json:"metadata"`
+	Rank     float64       `json:"rank"`
+	Snippet  string        `json:"snippet"`
+}
+
+// SearchFiles runs a full-text search over the files of the owner, best
+// matches first. Matches in the title weigh more than in the description,
+// which weigh more than in the file name. Snippet is the best matching part
+// with the matched terms in <mark> tags, HTML-escaped so it can be shown as
+// is.
+func (q *Queries) SearchFiles(ctx context.Context, arg SearchFilesParams) ([]SearchFilesRow, error) {
+	var query strings.Builder
+	query.WriteString(`SELECT files.id, files.owner_id, files.file_name, files.title, files.description, files.coordinates, files.checksum, files.created_at, files.size, files.latitude, files.longitude,
+  bm25(fileSearch, 10.0, 5.0, 1.0) AS rank,
+  snippet(fileSearch, -1, '` + snippetStart + `', '` + snippetEnd + `', '…', 12) AS snippet,
+  fileMetadata.file_id, fileMetadata.taken_at, fileMetadata.camera_make, fileMetadata.camera_model, fileMetadata.lens, fileMetadata.exposure_time, fileMetadata.f_number, fileMetadata.iso, fileMetadata.focal_length, fileMetadata.orientation, fileMetadata.latitude, fileMetadata.longitude
+FROM fileSearch
+JOIN files ON files.id = fileSearch.rowid
+LEFT JOIN fileMetadata ON fileMetadata.file_id = files.id
+WHERE fileSearch MATCH ? AND files.owner_id = ?`)
+	args := []any{arg.Match, arg.OwnerID}
+
+	if !arg.From.IsZero() {
+		query.WriteString("\n  AND files.created_at >= ?")
+		args = append(args, arg.From.UTC().Format(sqliteTime))
+	}
+	if !arg.To.IsZero() {
+		query.WriteString("\n  AND files.created_at < ?")
+		args = append(args, arg.To.UTC().Format(sqliteTime))
+	}
+	if arg.Cond != "" {
+		query.WriteString("\n  AND (" + arg.Cond + ")")
+		args = append(args, arg.Args...)
+	}
+
+	query.WriteString("\nORDER BY rank, files.id\nLIMIT ? OFFSET ?")
+	args = append(args, arg.Limit, arg.Offset)
+
+	rows, err := q.db.QueryContext(ctx, query.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchFilesRow
+	for rows.Next() {
+		var i SearchFilesRow
+		var metadata Filemetadata
+		var metadataID sql.NullInt64
+		if err := rows.Scan(
+			&i.ID,
+			&i.OwnerID,
+			&i.FileName,
+			&i.Title,
+			&i.Description,
+			&i.Coordinates,
+			&i.Checksum,
+			&i.CreatedAt,
+			&i.Size,
+			&i.Latitude,
+			&i.Longitude,
+			&i.Rank,
+			&i.Snippet,
+			&metadataID,
+			&metadata.TakenAt,
+			&metadata.CameraMake,
+			&metadata.CameraModel,
+			&metadata.Lens,
+			&metadata.ExposureTime,
+			&metadata.FNumber,
+			&metadata.Iso,
+			&metadata.FocalLength,
+			&metadata.Orientation,
+			&metadata.Latitude,
+			&metadata.Longitude,
+		); err != nil {
+			return nil, err
+		}
+		if metadataID.Valid {
+			metadata.FileID = metadataID.Int64
+			i.Metadata = &metadata
+		}
+		i.Snippet = highlight(i.Snippet)
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// serveFile streams the stored file with its ETag set to the checksum.
+// http.ServeContent takes care of Range, If-Range, If-None-Match and
+// If-Modified-Since, answering with 206, 304 or 416 where appropriate.
+func (app *app) serveFile(w http.ResponseWriter, r *http.Request) {
+	fileID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		sendError(w, Error{400, "Invalid file id", "Bad Request"}, err)
+		return
+	}
+
+	id := r.Context().Value("id").(int64)
+
+	isAdmin, err := app.Query.GetIsAdmin(app.Ctx, id)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	file, err := app.Query.GetFile(app.Ctx, fileID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			sendError(w, Error{404, "File not found", "Not Found"}, err)
+			return
+		}
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if file.OwnerID != id && isAdmin == 0 {
+		sendError(w, Error{404, "File not found", "Not Found"}, nil)
+		return
+	}
+
+	f, err := app.Store.Get(app.Ctx, blobKey(file.Checksum))
+	if err != nil {
+		sendError(w, Error{500, "Error opening file:" + file.FileName, "Internal Server Error"}, err)
+		return
+	}
+	defer f.Close()
+
+	disposition := "inline"
+	if r.URL.Query().Has("download") {
+		disposition = "attachment"
+	}
+
+	// Let ServeContent pick the type from the file name or its content
+	w.Header().Del("Content-Type")
+	w.Header().Set("ETag", `"`+file.Checksum+`"`)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": file.FileName}))
+
+	http.ServeContent(w, r, file.FileName, file.CreatedAt.Time, f)
+}
@@ -0,0 +1,10 @@
+#!/usr/bin/env bash
+
+# Usage ./change_password.sh <token> <old password> <new password>
+# Every other session of the user is logged out
+
+curl --header "Content-Type: application/json" \
+  --header "Authorization: Bearer $1" \
+  --request POST \
+  --data '{"old_password":"'"$2"'","new_password":"'"$3"'"}' \
+  http://localhost:8000/password/change
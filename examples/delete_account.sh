@@ -0,0 +1,14 @@
+#!/usr/bin/env bash
+
+# Usage ./delete_account.sh <token> <password> [2fa code]
+# Downloads an export of the account to export.zip, then deletes it
+
+curl --header "Content-Type: application/json" \
+  --header "Authorization: Bearer $1" \
+  --request POST \
+  --data '{"password":"'"$2"'","code":"'"$3"'","export":true}' \
+  --fail --output export.zip \
+  http://localhost:8000/account/delete || exit 1
+
+# The archive only ends with deleted.json when the account is gone
+unzip -l export.zip deleted.json > /dev/null || echo "The account was not deleted" >&2
@@ -0,0 +1,9 @@
+#!/usr/bin/env bash
+
+# Usage ./forgot_password.sh <login>
+# Without MAIL_DRIVER=smtp the reset link is written to the server log
+
+curl --header "Content-Type: application/json" \
+  --request POST \
+  --data '{"login":"'"$1"'"}' \
+  http://localhost:8000/password/forgot
@@ -2,7 +2,5 @@
 
 # Usage ./get_albums.sh <token>
 
-curl --header "Content-Type: application/json" \
-  --request POST \
-  --data '{"token":"'"$1"'"}' \
+curl --header "Authorization: Bearer $1" \
   http://localhost:8000/album/list
@@ -0,0 +1,7 @@
+#!/usr/bin/env bash
+
+# Usage ./get_file.sh <token> <file_id> <output>
+
+curl --header "Authorization: Bearer $1" \
+  --output "$3" \
+  http://localhost:8000/file/$2
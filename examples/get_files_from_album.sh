@@ -1,8 +1,6 @@
 #!/usr/bin/env bash
 
-# Usage ./get_albums.sh <token>
+# Usage ./get_files_from_album.sh <token> <album_id>
 
-curl --header "Content-Type: application/json" \
-  --request POST \
-  --data '{"token":"'"$1"'", "album_id":'"$2"'}' \
-  http://localhost:8000/album/list
+curl --header "Authorization: Bearer $1" \
+  http://localhost:8000/album/$2/files
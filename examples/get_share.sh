@@ -1,16 +1,6 @@
 #!/usr/bin/env bash
 
-TMP_JSON=$(mktemp)
-TOKEN="$1"
+# Usage ./get_share.sh <token>
 
-cat > "$TMP_JSON" <<EOF
-{
-  "token": "$TOKEN"
-}
-EOF
-
-curl -X POST "localhost:8000/file/share/get" \
-  -H "Content-Type: application/json" \
-  -d @"$TMP_JSON"
-
-rm "$TMP_JSON"
+curl --header "Authorization: Bearer $1" \
+  http://localhost:8000/file/share/get
@@ -0,0 +1,10 @@
+#!/usr/bin/env bash
+
+# Usage ./login_2fa.sh <challenge> <code>
+# Second login step for accounts with two-factor authentication, the
+# challenge is returned by login.sh
+
+curl --header "Content-Type: application/json" \
+  --request POST \
+  --data '{"challenge":"'"$1"'","code":"'"$2"'"}' \
+  http://localhost:8000/login/2fa
@@ -1,9 +1,11 @@
 #!/usr/bin/env bash
 
-# Usage ./register.sh <login> <password>
+# Usage ./register.sh <login> <password> [email] [invite code]
+# The email is required with REGISTRATION_MODE=verify, the invite code
+# with REGISTRATION_MODE=invite
 
 
 curl --header "Content-Type: application/json" \
   --request POST \
-  --data '{"login":"'"$1"'","password":"'"$2"'"}' \
+  --data '{"login":"'"$1"'","password":"'"$2"'","email":"'"$3"'","invite_code":"'"$4"'"}' \
   http://localhost:8000/register
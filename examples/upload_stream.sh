@@ -0,0 +1,29 @@
+#!/usr/bin/env bash
+
+# Usage: ./upload_stream.sh <TOKEN> <FILE_NAME> [<TAG> ...]
+TOKEN="$1"
+FILE_NAME="$2"
+API_ENDPOINT="localhost:8000/file/upload/stream"
+
+if [[ -z "$TOKEN" || -z "$FILE_NAME" ]]; then
+  echo "Usage: $0 <TOKEN> <FILE_NAME> [<TAG> ...]"
+  exit 1
+fi
+
+if [[ ! -f "$FILE_NAME" ]]; then
+  echo "File '$FILE_NAME' does not exist."
+  exit 1
+fi
+
+shift 2  # Remaining arguments are tags
+
+TAGS=()
+for TAG in "$@"; do
+  TAGS+=(-F "tags=$TAG")
+done
+
+curl -X POST "$API_ENDPOINT" \
+  -H "Authorization: Bearer $TOKEN" \
+  -F "file_name=$(basename "$FILE_NAME")" \
+  "${TAGS[@]}" \
+  -F "file=@$FILE_NAME"
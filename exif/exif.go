@@ -0,0 +1,513 @@
+// Package exif reads camera metadata from the EXIF and XMP blocks of JPEG and
+// TIFF files. Only the fields the server stores are decoded.
+package exif
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"server/geo"
+)
+
+var (
+	ErrUnknownFormat = errors.New("exif: not a JPEG or TIFF file")
+	ErrInvalid       = errors.New("exif: invalid metadata")
+)
+
+// Limits on what a file can make the reader allocate.
+const (
+	maxEntries = 1000
+	maxValue   = 1 << 20
+)
+
+type Metadata struct {
+	// TakenAt is when the photo was taken, in UTC. Cameras often record no
+	// time zone, their clock is then read as UTC.
+	TakenAt time.Time
+	Make    string
+	Model   string
+	Lens    string
+	// ExposureTime is in seconds and FocalLength in millimeters. Numbers are
+	// 0 when unknown.
+	ExposureTime float64
+	FNumber      float64
+	ISO          int64
+	FocalLength  float64
+	// Orientation tells how the image has to be rotated and flipped for
+	// display, 1 to 8 as defined by EXIF or 0 when unknown.
+	Orientation int
+	// Location is where the photo was taken, nil when unknown.
+	Location *geo.Point
+}
+
+// Empty reports whether no field was found.
+func (m Metadata) Empty() bool {
+	return m == Metadata{}
+}
+
+// fill sets the fields of m that are still unknown from other.
+func (m *Metadata) fill(other Metadata) {
+	if m.TakenAt.IsZero() {
+		m.TakenAt = other.TakenAt
+	}
+	if m.Make == "" {
+		m.Make = other.Make
+	}
+	if m.Model == "" {
+		m.Model = other.Model
+	}
+	if m.Lens == "" {
+		m.Lens = other.Lens
+	}
+	if m.ExposureTime == 0 {
+		m.ExposureTime = other.ExposureTime
+	}
+	if m.FNumber == 0 {
+		m.FNumber = other.FNumber
+	}
+	if m.ISO == 0 {
+		m.ISO = other.ISO
+	}
+	if m.FocalLength == 0 {
+		m.FocalLength = other.FocalLength
+	}
+	if m.Orientation == 0 {
+		m.Orientation = other.Orientation
+	}
+	if m.Location == nil {
+		m.Location = other.Location
+	}
+}
+
+// Read reads the metadata of a JPEG or TIFF file. Fields found in both EXIF
+// and XMP are taken from EXIF. Broken parts of the metadata are skipped
+// when the rest can still be read.
+func Read(r io.ReadSeeker) (Metadata, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return Metadata{}, ErrUnknownFormat
+		}
+		return Metadata{}, err
+	}
+
+	switch {
+	case header[0] == 0xFF && header[1] == 0xD8:
+		return readJPEG(bufio.NewReader(io.MultiReader(bytes.NewReader(header[2:]), r)))
+	case string(header[:]) == "II*\x00" || string(header[:]) == "MM\x00*":
+		return readTIFF(seekReaderAt{r})
+	}
+
+	return Metadata{}, ErrUnknownFormat
+}
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+)
+
+// readJPEG reads the APP1 segments before the image data, the rest of the
+// file is never read.
+func readJPEG(r *bufio.Reader) (Metadata, error) {
+	var m, x Metadata
+	var foundExif, foundXMP bool
+
+	for {
+		marker, err := nextMarker(r)
+		if err != nil {
+			return Metadata{}, err
+		}
+
+		switch {
+		case marker == 0xDA || marker == 0xD9:
+			// Start of scan or end of image
+			m.fill(x)
+			return m, nil
+		case marker >= 0xD0 && marker <= 0xD7 || marker == 0x01:
+			// Markers without a segment
+			continue
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return Metadata{}, ErrInvalid
+		}
+
+		n := int(binary.BigEndian.Uint16(length[:])) - 2
+		if n < 0 {
+			return Metadata{}, ErrInvalid
+		}
+
+		if marker != 0xE1 {
+			if _, err := r.Discard(n); err != nil {
+				return Metadata{}, ErrInvalid
+			}
+			continue
+		}
+
+		segment := make([]byte, n)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return Metadata{}, ErrInvalid
+		}
+
+		switch {
+		case bytes.HasPrefix(segment, exifHeader) && !foundExif:
+			foundExif = true
+			if exif, err := readTIFF(bytes.NewReader(segment[len(exifHeader):])); err == nil {
+				m = exif
+			}
+		case bytes.HasPrefix(segment, xmpHeader) && !foundXMP:
+			foundXMP = true
+			if xmp, err := readXMP(segment[len(xmpHeader):]); err == nil {
+				x = xmp
+			}
+		}
+	}
+}
+
+// nextMarker reads the next marker, skipping the fill bytes before it.
+func nextMarker(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil || b != 0xFF {
+		return 0, ErrInvalid
+	}
+
+	for b == 0xFF {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, ErrInvalid
+		}
+	}
+
+	return b, nil
+}
+
+// seekReaderAt reads TIFF files from storage, which only offers seeking.
+type seekReaderAt struct {
+	r io.ReadSeeker
+}
+
+func (s seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := s.r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.ReadFull(s.r, p)
+}
+
+// TIFF tags read from the first IFD, the Exif IFD and the GPS IFD.
+const (
+	tagMake              = 0x010F
+	tagModel             = 0x0110
+	tagOrientation       = 0x0112
+	tagDateTime          = 0x0132
+	tagXMP               = 0x02BC
+	tagExifIFD           = 0x8769
+	tagGPSIFD            = 0x8825
+	tagExposureTime      = 0x829A
+	tagFNumber           = 0x829D
+	tagISO               = 0x8827
+	tagDateTimeOriginal  = 0x9003
+	tagDateTimeDigitized = 0x9004
+	tagOffsetTime        = 0x9010
+	tagOffsetOriginal    = 0x9011
+	tagOffsetDigitized   = 0x9012
+	tagFocalLength       = 0x920A
+	tagLensModel         = 0xA434
+	tagGPSLatitudeRef    = 0x0001
+	tagGPSLatitude       = 0x0002
+	tagGPSLongitudeRef   = 0x0003
+	tagGPSLongitude      = 0x0004
+)
+
+// typeSizes are the sizes in bytes of the TIFF field types by number.
+var typeSizes = map[uint16]uint32{
+	1:  1, // BYTE
+	2:  1, // ASCII
+	3:  2, // SHORT
+	4:  4, // LONG
+	5:  8, // RATIONAL
+	7:  1, // UNDEFINED
+	9:  4, // SLONG
+	10: 8, // SRATIONAL
+}
+
+type entry struct {
+	typ   uint16
+	count uint32
+	// raw holds the value when it fits into 4 bytes, else its offset
+	raw [4]byte
+}
+
+type tiff struct {
+	r     io.ReaderAt
+	order binary.ByteOrder
+}
+
+type ifd map[uint16]entry
+
+func readTIFF(r io.ReaderAt) (Metadata, error) {
+	var header [8]byte
+	if _, err := r.ReadAt(header[:], 0); err != nil {
+		return Metadata{}, ErrInvalid
+	}
+
+	t := &tiff{r: r}
+	switch string(header[:4]) {
+	case "II*\x00":
+		t.order = binary.LittleEndian
+	case "MM\x00*":
+		t.order = binary.BigEndian
+	default:
+		return Metadata{}, ErrInvalid
+	}
+
+	ifd0, err := t.ifd(t.order.Uint32(header[4:]))
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	// A missing or broken sub IFD leaves its fields unknown
+	exif, gps := ifd{}, ifd{}
+	if offset, ok := t.uint(ifd0, tagExifIFD); ok {
+		if sub, err := t.ifd(offset); err == nil {
+			exif = sub
+		}
+	}
+	if offset, ok := t.uint(ifd0, tagGPSIFD); ok {
+		if sub, err := t.ifd(offset); err == nil {
+			gps = sub
+		}
+	}
+
+	m := Metadata{
+		Make:  t.ascii(ifd0, tagMake),
+		Model: t.ascii(ifd0, tagModel),
+		Lens:  t.ascii(exif, tagLensModel),
+	}
+
+	if orientation, ok := t.uint(ifd0, tagOrientation); ok && orientation >= 1 && orientation <= 8 {
+		m.Orientation = int(orientation)
+	}
+
+	for _, tags := range [][2]uint16{
+		{tagDateTimeOriginal, tagOffsetOriginal},
+		{tagDateTimeDigitized, tagOffsetDigitized},
+		{tagDateTime, tagOffsetTime},
+	} {
+		from := exif
+		if tags[0] == tagDateTime {
+			from = ifd0
+		}
+
+		if taken, ok := parseTime(t.ascii(from, tags[0]), t.ascii(exif, tags[1])); ok {
+			m.TakenAt = taken
+			break
+		}
+	}
+
+	m.ExposureTime, _ = t.rational(exif, tagExposureTime, 0)
+	m.FNumber, _ = t.rational(exif, tagFNumber, 0)
+	m.FocalLength, _ = t.rational(exif, tagFocalLength, 0)
+	if iso, ok := t.uint(exif, tagISO); ok {
+		m.ISO = int64(iso)
+	}
+
+	m.Location = t.location(gps)
+
+	if e, ok := ifd0[tagXMP]; ok && (e.typ == 1 || e.typ == 7) {
+		if packet, ok := t.value(e); ok {
+			if xmp, err := readXMP(packet); err == nil {
+				m.fill(xmp)
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// ifd reads the entries of the IFD at offset.
+func (t *tiff) ifd(offset uint32) (ifd, error) {
+	var count [2]byte
+	if !t.read(count[:], offset) {
+		return nil, ErrInvalid
+	}
+
+	n := int(t.order.Uint16(count[:]))
+	if n > maxEntries {
+		return nil, ErrInvalid
+	}
+
+	buf := make([]byte, n*12)
+	if !t.read(buf, offset+2) {
+		return nil, ErrInvalid
+	}
+
+	entries := make(ifd, n)
+	for i := 0; i < n; i++ {
+		b := buf[i*12 : i*12+12]
+
+		e := entry{typ: t.order.Uint16(b[2:]), count: t.order.Uint32(b[4:])}
+		copy(e.raw[:], b[8:])
+		entries[t.order.Uint16(b)] = e
+	}
+
+	return entries, nil
+}
+
+func (t *tiff) read(p []byte, offset uint32) bool {
+	n, err := t.r.ReadAt(p, int64(offset))
+	return n == len(p) && (err == nil || err == io.EOF)
+}
+
+// value returns the bytes of an entry.
+func (t *tiff) value(e entry) ([]byte, bool) {
+	size, ok := typeSizes[e.typ]
+	if !ok {
+		return nil, false
+	}
+
+	length := uint64(size) * uint64(e.count)
+	if length > maxValue {
+		return nil, false
+	}
+	if length <= 4 {
+		return e.raw[:length], true
+	}
+
+	value := make([]byte, length)
+	if !t.read(value, t.order.Uint32(e.raw[:])) {
+		return nil, false
+	}
+
+	return value, true
+}
+
+// ascii returns a text value without its terminating NUL.
+func (t *tiff) ascii(entries ifd, tag uint16) string {
+	e, ok := entries[tag]
+	if !ok || e.typ != 2 {
+		return ""
+	}
+
+	value, ok := t.value(e)
+	if !ok {
+		return ""
+	}
+
+	if i := bytes.IndexByte(value, 0); i >= 0 {
+		value = value[:i]
+	}
+
+	if !utf8.Valid(value) {
+		return ""
+	}
+	return strings.TrimSpace(string(value))
+}
+
+// uint returns the first number of a SHORT or LONG value.
+func (t *tiff) uint(entries ifd, tag uint16) (uint32, bool) {
+	e, ok := entries[tag]
+	if !ok || e.count == 0 {
+		return 0, false
+	}
+
+	switch e.typ {
+	case 3:
+		return uint32(t.order.Uint16(e.raw[:])), true
+	case 4:
+		return t.order.Uint32(e.raw[:]), true
+	}
+
+	return 0, false
+}
+
+// rational returns the i-th number of a RATIONAL or SRATIONAL value.
+func (t *tiff) rational(entries ifd, tag uint16, i uint32) (float64, bool) {
+	e, ok := entries[tag]
+	if !ok || (e.typ != 5 && e.typ != 10) || i >= e.count {
+		return 0, false
+	}
+
+	value, ok := t.value(e)
+	if !ok {
+		return 0, false
+	}
+
+	num, den := t.order.Uint32(value[i*8:]), t.order.Uint32(value[i*8+4:])
+	if den == 0 {
+		return 0, false
+	}
+
+	if e.typ == 10 {
+		return float64(int32(num)) / float64(int32(den)), true
+	}
+	return float64(num) / float64(den), true
+}
+
+// location reads the position from the GPS IFD.
+func (t *tiff) location(gps ifd) *geo.Point {
+	lat, ok := t.dms(gps, tagGPSLatitude)
+	if !ok {
+		return nil
+	}
+
+	lon, ok := t.dms(gps, tagGPSLongitude)
+	if !ok {
+		return nil
+	}
+
+	if t.ascii(gps, tagGPSLatitudeRef) == "S" {
+		lat = -lat
+	}
+	if t.ascii(gps, tagGPSLongitudeRef) == "W" {
+		lon = -lon
+	}
+
+	p := geo.Point{Lat: lat, Lon: lon}
+	if !p.Valid() {
+		return nil
+	}
+
+	return &p
+}
+
+// dms reads degrees, minutes and seconds as decimal degrees.
+func (t *tiff) dms(gps ifd, tag uint16) (float64, bool) {
+	var d float64
+	for i, unit := range []float64{1, 60, 3600} {
+		part, ok := t.rational(gps, tag, uint32(i))
+		if !ok || part < 0 || math.IsInf(part, 0) {
+			return 0, false
+		}
+		d += part / unit
+	}
+
+	return d, true
+}
+
+// parseTime reads an EXIF date like "2024:07:01 12:30:00" with an optional
+// offset like "+02:00".
+func parseTime(value, offset string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+
+	if offset != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05-07:00", value+offset); err == nil {
+			return t.UTC(), true
+		}
+	}
+
+	t, err := time.Parse("2006:01:02 15:04:05", value)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
@@ -0,0 +1,504 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"server/geo"
+)
+
+// Fixtures are built field by field so each test shows what the file holds.
+
+// field is an IFD entry of a fixture. value is a string (ASCII), uint16
+// (SHORT), uint32 (LONG), []rational (RATIONAL), []byte (UNDEFINED) or
+// []field, a sub IFD whose offset is written as a LONG.
+type field struct {
+	tag   uint16
+	value any
+}
+
+type rational [2]uint32
+
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+type tiffWriter struct {
+	order byteOrder
+	buf   []byte
+}
+
+// buildTIFF writes a TIFF file with ifd0 as its first IFD.
+func buildTIFF(order byteOrder, ifd0 []field) []byte {
+	w := &tiffWriter{order: order, buf: []byte("II*\x00\x00\x00\x00\x00")}
+	if order == binary.BigEndian {
+		copy(w.buf, "MM\x00*")
+	}
+
+	offset := w.ifd(ifd0)
+	order.PutUint32(w.buf[4:], offset)
+	return w.buf
+}
+
+func (w *tiffWriter) ifd(fields []field) uint32 {
+	offset := len(w.buf)
+	w.buf = append(w.buf, make([]byte, 2+len(fields)*12+4)...)
+	w.order.PutUint16(w.buf[offset:], uint16(len(fields)))
+
+	for i, f := range fields {
+		typ, count, raw := w.encode(f.value)
+
+		p := offset + 2 + i*12
+		w.order.PutUint16(w.buf[p:], f.tag)
+		w.order.PutUint16(w.buf[p+2:], typ)
+		w.order.PutUint32(w.buf[p+4:], count)
+
+		if len(raw) <= 4 {
+			copy(w.buf[p+8:], raw)
+			continue
+		}
+
+		w.order.PutUint32(w.buf[p+8:], uint32(len(w.buf)))
+		w.buf = append(w.buf, raw...)
+	}
+
+	return uint32(offset)
+}
+
+func (w *tiffWriter) encode(value any) (uint16, uint32, []byte) {
+	switch v := value.(type) {
+	case string:
+		return 2, uint32(len(v) + 1), append([]byte(v), 0)
+	case uint16:
+		return 3, 1, w.order.AppendUint16(nil, v)
+	case uint32:
+		return 4, 1, w.order.AppendUint32(nil, v)
+	case []rational:
+		var raw []byte
+		for _, r := range v {
+			raw = w.order.AppendUint32(raw, r[0])
+			raw = w.order.AppendUint32(raw, r[1])
+		}
+		return 5, uint32(len(v)), raw
+	case []byte:
+		return 7, uint32(len(v)), v
+	case []field:
+		return 4, 1, w.order.AppendUint32(nil, w.ifd(v))
+	}
+	panic("unsupported fixture value")
+}
+
+// buildJPEG wraps the APP1 payloads into a JPEG file without image data.
+func buildJPEG(app1 ...[]byte) []byte {
+	b := []byte{0xFF, 0xD8}
+	for _, payload := range app1 {
+		b = append(b, 0xFF, 0xE1)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(payload)+2))
+		b = append(b, payload...)
+	}
+	return append(b, 0xFF, 0xDA, 0x00, 0x02, 0x00, 0xFF, 0xD9)
+}
+
+func exifSegment(tiff []byte) []byte {
+	return append([]byte("Exif\x00\x00"), tiff...)
+}
+
+func xmpSegment(packet string) []byte {
+	return append([]byte("http://ns.adobe.com/xap/1.0/\x00"), packet...)
+}
+
+// gps is a GPS IFD for the position in degrees, minutes and seconds.
+func gps(latRef string, lat [3]uint32, lonRef string, lon [3]uint32) []field {
+	return []field{
+		{tagGPSLatitudeRef, latRef},
+		{tagGPSLatitude, []rational{{lat[0], 1}, {lat[1], 1}, {lat[2], 1}}},
+		{tagGPSLongitudeRef, lonRef},
+		{tagGPSLongitude, []rational{{lon[0], 1}, {lon[1], 1}, {lon[2], 1}}},
+	}
+}
+
+// camera are the fields of a typical photo, taken in Kraków.
+func camera() []field {
+	return []field{
+		{tagMake, "FUJIFILM"},
+		{tagModel, "X-T3"},
+		{tagOrientation, uint16(6)},
+		{tagExifIFD, []field{
+			{tagExposureTime, []rational{{1, 250}}},
+			{tagFNumber, []rational{{28, 10}}},
+			{tagISO, uint16(400)},
+			{tagDateTimeOriginal, "2024:07:01 12:30:00"},
+			{tagOffsetOriginal, "+02:00"},
+			{tagFocalLength, []rational{{23, 1}}},
+			{tagLensModel, "XF23mmF1.4 R"},
+		}},
+		{tagGPSIFD, gps("N", [3]uint32{50, 3, 41}, "E", [3]uint32{19, 56, 12})},
+	}
+}
+
+func read(t *testing.T, data []byte) Metadata {
+	t.Helper()
+
+	m, err := Read(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	return m
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func checkLocation(t *testing.T, got *geo.Point, want *geo.Point) {
+	t.Helper()
+
+	switch {
+	case got == nil && want == nil:
+	case got == nil || want == nil:
+		t.Errorf("Location = %v, want %v", got, want)
+	case !near(got.Lat, want.Lat) || !near(got.Lon, want.Lon):
+		t.Errorf("Location = %v, want %v", *got, *want)
+	}
+}
+
+func TestReadByteOrder(t *testing.T) {
+	want := Metadata{
+		TakenAt:      time.Date(2024, 7, 1, 10, 30, 0, 0, time.UTC),
+		Make:         "FUJIFILM",
+		Model:        "X-T3",
+		Lens:         "XF23mmF1.4 R",
+		ExposureTime: 1.0 / 250,
+		FNumber:      2.8,
+		ISO:          400,
+		FocalLength:  23,
+		Orientation:  6,
+		Location:     &geo.Point{Lat: 50 + 3.0/60 + 41.0/3600, Lon: 19 + 56.0/60 + 12.0/3600},
+	}
+
+	for _, order := range []byteOrder{binary.LittleEndian, binary.BigEndian} {
+		tiff := buildTIFF(order, camera())
+
+		for name, data := range map[string][]byte{
+			"TIFF": tiff,
+			"JPEG": buildJPEG(exifSegment(tiff)),
+		} {
+			t.Run(order.String()+" "+name, func(t *testing.T) {
+				got := read(t, data)
+
+				checkLocation(t, got.Location, want.Location)
+				want := want
+				want.Location = got.Location
+
+				if got != want {
+					t.Errorf("Read = %+v, want %+v", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestReadGPS(t *testing.T) {
+	tests := []struct {
+		name string
+		gps  []field
+		want *geo.Point
+	}{
+		{"north east", gps("N", [3]uint32{50, 30, 0}, "E", [3]uint32{19, 15, 0}), &geo.Point{Lat: 50.5, Lon: 19.25}},
+		{"south west", gps("S", [3]uint32{33, 52, 12}, "W", [3]uint32{70, 30, 0}), &geo.Point{Lat: -(33 + 52.0/60 + 12.0/3600), Lon: -70.5}},
+		{"south east", gps("S", [3]uint32{33, 0, 0}, "E", [3]uint32{151, 12, 36}), &geo.Point{Lat: -33, Lon: 151.21}},
+		{"north west", gps("N", [3]uint32{40, 45, 0}, "W", [3]uint32{73, 59, 24}), &geo.Point{Lat: 40.75, Lon: -73.99}},
+		{"missing refs", []field{
+			{tagGPSLatitude, []rational{{10, 1}, {0, 1}, {0, 1}}},
+			{tagGPSLongitude, []rational{{20, 1}, {0, 1}, {0, 1}}},
+		}, &geo.Point{Lat: 10, Lon: 20}},
+		{"latitude out of range", gps("N", [3]uint32{91, 0, 0}, "E", [3]uint32{0, 0, 0}), nil},
+		{"longitude out of range", gps("N", [3]uint32{0, 0, 0}, "W", [3]uint32{181, 0, 0}), nil},
+		{"no longitude", gps("N", [3]uint32{50, 0, 0}, "E", [3]uint32{19, 0, 0})[:2], nil},
+		{"zero denominator", []field{
+			{tagGPSLatitude, []rational{{50, 1}, {0, 0}, {0, 1}}},
+			{tagGPSLongitude, []rational{{19, 1}, {0, 1}, {0, 1}}},
+		}, nil},
+		{"too few parts", []field{
+			{tagGPSLatitude, []rational{{50, 1}, {30, 1}}},
+			{tagGPSLongitude, []rational{{19, 1}, {0, 1}, {0, 1}}},
+		}, nil},
+		{"wrong type", []field{
+			{tagGPSLatitude, uint32(50)},
+			{tagGPSLongitude, []rational{{19, 1}, {0, 1}, {0, 1}}},
+		}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, order := range []byteOrder{binary.LittleEndian, binary.BigEndian} {
+				m := read(t, buildTIFF(order, []field{{tagGPSIFD, tt.gps}}))
+				checkLocation(t, m.Location, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadTime(t *testing.T) {
+	tests := []struct {
+		name string
+		ifd0 []field
+		exif []field
+		want time.Time
+	}{
+		{
+			"original with offset",
+			nil,
+			[]field{{tagDateTimeOriginal, "2024:07:01 12:30:00"}, {tagOffsetOriginal, "+02:00"}},
+			time.Date(2024, 7, 1, 10, 30, 0, 0, time.UTC),
+		},
+		{
+			"negative offset crossing midnight",
+			nil,
+			[]field{{tagDateTimeOriginal, "2024:12:31 21:15:00"}, {tagOffsetOriginal, "-05:00"}},
+			time.Date(2025, 1, 1, 2, 15, 0, 0, time.UTC),
+		},
+		{
+			"no offset is read as UTC",
+			nil,
+			[]field{{tagDateTimeOriginal, "2024:07:01 12:30:00"}},
+			time.Date(2024, 7, 1, 12, 30, 0, 0, time.UTC),
+		},
+		{
+			"broken offset is ignored",
+			nil,
+			[]field{{tagDateTimeOriginal, "2024:07:01 12:30:00"}, {tagOffsetOriginal, "CEST"}},
+			time.Date(2024, 7, 1, 12, 30, 0, 0, time.UTC),
+		},
+		{
+			"offset of another time is not used",
+			nil,
+			[]field{{tagDateTimeOriginal, "2024:07:01 12:30:00"}, {tagOffsetDigitized, "+02:00"}},
+			time.Date(2024, 7, 1, 12, 30, 0, 0, time.UTC),
+		},
+		{
+			"digitized when original is unset",
+			nil,
+			[]field{
+				{tagDateTimeOriginal, "0000:00:00 00:00:00"},
+				{tagDateTimeDigitized, "2024:07:02 08:00:00"},
+				{tagOffsetDigitized, "+09:00"},
+			},
+			time.Date(2024, 7, 1, 23, 0, 0, 0, time.UTC),
+		},
+		{
+			"modification time last",
+			[]field{{tagDateTime, "2024:07:03 18:00:00"}},
+			[]field{{tagOffsetTime, "+01:00"}},
+			time.Date(2024, 7, 3, 17, 0, 0, 0, time.UTC),
+		},
+		{
+			"original before modification time",
+			[]field{{tagDateTime, "2024:07:03 18:00:00"}},
+			[]field{{tagDateTimeOriginal, "2024:07:01 12:30:00"}},
+			time.Date(2024, 7, 1, 12, 30, 0, 0, time.UTC),
+		},
+		{
+			"invalid date",
+			nil,
+			[]field{{tagDateTimeOriginal, "2024-07-01T12:30:00"}},
+			time.Time{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ifd0 := append(tt.ifd0, field{tagExifIFD, tt.exif})
+
+			m := read(t, buildTIFF(binary.LittleEndian, ifd0))
+			if !m.TakenAt.Equal(tt.want) {
+				t.Errorf("TakenAt = %v, want %v", m.TakenAt, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadXMP(t *testing.T) {
+	const packet = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description
+    xmlns:tiff="http://ns.adobe.com/tiff/1.0/"
+    xmlns:exif="http://ns.adobe.com/exif/1.0/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    tiff:Make="Canon"
+    tiff:Model="EOS R6"
+    exif:FNumber="40/10"
+    exif:GPSLatitude="33,52.2S"
+    exif:GPSLongitude="151,12.6E"
+    xmp:CreateDate="2024-07-01T12:30:00+10:00">
+   <exif:ISOSpeedRatings><rdf:Seq><rdf:li>800</rdf:li></rdf:Seq></exif:ISOSpeedRatings>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+	t.Run("alone", func(t *testing.T) {
+		m := read(t, buildJPEG(xmpSegment(packet)))
+
+		if m.Make != "Canon" || m.Model != "EOS R6" || m.FNumber != 4 || m.ISO != 800 {
+			t.Errorf("Read = %+v", m)
+		}
+		if want := time.Date(2024, 7, 1, 2, 30, 0, 0, time.UTC); !m.TakenAt.Equal(want) {
+			t.Errorf("TakenAt = %v, want %v", m.TakenAt, want)
+		}
+		checkLocation(t, m.Location, &geo.Point{Lat: -(33 + 52.2/60), Lon: 151 + 12.6/60})
+	})
+
+	t.Run("EXIF first", func(t *testing.T) {
+		tiff := buildTIFF(binary.BigEndian, []field{
+			{tagMake, "FUJIFILM"},
+			{tagExifIFD, []field{{tagISO, uint16(200)}}},
+		})
+
+		// The order of the segments does not matter
+		m := read(t, buildJPEG(xmpSegment(packet), exifSegment(tiff)))
+
+		if m.Make != "FUJIFILM" || m.ISO != 200 {
+			t.Errorf("EXIF fields were not kept: %+v", m)
+		}
+		if m.Model != "EOS R6" || m.FNumber != 4 {
+			t.Errorf("missing fields were not filled from XMP: %+v", m)
+		}
+	})
+
+	t.Run("in TIFF", func(t *testing.T) {
+		m := read(t, buildTIFF(binary.LittleEndian, []field{{tagXMP, []byte(packet)}}))
+
+		if m.Make != "Canon" {
+			t.Errorf("Read = %+v", m)
+		}
+	})
+}
+
+func TestReadGarbage(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":    {},
+		"short":    {0xFF},
+		"text":     []byte("hello, world"),
+		"PNG":      []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"),
+		"GIF":      []byte("GIF89a"),
+		"TIFF-ish": []byte("II+\x00\x08\x00\x00\x00"),
+	} {
+		if _, err := Read(bytes.NewReader(data)); !errors.Is(err, ErrUnknownFormat) {
+			t.Errorf("%s: error = %v, want ErrUnknownFormat", name, err)
+		}
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	tiff := buildTIFF(binary.BigEndian, camera())
+	jpeg := buildJPEG(exifSegment(tiff), xmpSegment(`<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`))
+	sos := bytes.Index(jpeg, []byte{0xFF, 0xDA})
+
+	// JPEG files cut before the image data are invalid
+	for n := 4; n <= sos+1; n++ {
+		if _, err := Read(bytes.NewReader(jpeg[:n])); !errors.Is(err, ErrInvalid) {
+			t.Errorf("JPEG cut at %d: error = %v, want ErrInvalid", n, err)
+		}
+	}
+
+	// TIFF files cut anywhere lose fields but must not fail reading the rest
+	for n := 4; n < len(tiff); n++ {
+		m, err := Read(bytes.NewReader(tiff[:n]))
+		if err != nil && !errors.Is(err, ErrInvalid) {
+			t.Errorf("TIFF cut at %d: error = %v", n, err)
+		}
+		if err == nil && m.Make != "" && m.Make != "FUJIFILM" {
+			t.Errorf("TIFF cut at %d: Make = %q", n, m.Make)
+		}
+	}
+}
+
+func TestReadBroken(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+		want Metadata
+	}{
+		{
+			"IFD beyond the end",
+			[]byte("II*\x00\xff\x00\x00\x00"),
+			ErrInvalid,
+			Metadata{},
+		},
+		{
+			"too many entries",
+			append([]byte("II*\x00\x08\x00\x00\x00"), 0xFF, 0xFF),
+			ErrInvalid,
+			Metadata{},
+		},
+		{
+			"broken Exif IFD keeps the rest",
+			buildTIFF(binary.LittleEndian, []field{{tagMake, "FUJIFILM"}, {tagExifIFD, uint32(1 << 30)}}),
+			nil,
+			Metadata{Make: "FUJIFILM"},
+		},
+		{
+			"value beyond the end",
+			func() []byte {
+				tiff := buildTIFF(binary.LittleEndian, []field{{tagOrientation, uint16(3)}, {tagModel, "a long model name"}})
+				return tiff[:len(tiff)-8]
+			}(),
+			nil,
+			Metadata{Orientation: 3},
+		},
+		{
+			"invalid orientation",
+			buildTIFF(binary.LittleEndian, []field{{tagOrientation, uint16(9)}}),
+			nil,
+			Metadata{},
+		},
+		{
+			"text that is not UTF-8",
+			buildTIFF(binary.LittleEndian, []field{{tagMake, "\xff\xfe"}, {tagModel, "X-T3"}}),
+			nil,
+			Metadata{Model: "X-T3"},
+		},
+		{
+			"garbage Exif segment",
+			buildJPEG(exifSegment([]byte("not a TIFF header"))),
+			nil,
+			Metadata{},
+		},
+		{
+			"garbage XMP segment",
+			buildJPEG(xmpSegment("<x:xmpmeta><unclosed")),
+			nil,
+			Metadata{},
+		},
+		{
+			"segment length below 2",
+			[]byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x01},
+			ErrInvalid,
+			Metadata{},
+		},
+		{
+			"no marker after SOI",
+			[]byte{0xFF, 0xD8, 0x00, 0x00},
+			ErrInvalid,
+			Metadata{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Read(bytes.NewReader(tt.data))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			if m != tt.want {
+				t.Errorf("Read = %+v, want %+v", m, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"server/geo"
+)
+
+// Namespaces of the XMP properties that are read.
+const (
+	nsRDF       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsTIFF      = "http://ns.adobe.com/tiff/1.0/"
+	nsExif      = "http://ns.adobe.com/exif/1.0/"
+	nsExifEX    = "http://cipa.jp/exif/1.0/"
+	nsAux       = "http://ns.adobe.com/exif/1.0/aux/"
+	nsXMP       = "http://ns.adobe.com/xap/1.0/"
+	nsPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+)
+
+var namespaces = map[string]bool{
+	nsTIFF:      true,
+	nsExif:      true,
+	nsExifEX:    true,
+	nsAux:       true,
+	nsXMP:       true,
+	nsPhotoshop: true,
+}
+
+// xmpTimes are the forms of ISO 8601 dates found in XMP.
+var xmpTimes = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// readXMP reads an XMP packet. Properties are written either as attributes
+// of rdf:Description or as elements, lists like exif:ISOSpeedRatings hold
+// their values in rdf:li elements of which the first is used.
+func readXMP(packet []byte) (Metadata, error) {
+	props := map[xml.Name]string{}
+	set := func(name xml.Name, value string) {
+		value = strings.TrimSpace(value)
+		if _, ok := props[name]; !ok && value != "" {
+			props[name] = value
+		}
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(packet))
+
+	var open []xml.Name
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Metadata{}, ErrInvalid
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			for _, attr := range t.Attr {
+				if namespaces[attr.Name.Space] {
+					set(attr.Name, attr.Value)
+				}
+			}
+			open = append(open, t.Name)
+		case xml.EndElement:
+			open = open[:len(open)-1]
+		case xml.CharData:
+			if name, ok := property(open); ok {
+				set(name, string(t))
+			}
+		}
+	}
+
+	m := Metadata{
+		Make:  props[xml.Name{Space: nsTIFF, Local: "Make"}],
+		Model: props[xml.Name{Space: nsTIFF, Local: "Model"}],
+		Lens:  first(props, xml.Name{Space: nsExifEX, Local: "LensModel"}, xml.Name{Space: nsAux, Local: "Lens"}),
+	}
+
+	taken := first(props,
+		xml.Name{Space: nsExif, Local: "DateTimeOriginal"},
+		xml.Name{Space: nsXMP, Local: "CreateDate"},
+		xml.Name{Space: nsPhotoshop, Local: "DateCreated"},
+	)
+	for _, layout := range xmpTimes {
+		if t, err := time.Parse(layout, taken); err == nil {
+			m.TakenAt = t.UTC()
+			break
+		}
+	}
+
+	m.ExposureTime = parseRational(props[xml.Name{Space: nsExif, Local: "ExposureTime"}])
+	m.FNumber = parseRational(props[xml.Name{Space: nsExif, Local: "FNumber"}])
+	m.FocalLength = parseRational(props[xml.Name{Space: nsExif, Local: "FocalLength"}])
+
+	iso := first(props, xml.Name{Space: nsExifEX, Local: "PhotographicSensitivity"}, xml.Name{Space: nsExif, Local: "ISOSpeedRatings"})
+	if n, err := strconv.ParseInt(iso, 10, 64); err == nil && n > 0 {
+		m.ISO = n
+	}
+
+	orientation := props[xml.Name{Space: nsTIFF, Local: "Orientation"}]
+	if n, err := strconv.Atoi(orientation); err == nil && n >= 1 && n <= 8 {
+		m.Orientation = n
+	}
+
+	lat, latOK := parseXMPCoordinate(props[xml.Name{Space: nsExif, Local: "GPSLatitude"}], "NS")
+	lon, lonOK := parseXMPCoordinate(props[xml.Name{Space: nsExif, Local: "GPSLongitude"}], "EW")
+	if p := (geo.Point{Lat: lat, Lon: lon}); latOK && lonOK && p.Valid() {
+		m.Location = &p
+	}
+
+	return m, nil
+}
+
+// property names the property whose text is read inside the open elements,
+// either the innermost element or the list holding an rdf:li.
+func property(open []xml.Name) (xml.Name, bool) {
+	n := len(open)
+	if n == 0 {
+		return xml.Name{}, false
+	}
+
+	if namespaces[open[n-1].Space] {
+		return open[n-1], true
+	}
+
+	// <exif:ISOSpeedRatings><rdf:Seq><rdf:li>200</rdf:li></rdf:Seq></exif:ISOSpeedRatings>
+	if n >= 3 && open[n-1] == (xml.Name{Space: nsRDF, Local: "li"}) && open[n-2].Space == nsRDF && namespaces[open[n-3].Space] {
+		return open[n-3], true
+	}
+
+	return xml.Name{}, false
+}
+
+func first(props map[xml.Name]string, names ...xml.Name) string {
+	for _, name := range names {
+		if value := props[name]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// parseRational reads numbers written as "1/250" or "2.8", 0 when invalid.
+func parseRational(s string) float64 {
+	num, den, found := strings.Cut(s, "/")
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+
+	if !found {
+		return n
+	}
+
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+
+	return n / d
+}
+
+// parseXMPCoordinate reads an XMP GPS coordinate, "DDD,MM,SSk" or "DDD,MM.mmk"
+// where k is one of the two hemisphere letters in refs.
+func parseXMPCoordinate(s string, refs string) (float64, bool) {
+	if len(s) < 2 {
+		return 0, false
+	}
+
+	ref := strings.ToUpper(s[len(s)-1:])
+	if !strings.Contains(refs, ref) {
+		return 0, false
+	}
+
+	parts := strings.Split(s[:len(s)-1], ",")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, false
+	}
+
+	var d float64
+	for i, unit := range []float64{1, 60, 3600}[:len(parts)] {
+		part, err := strconv.ParseFloat(parts[i], 64)
+		if err != nil || part < 0 {
+			return 0, false
+		}
+		d += part / unit
+	}
+
+	if ref == refs[1:] {
+		d = -d
+	}
+
+	return d, true
+}
@@ -0,0 +1,187 @@
+// Package geo parses the coordinates stored with files and does the
+// distance math for location searches.
+package geo
+
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// EarthRadius is the mean radius of the earth in meters.
+const EarthRadius = 6371008.8
+
+var ErrInvalid = errors.New("invalid coordinates")
+
+type Point struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// coordinate is one of latitude or longitude: decimal degrees or degrees,
+// minutes and seconds, followed by an optional hemisphere.
+const coordinate = `([+-]?\d+(?:\.\d+)?)\s*°?\s*` +
+	`(?:(\d+(?:\.\d+)?)\s*['′]\s*)?` +
+	`(?:(\d+(?:\.\d+)?)\s*(?:["″]|'')\s*)?` +
+	`([NSEWnsew])?`
+
+var coordinates = regexp.MustCompile(`^` + coordinate + `\s*[,;\s]\s*` + coordinate + `$`)
+
+// Parse reads the latitude and longitude from text like "50.0614, 19.9366",
+// "50.0614N 19.9366E", `50°3'41"N 19°56'12"E` or "geo:50.0614,19.9366".
+// Without hemispheres the latitude comes first.
+func Parse(s string) (Point, error) {
+	s = strings.TrimSpace(s)
+
+	// geo: URIs may carry an altitude and parameters after the position
+	if len(s) > 4 && strings.EqualFold(s[:4], "geo:") {
+		s, _, _ = strings.Cut(s[4:], ";")
+		if parts := strings.Split(s, ","); len(parts) > 2 {
+			s = parts[0] + "," + parts[1]
+		}
+	}
+
+	m := coordinates.FindStringSubmatch(s)
+	if m == nil {
+		return Point{}, ErrInvalid
+	}
+
+	first, firstHemisphere, err := degrees(m[1:5])
+	if err != nil {
+		return Point{}, err
+	}
+
+	second, secondHemisphere, err := degrees(m[5:9])
+	if err != nil {
+		return Point{}, err
+	}
+
+	lat, latHemisphere, lon, lonHemisphere := first, firstHemisphere, second, secondHemisphere
+	if strings.ContainsAny(firstHemisphere, "EW") || strings.ContainsAny(secondHemisphere, "NS") {
+		lat, latHemisphere, lon, lonHemisphere = second, secondHemisphere, first, firstHemisphere
+	}
+
+	if strings.ContainsAny(latHemisphere, "EW") || strings.ContainsAny(lonHemisphere, "NS") {
+		return Point{}, ErrInvalid
+	}
+
+	p := Point{Lat: lat, Lon: lon}
+	if !p.Valid() {
+		return Point{}, ErrInvalid
+	}
+
+	return p, nil
+}
+
+// degrees converts one matched coordinate to signed decimal degrees.
+func degrees(m []string) (float64, string, error) {
+	var parts [3]float64
+	for i, value := range m[:3] {
+		if value == "" {
+			continue
+		}
+
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, "", ErrInvalid
+		}
+		parts[i] = f
+	}
+
+	if parts[1] >= 60 || parts[2] >= 60 {
+		return 0, "", ErrInvalid
+	}
+
+	negative := strings.HasPrefix(m[0], "-")
+	d := math.Abs(parts[0]) + parts[1]/60 + parts[2]/3600
+
+	hemisphere := strings.ToUpper(m[3])
+	if hemisphere != "" && negative {
+		return 0, "", ErrInvalid
+	}
+	if negative || hemisphere == "S" || hemisphere == "W" {
+		d = -d
+	}
+
+	return d, hemisphere, nil
+}
+
+func (p Point) Valid() bool {
+	return p.Lat >= -90 && p.Lat <= 90 && p.Lon >= -180 && p.Lon <= 180
+}
+
+// Distance returns the great-circle distance between p and q in meters.
+func Distance(p, q Point) float64 {
+	lat1, lat2 := radians(p.Lat), radians(q.Lat)
+	dLat, dLon := lat2-lat1, radians(q.Lon-p.Lon)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+func radians(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+// Box is an area between two latitudes and two longitudes. MinLon is never
+// greater than MaxLon, areas across the antimeridian are split in two.
+type Box struct {
+	MinLat, MaxLat float64
+	MinLon, MaxLon float64
+}
+
+// BoundingBox returns the boxes covering the area from west to east and
+// south to north. west may be greater than east when the area crosses the
+// antimeridian.
+func BoundingBox(west, south, east, north float64) ([]Box, error) {
+	corners := []Point{{south, west}, {north, east}}
+	for _, p := range corners {
+		if !p.Valid() {
+			return nil, ErrInvalid
+		}
+	}
+
+	if south > north {
+		return nil, ErrInvalid
+	}
+
+	if west > east {
+		return []Box{{south, north, west, 180}, {south, north, -180, east}}, nil
+	}
+
+	return []Box{{south, north, west, east}}, nil
+}
+
+// Around returns the boxes covering every point within radius meters of p.
+// They cover a bit more than the circle, results are checked with Distance.
+func Around(p Point, radius float64) []Box {
+	dLat := radius / EarthRadius * 180 / math.Pi
+
+	minLat, maxLat := p.Lat-dLat, p.Lat+dLat
+	if minLat <= -90 || maxLat >= 90 {
+		// Every longitude is close near a pole
+		return []Box{{math.Max(minLat, -90), math.Min(maxLat, 90), -180, 180}}
+	}
+
+	// The longitude degrees shrink the farther the box reaches from the
+	// equator
+	ratio := math.Sin(radius/EarthRadius) / math.Cos(radians(p.Lat))
+	if radius/EarthRadius >= math.Pi/2 || ratio >= 1 {
+		return []Box{{minLat, maxLat, -180, 180}}
+	}
+	dLon := math.Asin(ratio) * 180 / math.Pi
+
+	minLon, maxLon := p.Lon-dLon, p.Lon+dLon
+	switch {
+	case minLon < -180:
+		return []Box{{minLat, maxLat, minLon + 360, 180}, {minLat, maxLat, -180, maxLon}}
+	case maxLon > 180:
+		return []Box{{minLat, maxLat, minLon, 180}, {minLat, maxLat, -180, maxLon - 360}}
+	}
+
+	return []Box{{minLat, maxLat, minLon, maxLon}}
+}
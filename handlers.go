@@ -1,17 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
-	"os"
 	"slices"
 	"strconv"
+	"time"
 
 	"server/auth"
 	"server/database"
@@ -37,6 +40,7 @@ type Error struct {
 // interface for json needed
 func sendError(w http.ResponseWriter, error Error, err error) {
 	log.Println(err)
+	w.WriteHeader(error.StatusCode)
 	if err := json.NewEncoder(w).Encode(error); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		//http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -44,13 +48,25 @@ func sendError(w http.ResponseWriter, error Error, err error) {
 	}
 }
 
+// readBlob loads a whole stored file into memory.
+func (app *app) readBlob(key string) ([]byte, error) {
+	blob, err := app.Store.Get(app.Ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	return io.ReadAll(blob)
+}
+
 func (app *app) register(w http.ResponseWriter, r *http.Request) {
 	prepareResponse(w)
 
 	input := struct {
-		Login    string `json:"login"`
-		Password string `json:"password"`
-		Email    string `json:"email"`
+		Login      string `json:"login"`
+		Password   string `json:"password"`
+		Email      string `json:"email"`
+		InviteCode string `json:"invite_code"`
 	}{}
 
 	err := json.NewDecoder(r.Body).Decode(&input)
@@ -59,19 +75,93 @@ func (app *app) register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(input.Password), 12)
+	switch app.Registration {
+	case registrationClosed:
+		sendError(w, Error{403, "Registration is disabled", "Forbidden"}, nil)
+		return
+	case registrationVerify:
+		if input.Email == "" {
+			sendError(w, Error{400, "Missing email", "Bad Request"}, nil)
+			return
+		}
+	case registrationInvite:
+		if input.InviteCode == "" {
+			sendError(w, Error{403, "Registration requires an invite code", "Forbidden"}, nil)
+			return
+		}
+	}
+
+	hashedPassword, err := auth.HashPassword(input.Password)
 	if err != nil {
+		if errors.Is(err, auth.ErrWeakPassword) {
+			sendError(w, Error{400, fmt.Sprintf("Password must have at least %d characters", auth.MinPasswordLength), "Bad Request"}, err)
+			return
+		}
 		sendError(w, Error{500, "Could not generate hash from password", "Internal Server Error"}, err)
 		return
 	}
 
-	input.Login, err = usr.AddUser(app.Query, input.Login, string(hashedPassword), input.Email)
+	// The invite is only used up if the account is created
+	tx, err := app.DB.Begin()
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+	defer tx.Rollback()
+
+	query := app.Query.WithTx(tx)
+
+	if app.Registration == registrationInvite {
+		invite, err := query.UseInvite(app.Ctx, auth.HashMailToken(input.InviteCode))
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+
+		if err != nil || invite.ExpiresAt.Valid && invite.ExpiresAt.Time.Before(time.Now()) {
+			sendError(w, Error{403, "Invalid invite code", "Forbidden"}, err)
+			return
+		}
+
+		log.Printf("Add user: -- Using invite %d", invite.ID)
+	}
+
+	login, id, err := usr.AddUser(query, input.Login, hashedPassword, input.Email, app.Registration != registrationVerify)
 	if err != nil {
 		sendError(w, Error{500, "Could not add user", "Internal Server Error"}, err)
 		return
 	}
 
-	log.Printf("Add user: -- Login: %s - Password: %s", input.Login, input.Password)
+	var token string
+	if app.Registration == registrationVerify {
+		var hash string
+		token, hash, err = auth.NewMailToken()
+		if err != nil {
+			sendError(w, Error{500, "Could not generate a new token", "Internal Server Error"}, err)
+			return
+		}
+
+		err = query.CreateEmailVerification(app.Ctx, database.CreateEmailVerificationParams{
+			UserID:    id,
+			TokenHash: hash,
+			ExpiresAt: time.Now().Add(auth.VerifyTokenTTL).UTC(),
+		})
+		if err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if token != "" {
+		go app.sendVerificationMail(login, input.Email, token)
+	}
+
+	log.Printf("Add user: -- Login: %s", login)
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -81,7 +171,6 @@ func (app *app) updateUser(w http.ResponseWriter, r *http.Request) {
 	input := struct {
 		Email   string `json:"email"`
 		Profile string `json:"profile"`
-		Token   string `json:"token"`
 	}{}
 
 	err := json.NewDecoder(r.Body).Decode(&input)
@@ -90,11 +179,7 @@ func (app *app) updateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := auth.ValidateSession(app.CACHE, input.Token)
-	if err != nil {
-		sendError(w, Error{401, "Incorrect Token", "Unauthorized"}, err)
-		return
-	}
+	id := r.Context().Value("id").(int64)
 
 	userParams := database.UpdateUserParams{
 		ID:      int64(id),
@@ -104,7 +189,7 @@ func (app *app) updateUser(w http.ResponseWriter, r *http.Request) {
 
 	output, err := app.Query.UpdateUser(app.Ctx, userParams)
 	if err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 		return
 	}
 
@@ -119,15 +204,9 @@ func (app *app) login(w http.ResponseWriter, r *http.Request) {
 	prepareResponse(w)
 
 	input := struct {
-		Login    string `json:"login"`
-		Password string `json:"password"`
-	}{}
-
-	output := struct {
-		Token   string `json:"token"`
-		Profile string `json:"profile"`
-		Email   string `json:"email"`
-		IsAdmin int    `json:"is_admin"`
+		Login     string `json:"login"`
+		Password  string `json:"password"`
+		SetCookie bool   `json:"set_cookie"`
 	}{}
 
 	err := json.NewDecoder(r.Body).Decode(&input)
@@ -136,44 +215,161 @@ func (app *app) login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user, err := app.Query.GetUserByLogin(app.Ctx, input.Login)
+	ip := clientIP(r)
+
+	lockedUntil, err := auth.LoginLockedUntil(app.CACHE, input.Login, ip)
 	if err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 		return
 	}
 
-	if err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
-		sendError(w, Error{401, "Wrong password or login", "Unauthorized"}, err)
+	if !lockedUntil.IsZero() {
+		rejectLogin(w, lockedUntil, nil)
 		return
-	} else {
-		output.Token, err = auth.CreateSession(app.CACHE, user.ID)
-		if err != nil {
-			sendError(w, Error{500, "Could not generate a new token", "Internal Server Error"}, err)
-			return
-		}
+	}
 
-		profile, err := app.Query.GetProfile(app.Ctx, user.ID)
-		if err != nil {
-			sendError(w, Error{400, "Database", "Internal Server Error"}, err)
-			return
-		}
+	user, err := app.Query.GetUserByLogin(app.Ctx, input.Login)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	// Unknown logins are compared too, so they take as long as wrong passwords
+	hash := user.Password
+	if errors.Is(err, sql.ErrNoRows) {
+		hash = unknownUserHash
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(input.Password)); err != nil || user.ID == 0 {
+		app.loginFailed(w, input.Login, ip, err)
+		return
+	}
 
-		email, err := app.Query.GetEmail(app.Ctx, user.ID)
+	if user.Disabled == 1 {
+		sendError(w, Error{403, "Account is disabled", "Forbidden"}, nil)
+		return
+	}
+
+	// Accounts waiting for email verification
+	if user.Active == 0 {
+		sendError(w, Error{403, "Account is not active", "Forbidden"}, nil)
+		return
+	}
+
+	enabled, err := app.hasTwoFactor(user.ID)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if enabled {
+		challenge, err := auth.CreateChallenge(app.CACHE, user.ID)
 		if err != nil {
-			sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+			sendError(w, Error{500, "Could not generate a new token", "Internal Server Error"}, err)
 			return
 		}
 
-		log.Printf("Login -- Login: %s - Token: %s", input.Login, output.Token)
+		log.Printf("Login -- Login: %s - Waiting for second factor", input.Login)
 
-		output.IsAdmin = int(user.IsAdmin)
-		output.Profile = profile.String
-		output.Email = email.String
+		output := struct {
+			TwoFactorRequired bool   `json:"two_factor_required"`
+			Challenge         string `json:"challenge"`
+		}{
+			TwoFactorRequired: true,
+			Challenge:         challenge,
+		}
 
 		if err := json.NewEncoder(w).Encode(output); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
 		}
+		return
+	}
+
+	app.startSession(w, r, database.GetUserRow(user), input.SetCookie)
+}
+
+// unknownUserHash is a hash of a random password with the cost used by
+// register, compared against when the login does not exist.
+const unknownUserHash = "$2a$12$OSplPeLp4RStINJdNZW.W.PWGI49SriwyhJRJsLk7HiXhob34.A5O"
+
+// rejectLogin answers every failed login the same way, whether the login
+// does not exist, the password is wrong or the account is locked.
+func rejectLogin(w http.ResponseWriter, lockedUntil time.Time, err error) {
+	if !lockedUntil.IsZero() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(time.Until(lockedUntil).Seconds())+1))
+	}
+
+	sendError(w, Error{401, "Wrong password or login", "Unauthorized"}, err)
+}
+
+// loginFailed counts the failure towards the lockout of the login and the
+// address before rejecting the login.
+func (app *app) loginFailed(w http.ResponseWriter, login, ip string, err error) {
+	if err := auth.LoginFailed(app.CACHE, login, ip); err != nil {
+		log.Println(err)
+	}
+
+	lockedUntil, lockErr := auth.LoginLockedUntil(app.CACHE, login, ip)
+	if lockErr != nil {
+		log.Println(lockErr)
+	}
+
+	rejectLogin(w, lockedUntil, err)
+}
+
+// startSession logs the user in once every factor has been checked.
+func (app *app) startSession(w http.ResponseWriter, r *http.Request, user database.GetUserRow, setCookie bool) {
+	output := struct {
+		Token   string `json:"token"`
+		Profile string `json:"profile"`
+		Email   string `json:"email"`
+		IsAdmin int    `json:"is_admin"`
+	}{}
+
+	if err := auth.LoginSucceeded(app.CACHE, user.Login); err != nil {
+		log.Println(err)
+	}
+
+	token, err := auth.CreateSession(app.CACHE, user.ID, r.UserAgent(), clientIP(r))
+	if err != nil {
+		sendError(w, Error{500, "Could not generate a new token", "Internal Server Error"}, err)
+		return
+	}
+	output.Token = token
+
+	profile, err := app.Query.GetProfile(app.Ctx, user.ID)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	email, err := app.Query.GetEmail(app.Ctx, user.ID)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	log.Printf("Login -- Login: %s - Token: %s", user.Login, output.Token)
+
+	if setCookie {
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookie,
+			Value:    output.Token,
+			Path:     "/",
+			MaxAge:   int(auth.MaxAge.Seconds()),
+			HttpOnly: true,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+
+	output.IsAdmin = int(user.IsAdmin)
+	output.Profile = profile.String
+	output.Email = email.String
+
+	if err := json.NewEncoder(w).Encode(output); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 }
 
@@ -183,14 +379,26 @@ func (app *app) logout(w http.ResponseWriter, r *http.Request) {
 		Token string `json:"token"`
 	}{}
 
-	err := json.NewDecoder(r.Body).Decode(&input)
-	if err != nil {
-		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
-		return
+	input.Token = tokenFromRequest(r)
+	if input.Token == "" {
+		err := json.NewDecoder(r.Body).Decode(&input)
+		if err != nil {
+			sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+			return
+		}
 	}
 
 	database.DeleteToken(app.CACHE, input.Token)
 
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
 	log.Printf("Logout: -- Removed: %s (maybe valid)", input.Token)
 
 	w.WriteHeader(http.StatusOK)
@@ -216,18 +424,17 @@ func (app *app) uploadFile(w http.ResponseWriter, r *http.Request) {
 
 	id := r.Context().Value("id").(int64)
 
-	user, err := app.Query.GetUser(app.Ctx, id)
-	if err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
-		return
-	}
-
 	for _, file := range input.Files {
 		file.Metadata.OwnerID = id
 
+		if !validCoordinates(file.Metadata.Coordinates) {
+			sendError(w, Error{400, "Invalid coordinates", "Bad Request"}, nil)
+			return
+		}
+
 		data, err := base64.StdEncoding.DecodeString(file.File)
 		if err != nil {
-			sendError(w, Error{400, "Decoding", "Internal Server Error"}, err)
+			sendError(w, Error{400, "Decoding", "Bad Request"}, err)
 			return
 		}
 
@@ -236,40 +443,19 @@ func (app *app) uploadFile(w http.ResponseWriter, r *http.Request) {
 
 		file.Metadata.Checksum = checksum
 
-		id, err := app.Query.AddFile(app.Ctx, file.Metadata)
-		if err != nil {
-			sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+		id, err := app.addFile(file.Metadata, bytes.NewReader(data), int64(len(data)))
+		if sendQuotaError(w, err) {
 			return
 		}
-
-		fileIdStr := strconv.FormatInt(id, 16)
-
-		f, err := os.OpenFile("../storage/users/"+user.Login+"/"+fileIdStr, os.O_WRONLY|os.O_CREATE, 0666)
 		if err != nil {
-			sendError(w, Error{400, "Could not acquire file path", "Internal Server Error"}, err)
+			sendError(w, Error{500, "Could not store file", "Internal Server Error"}, err)
 			return
 		}
 
-		f.Write(data)
-
-		for _, tag := range file.Tags {
-			tagDB, err := app.Query.GetTagByName(app.Ctx, tag)
-			if err != nil && err != sql.ErrNoRows {
-				sendError(w, Error{400, "Database", "Internal Server Error"}, err)
-				return
-			}
-
-			if sql.ErrNoRows == err {
-				tagDB.ID, err = app.Query.AddTag(app.Ctx, tag)
-			}
-
-			err = app.Query.TagsConnect(app.Ctx, database.TagsConnectParams{FileID: id, TagID: tagDB.ID})
-			if err != nil && err != sql.ErrNoRows {
-				sendError(w, Error{400, "Database", "Internal Server Error"}, err)
-				return
-			}
+		if err := app.tagFile(file.Metadata.OwnerID, id, file.Tags); err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
 		}
-
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -288,13 +474,13 @@ func (app *app) deleteFile(w http.ResponseWriter, r *http.Request) {
 	id := r.Context().Value("id").(int64)
 	user, err := app.Query.GetUser(app.Ctx, id)
 	if err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 		return
 	}
 
 	file, err := app.Query.GetFile(app.Ctx, input.FileId)
 	if err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 		return
 	}
 
@@ -303,16 +489,13 @@ func (app *app) deleteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := os.Remove("../storage/users/" + user.Login + "/" + strconv.FormatInt(file.ID, 16)); err != nil {
-		if errors.Is(err, os.ErrNotExist) {
-			log.Printf("File %s does not exist, but database entry will be removed anyway", file.FileName)
-		}
-		sendError(w, Error{400, "Could not remove file from storage", "Internal Server Error"}, err)
+	if err := app.Query.DeleteFile(app.Ctx, input.FileId); err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 		return
 	}
 
-	if err := app.Query.DeleteFile(app.Ctx, input.FileId); err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+	if err := app.releaseBlob(file.Checksum); err != nil {
+		sendError(w, Error{500, "Could not remove file from storage", "Internal Server Error"}, err)
 		return
 	}
 
@@ -320,47 +503,160 @@ func (app *app) deleteFile(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func (app *app) getFileList(w http.ResponseWriter, r *http.Request) {
+// File listings are returned in pages of fileListLimit, clients may ask for
+// up to maxFileListLimit.
+const (
+	fileListLimit    = 100
+	maxFileListLimit = 1000
+)
+
+// fileCursor marks where the next page of a file listing starts. It is
+// handed to clients as opaque base64.
+type fileCursor struct {
+	Sort string `json:"sort"`
+	Desc bool   `json:"desc"`
+	Key  string `json:"key"`
+	ID   int64  `json:"id"`
+}
+
+func (c fileCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeFileCursor(s string) (fileCursor, error) {
+	var c fileCursor
 
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, err
+	}
+
+	err = json.Unmarshal(data, &c)
+	return c, err
+}
+
+// getFileList lists the files of the user with their tags and camera
+// metadata, a page at a time.
+// Query parameters:
+//
+//	sort        created_at (default), name or size
+//	order       asc or desc, created_at defaults to desc, the others to asc
+//	from, to    when the files were added, see timeParam
+//	tags        a tag query like for searchTags, with descendants
+//	album       only files in the album with this id
+//	limit       files per page
+//	cursor      next_cursor of the previous page
+//
+// next_cursor is left out on the last page.
+func (app *app) getFileList(w http.ResponseWriter, r *http.Request) {
 	id := r.Context().Value("id").(int64)
-	type File struct {
-		File database.GetFilesRow `json:"file"`
-		Tags []string             `json:"tags"`
+
+	params := database.ListFilesParams{OwnerID: id, Sort: r.URL.Query().Get("sort")}
+	if params.Sort == "" {
+		params.Sort = "created_at"
 	}
 
-	output := struct {
-		File []File `json:"file"`
-	}{}
+	switch r.URL.Query().Get("order") {
+	case "":
+		params.Desc = params.Sort == "created_at"
+	case "asc":
+	case "desc":
+		params.Desc = true
+	default:
+		sendError(w, Error{400, "Invalid order, use asc or desc", "Bad Request"}, nil)
+		return
+	}
 
-	user, err := app.Query.GetUser(app.Ctx, id)
-	if err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+	var ok bool
+	if params.From, ok = timeParam(w, r, "from", false); !ok {
+		return
+	}
+	if params.To, ok = timeParam(w, r, "to", true); !ok {
+		return
+	}
+	if params.AlbumID, ok = intParam(w, r, "album", 0); !ok {
 		return
 	}
+	if params.Limit, ok = intParam(w, r, "limit", fileListLimit); !ok {
+		return
+	}
+	params.Limit = min(max(params.Limit, 1), maxFileListLimit)
 
-	files, err := app.Query.GetFiles(app.Ctx, user.ID)
-	if err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+	descendants, ok := boolParam(w, r, "descendants")
+	if !ok {
 		return
 	}
 
-	for _, file := range files {
-		tags, err := app.Query.GetTagsByFile(app.Ctx, file.ID)
-		if err != nil {
-			sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+	if params.AlbumID != 0 {
+		album, err := app.Query.GetAlbum(app.Ctx, params.AlbumID)
+		if err != nil || album.OwnerID != id {
+			sendError(w, Error{404, "Album not found", "Not Found"}, err)
 			return
 		}
+	}
 
-		var tagNames []string
-		for _, tagID := range tags {
-			tagName, err := app.Query.GetTagById(app.Ctx, tagID)
-			if err != nil {
-				sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+	if tags := r.URL.Query().Get("tags"); tags != "" {
+		var err error
+		params.Cond, params.Args, err = app.tagCondition(id, tags, descendants)
+		if err != nil {
+			if sendTagQueryError(w, err) {
 				return
 			}
-			tagNames = append(tagNames, tagName.Name)
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+	}
+
+	if value := r.URL.Query().Get("cursor"); value != "" {
+		cursor, err := decodeFileCursor(value)
+		if err != nil {
+			sendError(w, Error{400, "Invalid cursor", "Bad Request"}, err)
+			return
+		}
+
+		if cursor.Sort != params.Sort || cursor.Desc != params.Desc {
+			sendError(w, Error{400, "Cursor is for another sort order", "Bad Request"}, nil)
+			return
 		}
-		output.File = append(output.File, File{File: file, Tags: tagNames})
+
+		params.AfterKey, params.AfterID = cursor.Key, cursor.ID
+	}
+
+	// One more than asked for tells whether there is a next page
+	params.Limit++
+
+	files, err := app.Query.ListFiles(app.Ctx, params)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidSort) {
+			sendError(w, Error{400, "Invalid sort, use created_at, name or size", "Bad Request"}, err)
+			return
+		}
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	type File struct {
+		File     database.File          `json:"file"`
+		Tags     []string               `json:"tags"`
+		Metadata *database.Filemetadata `json:"metadata"`
+	}
+
+	output := struct {
+		File       []File `json:"file"`
+		NextCursor string `json:"next_cursor,omitempty"`
+	}{
+		File: []File{},
+	}
+
+	if int64(len(files)) == params.Limit {
+		files = files[:len(files)-1]
+		last := files[len(files)-1]
+		output.NextCursor = fileCursor{Sort: params.Sort, Desc: params.Desc, Key: last.SortKey, ID: last.ID}.encode()
+	}
+
+	for _, file := range files {
+		output.File = append(output.File, File{File: file.File, Tags: file.Tags, Metadata: file.Metadata})
 	}
 
 	if err = json.NewEncoder(w).Encode(&output); err != nil {
@@ -379,7 +675,6 @@ type File struct {
 func (app *app) fileDownload(w http.ResponseWriter, r *http.Request) {
 
 	input := struct {
-		Token   string  `json:"token"`
 		FileIds []int64 `json:"file_ids"`
 	}{}
 
@@ -392,29 +687,25 @@ func (app *app) fileDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := auth.ValidateSession(app.CACHE, input.Token)
-	if err != nil {
-		sendError(w, Error{401, "Incorrect Token", "Unauthorized"}, err)
-		return
-	}
+	id := r.Context().Value("id").(int64)
 
 	user, err := app.Query.GetUser(app.Ctx, int64(id))
 	if err != nil {
-		sendError(w, Error{400, "Database, Get User", "Internal Server Error"}, err)
+		sendError(w, Error{500, "Database, Get User", "Internal Server Error"}, err)
 		return
 	}
 
 	files, err := app.Query.GetFiles(app.Ctx, user.ID)
 	if err != nil {
-		sendError(w, Error{400, "Database, Get Files", "Internal Server Error"}, err)
+		sendError(w, Error{500, "Database, Get Files", "Internal Server Error"}, err)
 		return
 	}
 
 	for i := range files {
 		if slices.Contains(input.FileIds, files[i].ID) {
-			file, err := os.ReadFile("../storage/users/" + user.Login + "/" + strconv.FormatInt(files[i].ID, 16))
+			file, err := app.readBlob(blobKey(files[i].Checksum))
 			if err != nil {
-				sendError(w, Error{400, "Error opening file:" + output.Files[i].FileName, "Internal Server Error"}, err)
+				sendError(w, Error{500, "Error opening file:" + files[i].FileName, "Internal Server Error"}, err)
 				return
 			}
 
@@ -424,7 +715,7 @@ func (app *app) fileDownload(w http.ResponseWriter, r *http.Request) {
 			checksum := hex.EncodeToString(hash[:])
 
 			if checksum != files[i].Checksum {
-				sendError(w, Error{400, "Checksum mismatch for file: " + output.Files[i].FileName, "Internal Server Error"}, nil)
+				sendError(w, Error{500, "Checksum mismatch for file: " + output.Files[i].FileName, "Internal Server Error"}, nil)
 				return
 			}
 
@@ -439,10 +730,12 @@ func (app *app) fileDownload(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// getTags lists the tags of the user and the shared tags, which have an
+// owner_id of 0.
 func (app *app) getTags(w http.ResponseWriter, r *http.Request) {
-	output, err := app.Query.GetTags(app.Ctx)
+	output, err := app.Query.GetTags(app.Ctx, r.Context().Value("id").(int64))
 	if err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 		return
 	}
 
@@ -467,19 +760,23 @@ func (app *app) addAlbum(w http.ResponseWriter, r *http.Request) {
 	if input.AlbumTitle.CoverID.Valid {
 		covetFile, err := app.Query.GetFile(app.Ctx, input.AlbumTitle.CoverID.Int64)
 		if err != nil {
-			sendError(w, Error{400, "Cover file not found", "Internal Server Error"}, err)
+			if errors.Is(err, sql.ErrNoRows) {
+				sendError(w, Error{404, "Cover file not found", "Not Found"}, err)
+				return
+			}
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 			return
 		}
 
 		if covetFile.OwnerID != input.AlbumTitle.OwnerID {
-			sendError(w, Error{403, "Cover file does not belong to the user", "Bad Request"}, nil)
+			sendError(w, Error{403, "Cover file does not belong to the user", "Forbidden"}, nil)
 			return
 		}
 
 	}
 
 	if err := app.Query.AddAlbum(app.Ctx, input.AlbumTitle); err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 		return
 	}
 
@@ -488,22 +785,24 @@ func (app *app) addAlbum(w http.ResponseWriter, r *http.Request) {
 
 func (app *app) getAlbums(w http.ResponseWriter, r *http.Request) {
 
+	// Covers link to their thumbnails rather than carrying the images
+	type Cover struct {
+		Id         int64             `json:"id"`
+		FileName   string            `json:"file_name"`
+		Checksum   string            `json:"checksum"`
+		Thumbnails map[string]string `json:"thumbnails"`
+	}
+
 	output := struct {
 		Albums []database.Album `json:"albums"`
-		Covers []File           `json:"album_cover"`
+		Covers []Cover          `json:"album_cover"`
 	}{}
 
 	id := r.Context().Value("id").(int64)
 
-	user, err := app.Query.GetUser(app.Ctx, int64(id))
-	if err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
-		return
-	}
-
 	albums, err := app.Query.GetAlbums(app.Ctx, id)
 	if err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 		return
 	}
 
@@ -515,32 +814,16 @@ func (app *app) getAlbums(w http.ResponseWriter, r *http.Request) {
 			cover, err := app.Query.GetFile(app.Ctx, album.CoverID.Int64)
 			if err != nil {
 				if err == sql.ErrNoRows {
-					output.Covers = append(output.Covers, File{})
+					output.Covers = append(output.Covers, Cover{})
 					continue
 				}
-				sendError(w, Error{400, "Database", "Internal Server Error"}, err)
-				return
-			}
-
-			coverFile, err := os.ReadFile("../storage/users/" + user.Login + "/" + strconv.FormatInt(cover.ID, 16))
-			if err != nil {
-				sendError(w, Error{400, "Error opening file:" + cover.FileName, "Internal Server Error"}, err)
+				sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 				return
 			}
 
-			data := base64.StdEncoding.EncodeToString(coverFile)
-
-			hash := sha256.Sum256(coverFile)
-			checksum := hex.EncodeToString(hash[:])
-
-			if checksum != cover.Checksum {
-				sendError(w, Error{400, "Checksum mismatch for file: " + cover.FileName, "Internal Server Error"}, nil)
-				return
-			}
-
-			output.Covers = append(output.Covers, File{Id: cover.ID, FileName: cover.FileName, File: data, Checksum: cover.Checksum})
+			output.Covers = append(output.Covers, Cover{Id: cover.ID, FileName: cover.FileName, Checksum: cover.Checksum, Thumbnails: thumbURLs(cover.ID)})
 		} else {
-			output.Covers = append(output.Covers, File{})
+			output.Covers = append(output.Covers, Cover{})
 		}
 
 	}
@@ -562,7 +845,7 @@ func (app *app) addFileToAlbum(w http.ResponseWriter, r *http.Request) {
 	id := r.Context().Value("id").(int64)
 	file, err := app.Query.GetFile(app.Ctx, input.FileID)
 	if err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 		return
 	}
 
@@ -573,7 +856,7 @@ func (app *app) addFileToAlbum(w http.ResponseWriter, r *http.Request) {
 
 	album, err := app.Query.GetAlbum(app.Ctx, input.AlbumID)
 	if err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 		return
 	}
 
@@ -583,17 +866,21 @@ func (app *app) addFileToAlbum(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := app.Query.AddToAlbum(app.Ctx, input); err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
+// addFileToAlbumByTag adds the files with any of the tags, and the files
+// matching the tag query in query, to the album.
 func (app *app) addFileToAlbumByTag(w http.ResponseWriter, r *http.Request) {
 	input := struct {
-		AlbumID int64    `json:"album_id"`
-		Tags    []string `json:"tags"`
+		AlbumID     int64    `json:"album_id"`
+		Tags        []string `json:"tags"`
+		Query       string   `json:"query"`
+		Descendants bool     `json:"descendants"`
 	}{}
 
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
@@ -605,7 +892,7 @@ func (app *app) addFileToAlbumByTag(w http.ResponseWriter, r *http.Request) {
 
 	album, err := app.Query.GetAlbum(app.Ctx, input.AlbumID)
 	if err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 		return
 	}
 
@@ -614,36 +901,52 @@ func (app *app) addFileToAlbumByTag(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var files []database.File
+
 	for _, tagName := range input.Tags {
 
-		tag, err := app.Query.GetTagByName(app.Ctx, tagName)
+		tag, err := app.resolveTagPath(app.Query, id, tagName, false)
 		if err != nil {
-			sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+			if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errEmptyTagPath) {
+				sendError(w, Error{404, "Tag not found: " + tagName, "Not Found"}, err)
+				return
+			}
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 			return
 		}
 
-		files, err := app.Query.GetFilesByTag(app.Ctx, database.GetFilesByTagParams{TagID: tag.ID, OwnerID: id})
+		tagged, err := app.filesByTag(id, tag.ID, input.Descendants)
 		if err != nil {
-			sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 			return
 		}
 
-		for _, file := range files {
+		files = append(files, tagged...)
+	}
 
-			if file.ID.Valid {
+	if input.Query != "" {
+		matched, err := app.filesByTagQuery(id, input.Query, input.Descendants)
+		if err != nil {
+			if sendTagQueryError(w, err) {
+				return
+			}
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
 
-				toadd := database.AddToAlbumParams{
-					FileID:  file.ID.Int64,
-					AlbumID: input.AlbumID,
-				}
+		files = append(files, matched...)
+	}
 
-				if err := app.Query.AddToAlbum(app.Ctx, toadd); err != nil {
-					sendError(w, Error{400, "Database", "Internal Server Error"}, err)
-					return
-				}
+	for _, file := range files {
 
-			}
+		toadd := database.AddToAlbumParams{
+			FileID:  file.ID,
+			AlbumID: input.AlbumID,
+		}
 
+		if err := app.Query.AddToAlbum(app.Ctx, toadd); err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
 		}
 
 	}
@@ -656,14 +959,34 @@ func (app *app) getFileFromAlbum(w http.ResponseWriter, r *http.Request) {
 		AlbumID int64 `json:"album_id"`
 	}{}
 
-	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+	// GET /album/{id}/files names the album in the path
+	if r.Method == http.MethodGet {
+		albumID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil {
+			sendError(w, Error{400, "Invalid album id", "Bad Request"}, err)
+			return
+		}
+		input.AlbumID = albumID
+	} else if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
 		return
 	}
 
+	// Albums of other users are not revealed to exist
+	album, err := app.Query.GetAlbum(app.Ctx, input.AlbumID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if err != nil || album.OwnerID != r.Context().Value("id").(int64) {
+		sendError(w, Error{404, "Album not found", "Not Found"}, err)
+		return
+	}
+
 	output, err := app.Query.GetFileFromAlbum(app.Ctx, input.AlbumID)
 	if err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 		return
 	}
 
@@ -684,14 +1007,14 @@ func (app *app) shareFile(w http.ResponseWriter, r *http.Request) {
 
 	url, err := auth.GenerateSecureToken(32)
 	if err != nil {
-		sendError(w, Error{400, "Could not generate URL", "Internal Server Error"}, err)
+		sendError(w, Error{500, "Could not generate URL", "Internal Server Error"}, err)
 		return
 	}
 	input.Url = url
 
 	share, err := app.Query.AddGuestFile(app.Ctx, input)
 	if err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 		return
 	}
 
@@ -713,13 +1036,13 @@ func (app *app) getShareFile(w http.ResponseWriter, r *http.Request) {
 
 	user, err := app.Query.GetUser(app.Ctx, id)
 	if err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 		return
 	}
 
 	output, err := app.Query.GetSharedFiles(app.Ctx, database.GetSharedFilesParams{OwnerID: id, IsAdmin: user.IsAdmin})
 	if err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 		return
 	}
 
@@ -740,13 +1063,23 @@ func (app *app) downloadSharedFile(w http.ResponseWriter, r *http.Request) {
 
 	output, err := app.Query.GetShareDownload(app.Ctx, input)
 	if err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+		if errors.Is(err, sql.ErrNoRows) {
+			sendError(w, Error{404, "Share not found", "Not Found"}, err)
+			return
+		}
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	// Shares of files deleted before files_links_delete existed point nowhere
+	if !output.ID.Valid {
+		sendError(w, Error{404, "File not found", "Not Found"}, nil)
 		return
 	}
 
 	uses, err := app.Query.GetShareUseCount(app.Ctx, input.ID)
 	if err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 		return
 	}
 
@@ -756,20 +1089,14 @@ func (app *app) downloadSharedFile(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if err := app.Query.DecrementShareUses(app.Ctx, input.ID); err != nil {
-			sendError(w, Error{400, "Database", "Internal Server Error"}, err)
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
 			return
 		}
 	}
 
-	login, err := app.Query.GetLogin(app.Ctx, output.OwnerID.Int64)
-	if err != nil {
-		sendError(w, Error{400, "Database", "Internal Server Error"}, err)
-		return
-	}
-
-	file, err := os.ReadFile("../storage/users/" + login + "/" + strconv.FormatInt(output.ID.Int64, 16))
+	file, err := app.readBlob(blobKey(output.Checksum.String))
 	if err != nil {
-		sendError(w, Error{400, "Error opening file:" + output.FileName.String, "Internal Server Error"}, err)
+		sendError(w, Error{500, "Error opening file:" + output.FileName.String, "Internal Server Error"}, err)
 		return
 	}
 
@@ -777,7 +1104,7 @@ func (app *app) downloadSharedFile(w http.ResponseWriter, r *http.Request) {
 	checksum := hex.EncodeToString(hash[:])
 
 	if checksum != output.Checksum.String {
-		sendError(w, Error{400, "Checksum mismatch for file: " + output.FileName.String, "Internal Server Error"}, nil)
+		sendError(w, Error{500, "Checksum mismatch for file: " + output.FileName.String, "Internal Server Error"}, nil)
 		return
 	}
 
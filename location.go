@@ -0,0 +1,312 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"server/database"
+	"server/geo"
+	"server/types"
+)
+
+// maxSearchRadius is the largest radius in meters searchLocation accepts,
+// half the circumference of the earth.
+const maxSearchRadius = 20_000_000
+
+// validCoordinates reports whether the coordinates given with an upload are
+// empty or can be parsed by geo.Parse.
+func validCoordinates(coordinates types.JSONNullString) bool {
+	if !coordinates.Valid || strings.TrimSpace(coordinates.String) == "" {
+		return true
+	}
+
+	_, err := geo.Parse(coordinates.String)
+	return err == nil
+}
+
+// locate sets the latitude and longitude of a new file from its coordinates.
+func locate(metadata *database.AddFileParams) {
+	metadata.Latitude, metadata.Longitude = types.JSONNullFloat64{}, types.JSONNullFloat64{}
+
+	if !metadata.Coordinates.Valid {
+		return
+	}
+
+	p, err := geo.Parse(metadata.Coordinates.String)
+	if err != nil {
+		return
+	}
+
+	metadata.Latitude = types.JSONNullFloat64{NullFloat64: sql.NullFloat64{Float64: p.Lat, Valid: true}}
+	metadata.Longitude = types.JSONNullFloat64{NullFloat64: sql.NullFloat64{Float64: p.Lon, Valid: true}}
+}
+
+// locateFiles parses the coordinates of files stored before they were
+// parsed on upload. Coordinates that cannot be parsed are left as they are.
+func (app *app) locateFiles() error {
+	files, err := app.Query.GetUnlocatedFiles(app.Ctx)
+	if err != nil {
+		return err
+	}
+
+	located := 0
+	for _, file := range files {
+		metadata := database.AddFileParams{Coordinates: file.Coordinates}
+		locate(&metadata)
+		if !metadata.Latitude.Valid {
+			continue
+		}
+
+		err := app.Query.SetFileLocation(app.Ctx, database.SetFileLocationParams{
+			Latitude:  metadata.Latitude,
+			Longitude: metadata.Longitude,
+			ID:        file.ID,
+		})
+		if err != nil {
+			return err
+		}
+		located++
+	}
+
+	if located > 0 {
+		log.Printf("Located %d files from their coordinates", located)
+	}
+	if len(files) > located {
+		log.Printf("Could not parse the coordinates of %d files", len(files)-located)
+	}
+
+	return nil
+}
+
+// floatParams reads a comma separated list of count numbers.
+func floatParams(value string, count int) ([]float64, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != count {
+		return nil, geo.ErrInvalid
+	}
+
+	numbers := make([]float64, count)
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, err
+		}
+		numbers[i] = f
+	}
+
+	return numbers, nil
+}
+
+// bboxParam reads the bbox query parameter, west,south,east,north in
+// degrees like a GeoJSON bbox.
+func bboxParam(w http.ResponseWriter, r *http.Request) ([]geo.Box, bool) {
+	bbox, err := floatParams(r.URL.Query().Get("bbox"), 4)
+	if err == nil {
+		var boxes []geo.Box
+		if boxes, err = geo.BoundingBox(bbox[0], bbox[1], bbox[2], bbox[3]); err == nil {
+			return boxes, true
+		}
+	}
+
+	sendError(w, Error{400, "Invalid bbox, use west,south,east,north", "Bad Request"}, err)
+	return nil, false
+}
+
+// filesInBoxes returns the located files of the user inside any of the boxes.
+func (app *app) filesInBoxes(id int64, boxes []geo.Box) ([]database.File, error) {
+	var files []database.File
+	for _, box := range boxes {
+		found, err := app.Query.GetFilesInBox(app.Ctx, database.GetFilesInBoxParams{
+			OwnerID: id,
+			MinLat:  box.MinLat,
+			MaxLat:  box.MaxLat,
+			MinLon:  box.MinLon,
+			MaxLon:  box.MaxLon,
+		})
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, found...)
+	}
+
+	return files, nil
+}
+
+// filesInBoxesWithMetadata is filesInBoxes with the metadata of each file.
+func (app *app) filesInBoxesWithMetadata(id int64, boxes []geo.Box) ([]fileWithMetadata, error) {
+	files, err := app.filesInBoxes(id, boxes)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.withMetadata(files)
+}
+
+// searchLocation finds the located files of the user in an area, either
+//
+//	GET /file/geo/search?bbox=west,south,east,north
+//	GET /file/geo/search?near=lat,lon&radius=meters
+//
+// Files found by radius come nearest first and carry their distance in
+// meters. A bbox with west greater than east crosses the antimeridian.
+func (app *app) searchLocation(w http.ResponseWriter, r *http.Request) {
+	id := r.Context().Value("id").(int64)
+
+	type result struct {
+		fileWithMetadata
+		Distance *float64 `json:"distance,omitempty"`
+	}
+
+	output := struct {
+		Files []result `json:"files"`
+	}{
+		Files: []result{},
+	}
+
+	switch {
+	case r.URL.Query().Has("bbox"):
+		boxes, ok := bboxParam(w, r)
+		if !ok {
+			return
+		}
+
+		files, err := app.filesInBoxesWithMetadata(id, boxes)
+		if err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+
+		for _, file := range files {
+			output.Files = append(output.Files, result{fileWithMetadata: file})
+		}
+	case r.URL.Query().Has("near"):
+		near, err := floatParams(r.URL.Query().Get("near"), 2)
+		center := geo.Point{}
+		if err == nil {
+			center = geo.Point{Lat: near[0], Lon: near[1]}
+		}
+		if err != nil || !center.Valid() {
+			sendError(w, Error{400, "Invalid near, use lat,lon", "Bad Request"}, err)
+			return
+		}
+
+		radius, err := strconv.ParseFloat(r.URL.Query().Get("radius"), 64)
+		if err != nil || radius <= 0 || radius > maxSearchRadius {
+			sendError(w, Error{400, "Invalid radius, use meters up to 20000000", "Bad Request"}, err)
+			return
+		}
+
+		files, err := app.filesInBoxesWithMetadata(id, geo.Around(center, radius))
+		if err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+
+		// The boxes reach beyond the circle in their corners
+		for _, file := range files {
+			distance := geo.Distance(center, geo.Point{Lat: file.Latitude.Float64, Lon: file.Longitude.Float64})
+			if distance <= radius {
+				output.Files = append(output.Files, result{fileWithMetadata: file, Distance: &distance})
+			}
+		}
+
+		slices.SortStableFunc(output.Files, func(a, b result) int {
+			switch {
+			case *a.Distance < *b.Distance:
+				return -1
+			case *a.Distance > *b.Distance:
+				return 1
+			}
+			return 0
+		})
+	default:
+		sendError(w, Error{400, "Missing bbox or near", "Bad Request"}, nil)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// exportLocations returns the located files of the user as a GeoJSON
+// FeatureCollection of points for map views. An optional bbox limits it like
+// for searchLocation.
+func (app *app) exportLocations(w http.ResponseWriter, r *http.Request) {
+	id := r.Context().Value("id").(int64)
+
+	var files []database.File
+	var err error
+
+	if r.URL.Query().Has("bbox") {
+		boxes, ok := bboxParam(w, r)
+		if !ok {
+			return
+		}
+
+		files, err = app.filesInBoxes(id, boxes)
+	} else {
+		files, err = app.Query.GetLocatedFiles(app.Ctx, id)
+	}
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	type geometry struct {
+		Type        string     `json:"type"`
+		Coordinates [2]float64 `json:"coordinates"`
+	}
+
+	type properties struct {
+		FileName    string               `json:"file_name"`
+		Title       types.JSONNullString `json:"title"`
+		Description types.JSONNullString `json:"description"`
+		CreatedAt   types.JSONNullTime   `json:"created_at"`
+	}
+
+	type feature struct {
+		Type       string     `json:"type"`
+		ID         int64      `json:"id"`
+		Geometry   geometry   `json:"geometry"`
+		Properties properties `json:"properties"`
+	}
+
+	output := struct {
+		Type     string    `json:"type"`
+		Features []feature `json:"features"`
+	}{
+		Type:     "FeatureCollection",
+		Features: []feature{},
+	}
+
+	for _, file := range files {
+		output.Features = append(output.Features, feature{
+			Type: "Feature",
+			ID:   file.ID,
+			// GeoJSON positions are longitude first
+			Geometry: geometry{Type: "Point", Coordinates: [2]float64{file.Longitude.Float64, file.Latitude.Float64}},
+			Properties: properties{
+				FileName:    file.FileName,
+				Title:       file.Title,
+				Description: file.Description,
+				CreatedAt:   file.CreatedAt,
+			},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"server/auth"
+	"server/database"
+)
+
+// adminListLockouts shows logins and addresses with recent failed logins and
+// whether they are locked.
+func (app *app) adminListLockouts(w http.ResponseWriter, r *http.Request) {
+	lockouts, err := auth.ListLockouts(app.CACHE)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	output := struct {
+		Lockouts []database.Lockout `json:"lockouts"`
+	}{
+		Lockouts: lockouts,
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// adminUnlock clears the failed logins of a login, an address or both.
+func (app *app) adminUnlock(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		Login string `json:"login"`
+		IP    string `json:"ip"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	if input.Login == "" && input.IP == "" {
+		sendError(w, Error{400, "Missing login or ip", "Bad Request"}, nil)
+		return
+	}
+
+	unlocked := false
+	for kind, key := range map[string]string{auth.LockoutLogin: input.Login, auth.LockoutIP: input.IP} {
+		if key == "" {
+			continue
+		}
+
+		deleted, err := auth.Unlock(app.CACHE, kind, key)
+		if err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+
+		if deleted {
+			log.Printf("Admin: -- Unlocked %s %s", kind, key)
+		}
+		unlocked = unlocked || deleted
+	}
+
+	if !unlocked {
+		sendError(w, Error{404, "No failed logins recorded", "Not Found"}, nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
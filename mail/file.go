@@ -0,0 +1,47 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// File writes every message to a .eml file in a directory instead of sending
+// it, for local testing. Without a directory messages are only logged.
+type File struct {
+	dir  string
+	from string
+}
+
+func NewFile(dir, from string) (*File, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	if from == "" {
+		from = "noreply@localhost"
+	}
+
+	return &File{dir: dir, from: from}, nil
+}
+
+func (m *File) Send(ctx context.Context, msg Message) error {
+	for _, value := range []string{msg.To, msg.Subject} {
+		if err := validHeader(value); err != nil {
+			return err
+		}
+	}
+
+	if m.dir == "" {
+		log.Printf("Mail: -- To: %s - Subject: %s\n%s", msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+
+	name := fmt.Sprintf("%d.eml", time.Now().UnixNano())
+	return os.WriteFile(filepath.Join(m.dir, name), format(m.from, msg), 0600)
+}
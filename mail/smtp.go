@@ -0,0 +1,54 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/smtp"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     string // defaults to 587
+	Username string // no authentication when empty
+	Password string
+	From     string
+}
+
+// SMTP sends mail through a relay. net/smtp upgrades the connection with
+// STARTTLS when the server offers it and refuses to authenticate without.
+type SMTP struct {
+	cfg SMTPConfig
+}
+
+func NewSMTP(cfg SMTPConfig) (*SMTP, error) {
+	if cfg.Host == "" {
+		return nil, errors.New("missing SMTP host")
+	}
+
+	if cfg.From == "" {
+		return nil, errors.New("missing sender address")
+	}
+
+	if cfg.Port == "" {
+		cfg.Port = "587"
+	}
+
+	return &SMTP{cfg: cfg}, nil
+}
+
+func (m *SMTP) Send(ctx context.Context, msg Message) error {
+	for _, value := range []string{msg.To, msg.Subject} {
+		if err := validHeader(value); err != nil {
+			return err
+		}
+	}
+
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+	}
+
+	addr := net.JoinHostPort(m.cfg.Host, m.cfg.Port)
+	return smtp.SendMail(addr, auth, m.cfg.From, []string{msg.To}, format(m.cfg.From, msg))
+}
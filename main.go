@@ -4,9 +4,17 @@ import (
 	"context"
 	"database/sql"
 	_ "embed"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"server/auth"
 	"server/database"
+	"server/mail"
+	"server/storage"
+	"strconv"
+	"strings"
+	"time"
 
 	_ "github.com/glebarez/go-sqlite"
 )
@@ -18,7 +26,68 @@ type app struct {
 	DB    *sql.DB
 	CACHE *sql.DB
 	Query *database.Queries
+	Store storage.Blob
+	Mail  mail.Mailer
 	Ctx   context.Context
+	// PublicURL is where users reach the web client, used for links in mails.
+	PublicURL string
+	// Registration is one of the registration modes.
+	Registration string
+	// DefaultQuota limits the bytes stored by users without their own quota,
+	// 0 means no limit.
+	DefaultQuota int64
+}
+
+// envDuration reads a duration such as "72h" from the environment.
+func envDuration(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	return time.ParseDuration(value)
+}
+
+// openStorage selects the blob store from the environment. STORAGE_DRIVER is
+// either "fs" (the default, files below STORAGE_PATH) or "s3".
+func openStorage() (storage.Blob, error) {
+	switch driver := os.Getenv("STORAGE_DRIVER"); driver {
+	case "", "fs":
+		root := os.Getenv("STORAGE_PATH")
+		if root == "" {
+			root = "../storage/"
+		}
+		return storage.NewFS(root)
+	case "s3":
+		return storage.NewS3(storage.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown storage driver: %q", driver)
+	}
+}
+
+// openMailer selects how mails are delivered. MAIL_DRIVER is either "log"
+// (the default, mails are only logged or written to MAIL_PATH) or "smtp".
+func openMailer() (mail.Mailer, error) {
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "", "log":
+		return mail.NewFile(os.Getenv("MAIL_PATH"), os.Getenv("MAIL_FROM"))
+	case "smtp":
+		return mail.NewSMTP(mail.SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     os.Getenv("SMTP_PORT"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("MAIL_FROM"),
+		})
+	default:
+		return nil, fmt.Errorf("unknown mail driver: %q", driver)
+	}
 }
 
 func main() {
@@ -29,27 +98,105 @@ func main() {
 		log.Fatal(err)
 	}
 
-	dbCache, err := sql.Open("sqlite", "file::memory:?cache=shared")
+	// Sessions are lost on restart unless SESSION_DB names a database file
+	sessionDB := os.Getenv("SESSION_DB")
+	if sessionDB == "" {
+		sessionDB = "file::memory:?cache=shared"
+	}
+
+	dbCache, err := sql.Open("sqlite", sessionDB)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	_, err = db.ExecContext(ctx, ddl)
-	if err != nil {
-		log.Println(err)
+	if err = database.Migrate(db, ddl); err != nil {
+		log.Fatal(err)
 	}
 
 	if err = database.SetupCache(dbCache); err != nil {
 		log.Fatal(err)
 	}
 
+	if auth.IdleTimeout, err = envDuration("SESSION_IDLE_TIMEOUT", auth.IdleTimeout); err != nil {
+		log.Fatal(err)
+	}
+
+	if auth.MaxAge, err = envDuration("SESSION_MAX_AGE", auth.MaxAge); err != nil {
+		log.Fatal(err)
+	}
+
+	if auth.ResetTokenTTL, err = envDuration("PASSWORD_RESET_TTL", auth.ResetTokenTTL); err != nil {
+		log.Fatal(err)
+	}
+
+	if err = os.MkdirAll(uploadDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	store, err := openStorage()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	mailer, err := openMailer()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	registration := os.Getenv("REGISTRATION_MODE")
+	if registration == "" {
+		registration = registrationOpen
+	}
+
+	if !validRegistrationMode(registration) {
+		log.Fatalf("unknown registration mode: %q", registration)
+	}
+
+	var defaultQuota int64
+	if value := os.Getenv("STORAGE_QUOTA"); value != "" {
+		defaultQuota, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || defaultQuota < 0 {
+			log.Fatalf("invalid storage quota: %q", value)
+		}
+	}
+
+	publicURL := os.Getenv("PUBLIC_URL")
+	if publicURL == "" {
+		publicURL = "http://localhost:8000"
+	}
+
 	app := app{
-		DB:    db,
-		CACHE: dbCache,
-		Query: database.New(db),
-		Ctx:   ctx,
+		DB:           db,
+		CACHE:        dbCache,
+		Query:        database.New(db),
+		Store:        store,
+		Mail:         mailer,
+		Ctx:          ctx,
+		PublicURL:    strings.TrimRight(publicURL, "/"),
+		Registration: registration,
+		DefaultQuota: defaultQuota,
 	}
 
+	if err = app.migrateLegacyFiles(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err = app.measureFiles(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err = app.nestSlashTags(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err = app.locateFiles(); err != nil {
+		log.Fatal(err)
+	}
+
+	go app.cleanupUploads(time.Hour, 24*time.Hour)
+	go app.collectBlobs(time.Hour)
+	go app.sweepSessions(10 * time.Minute)
+
 	server := http.Server{
 		Addr:    ":8000",
 		Handler: app.routes(),
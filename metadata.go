@@ -0,0 +1,155 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"strings"
+
+	"server/database"
+	"server/exif"
+	"server/geo"
+	"server/types"
+)
+
+// readMetadata reads the EXIF and XMP metadata of new content from r, which
+// is left at its start. When the client sent no coordinates they are taken
+// from the GPS position, so call it before locate. Formats without metadata
+// give an empty result.
+func readMetadata(r io.ReadSeeker, metadata *database.AddFileParams) (exif.Metadata, error) {
+	camera, err := exif.Read(r)
+	if err != nil {
+		if !errors.Is(err, exif.ErrUnknownFormat) {
+			log.Printf("Could not read the metadata of %s: %v", metadata.Checksum, err)
+		}
+		camera = exif.Metadata{}
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return exif.Metadata{}, err
+	}
+
+	fillCoordinates(metadata, camera)
+	return camera, nil
+}
+
+// linkedMetadata returns the metadata of the owner's other file with the
+// same content, which a linked file shares as it is not read again. Like
+// readMetadata, call it before locate.
+func (app *app) linkedMetadata(metadata *database.AddFileParams) (exif.Metadata, error) {
+	row, err := app.Query.GetMetadataByChecksum(app.Ctx, database.GetMetadataByChecksumParams{
+		OwnerID:  metadata.OwnerID,
+		Checksum: metadata.Checksum,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return exif.Metadata{}, nil
+	}
+	if err != nil {
+		return exif.Metadata{}, err
+	}
+
+	camera := exif.Metadata{
+		TakenAt:      row.TakenAt.Time,
+		Make:         row.CameraMake.String,
+		Model:        row.CameraModel.String,
+		Lens:         row.Lens.String,
+		ExposureTime: row.ExposureTime.Float64,
+		FNumber:      row.FNumber.Float64,
+		ISO:          row.Iso.Int64,
+		FocalLength:  row.FocalLength.Float64,
+		Orientation:  int(row.Orientation.Int64),
+	}
+	if row.Latitude.Valid && row.Longitude.Valid {
+		camera.Location = &geo.Point{Lat: row.Latitude.Float64, Lon: row.Longitude.Float64}
+	}
+
+	fillCoordinates(metadata, camera)
+	return camera, nil
+}
+
+// fillCoordinates takes the coordinates of the file from the GPS position
+// when the client sent none.
+func fillCoordinates(metadata *database.AddFileParams, camera exif.Metadata) {
+	if camera.Location != nil && strings.TrimSpace(metadata.Coordinates.String) == "" {
+		metadata.Coordinates = nullString(fmt.Sprintf("%.6f, %.6f", camera.Location.Lat, camera.Location.Lon))
+	}
+}
+
+// addMetadata records the metadata read for a new file. Failing to do so does
+// not fail the upload, the file is stored already.
+func (app *app) addMetadata(fileID int64, camera exif.Metadata) {
+	if camera.Empty() {
+		return
+	}
+
+	params := database.AddFileMetadataParams{
+		FileID:       fileID,
+		TakenAt:      types.JSONNullTime{NullTime: sql.NullTime{Time: camera.TakenAt, Valid: !camera.TakenAt.IsZero()}},
+		CameraMake:   nullString(camera.Make),
+		CameraModel:  nullString(camera.Model),
+		Lens:         nullString(camera.Lens),
+		ExposureTime: nullFloat(camera.ExposureTime),
+		FNumber:      nullFloat(camera.FNumber),
+		Iso:          nullInt(camera.ISO),
+		FocalLength:  nullFloat(camera.FocalLength),
+		Orientation:  nullInt(int64(camera.Orientation)),
+	}
+
+	if camera.Location != nil {
+		params.Latitude = types.JSONNullFloat64{NullFloat64: sql.NullFloat64{Float64: camera.Location.Lat, Valid: true}}
+		params.Longitude = types.JSONNullFloat64{NullFloat64: sql.NullFloat64{Float64: camera.Location.Lon, Valid: true}}
+	}
+
+	if err := app.Query.AddFileMetadata(app.Ctx, params); err != nil {
+		log.Println(err)
+	}
+}
+
+// metadataBatch limits the file ids per GetFilesMetadata query, SQLite
+// limits the number of parameters of a statement.
+const metadataBatch = 500
+
+// fileWithMetadata is a file listed together with what was read from its
+// EXIF and XMP, Metadata is nil when there was none.
+type fileWithMetadata struct {
+	database.File
+	Metadata *database.Filemetadata `json:"metadata"`
+}
+
+// withMetadata attaches the metadata of each file, for listings whose
+// queries do not join fileMetadata themselves.
+func (app *app) withMetadata(files []database.File) ([]fileWithMetadata, error) {
+	byFile := make(map[int64]*database.Filemetadata, len(files))
+	for batch := range slices.Chunk(files, metadataBatch) {
+		ids := make([]int64, len(batch))
+		for i, file := range batch {
+			ids[i] = file.ID
+		}
+
+		rows, err := app.Query.GetFilesMetadata(app.Ctx, ids)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range rows {
+			byFile[rows[i].FileID] = &rows[i]
+		}
+	}
+
+	result := make([]fileWithMetadata, len(files))
+	for i, file := range files {
+		result[i] = fileWithMetadata{File: file, Metadata: byFile[file.ID]}
+	}
+	return result, nil
+}
+
+func nullFloat(f float64) types.JSONNullFloat64 {
+	return types.JSONNullFloat64{NullFloat64: sql.NullFloat64{Float64: f, Valid: f != 0}}
+}
+
+func nullInt(i int64) types.JSONNullInt64 {
+	return types.JSONNullInt64{NullInt64: sql.NullInt64{Int64: i, Valid: i != 0}}
+}
@@ -3,45 +3,182 @@ package main
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"server/auth"
+	"slices"
+	"strings"
 )
 
+// Name of the optional HttpOnly cookie holding the session token.
+const sessionCookie = "session"
+
+// tokenFromRequest returns the session token sent in the Authorization
+// header or the session cookie, or an empty string.
+func tokenFromRequest(r *http.Request) string {
+	if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		return strings.TrimSpace(token)
+	}
+
+	if cookie, err := r.Cookie(sessionCookie); err == nil {
+		return cookie.Value
+	}
+
+	return ""
+}
+
+// authenticate accepts the token from the Authorization header, the session
+// cookie or, for older clients, the "token" field of the JSON body. The body
+// is only read in the last case.
 func (app *app) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		prepareResponse(w)
 		log.Println(r.URL)
 
-		requestData, err := io.ReadAll(r.Body)
+		token := tokenFromRequest(r)
+		if token == "" {
+			requestData, err := io.ReadAll(r.Body)
+			if err != nil {
+				sendError(w, Error{400, "Could not read request body", "Bad Request"}, err)
+				return
+			}
+			r.Body.Close()
+
+			input := struct {
+				Token string `json:"token"`
+			}{}
+
+			if len(requestData) > 0 {
+				if err := json.Unmarshal(requestData, &input); err != nil {
+					sendError(w, Error{400, "Could not read token", "Bad Request"}, err)
+					return
+				}
+			}
+
+			token = input.Token
+			r.Body = io.NopCloser(bytes.NewReader(requestData))
+		}
+
+		app.serveAuthenticated(w, r, next, token)
+	})
+}
+
+// authenticateHeader only accepts the token from the Authorization header or
+// the session cookie and leaves the request body untouched, so streaming
+// handlers can consume it directly.
+func (app *app) authenticateHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prepareResponse(w)
+		log.Println(r.URL)
+
+		app.serveAuthenticated(w, r, next, tokenFromRequest(r))
+	})
+}
+
+// scopedHandler marks a handler as reachable with API keys holding scope.
+type scopedHandler struct {
+	scope string
+	next  http.Handler
+}
+
+func (h scopedHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.next.ServeHTTP(w, r)
+}
+
+// scoped allows API keys with the given scope to use the handler. The scope
+// is checked by authenticate, handlers that are not wrapped only accept
+// session tokens.
+func scoped(scope string, next http.Handler) http.Handler {
+	return scopedHandler{scope: scope, next: next}
+}
+
+func (app *app) serveAuthenticated(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	if token == "" {
+		sendError(w, Error{401, "Missing token", "Unauthorized"}, nil)
+		return
+	}
+
+	var id int64
+	if strings.HasPrefix(token, auth.APIKeyPrefix) {
+		userID, scopes, err := auth.ValidateAPIKey(app.Query, token)
 		if err != nil {
-			sendError(w, Error{400, "Could not read request body", "Bad Request"}, err)
+			sendError(w, Error{401, "Incorrect API key", "Unauthorized"}, err)
 			return
 		}
-		r.Body.Close()
 
-		input := struct {
-			Token string `json:"token"`
-		}{}
-
-		if err := json.Unmarshal(requestData, &input); err != nil {
-			sendError(w, Error{400, "Could not read token", "Bad Request"}, err)
+		handler, ok := next.(scopedHandler)
+		if !ok || !slices.Contains(scopes, handler.scope) {
+			sendError(w, Error{403, "API key does not grant access to this endpoint", "Forbidden"}, nil)
 			return
 		}
 
-		id, err := auth.ValidateSession(app.CACHE, input.Token)
+		log.Printf("User authenticated with API key: %d", userID)
+		id = userID
+	} else {
+		userID, err := auth.ValidateSession(app.CACHE, token)
 		if err != nil {
 			sendError(w, Error{401, "Incorrect Token", "Unauthorized"}, err)
 			return
 		}
 
-		log.Printf("User authenticated: %d - %s", id, input.Token)
+		// Disabling an account ends its sessions, this also catches one
+		// created by a login running at the same time
+		user, err := app.Query.GetUser(app.Ctx, userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				sendError(w, Error{401, "Incorrect Token", "Unauthorized"}, err)
+				return
+			}
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+
+		if user.Disabled == 1 {
+			sendError(w, Error{403, "Account is disabled", "Forbidden"}, nil)
+			return
+		}
+
+		log.Printf("User authenticated: %d - %s", userID, token)
+		id = userID
+	}
+
+	ctx := context.WithValue(r.Context(), "id", id)
+	ctx = context.WithValue(ctx, "token", token)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// requireAdmin rejects users without the admin role. It has to be wrapped
+// by one of the authenticate middlewares.
+func (app *app) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Context().Value("id").(int64)
+
+		isAdmin, err := app.Query.GetIsAdmin(app.Ctx, id)
+		if err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
 
-		r.Body = io.NopCloser(bytes.NewReader(requestData))
+		if isAdmin == 0 {
+			sendError(w, Error{403, "Admin role required", "Forbidden"}, nil)
+			return
+		}
 
-		ctx := context.WithValue(r.Context(), "id", id)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		next.ServeHTTP(w, r)
 	})
 }
+
+// clientIP returns the address of the connecting client.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
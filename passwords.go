@@ -0,0 +1,252 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"server/auth"
+	"server/database"
+	"server/mail"
+	"server/types"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// setPassword stores the new password and ends every session except
+// keepToken, which may be empty. Pending reset links and logins waiting for
+// the second factor stop working too.
+func (app *app) setPassword(w http.ResponseWriter, id int64, password, keepToken string) bool {
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		if errors.Is(err, auth.ErrWeakPassword) {
+			sendError(w, Error{400, fmt.Sprintf("Password must have at least %d characters", auth.MinPasswordLength), "Bad Request"}, err)
+			return false
+		}
+		sendError(w, Error{500, "Could not generate hash from password", "Internal Server Error"}, err)
+		return false
+	}
+
+	if err := app.Query.UpdatePassword(app.Ctx, database.UpdatePasswordParams{Password: hash, ID: id}); err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return false
+	}
+
+	if err := app.Query.DeletePasswordResets(app.Ctx, id); err != nil {
+		log.Println(err)
+	}
+
+	removed, err := database.DeleteUserTokens(app.CACHE, id, keepToken)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return false
+	}
+
+	// Logins waiting for the second factor were made with the old password
+	if err := database.DeleteUserChallenges(app.CACHE, id); err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return false
+	}
+
+	log.Printf("Password: -- Changed for user %d, removed %d sessions", id, removed)
+
+	return true
+}
+
+func (app *app) changePassword(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		OldPassword string `json:"old_password"`
+		NewPassword string `json:"new_password"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	id := r.Context().Value("id").(int64)
+
+	password, err := app.Query.GetPassword(app.Ctx, id)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(password), []byte(input.OldPassword)); err != nil {
+		sendError(w, Error{401, "Wrong password", "Unauthorized"}, err)
+		return
+	}
+
+	if !app.setPassword(w, id, input.NewPassword, r.Context().Value("token").(string)) {
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// forgotPassword mails a reset link to the account named by login or email.
+// The answer is the same whether or not an account was found, so it cannot
+// be used to find out who is registered.
+func (app *app) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	prepareResponse(w)
+
+	input := struct {
+		Login string `json:"login"`
+		Email string `json:"email"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	var users []database.GetUsersByEmailRow
+	switch {
+	case input.Login != "":
+		user, err := app.Query.GetUserByLogin(app.Ctx, input.Login)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+
+		if err == nil {
+			email, err := app.Query.GetEmail(app.Ctx, user.ID)
+			if err != nil {
+				sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+				return
+			}
+			users = append(users, database.GetUsersByEmailRow{ID: user.ID, Login: user.Login, Email: email})
+		}
+	case input.Email != "":
+		var err error
+		users, err = app.Query.GetUsersByEmail(app.Ctx, types.JSONNullString{NullString: sql.NullString{String: input.Email, Valid: true}})
+		if err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+	default:
+		sendError(w, Error{400, "Missing login or email", "Bad Request"}, nil)
+		return
+	}
+
+	for _, user := range users {
+		if !user.Email.Valid || user.Email.String == "" {
+			log.Printf("Password: -- Reset requested for user %d without an email address", user.ID)
+			continue
+		}
+
+		token, err := app.createPasswordReset(user.ID)
+		if err != nil {
+			sendError(w, Error{500, "Could not create reset token", "Internal Server Error"}, err)
+			return
+		}
+
+		// Sending may take a while, do not let the response time tell
+		// whether an account exists
+		go app.sendResetMail(user.Login, user.Email.String, token)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// createPasswordReset returns a new reset token for the user. Only the
+// latest token works.
+func (app *app) createPasswordReset(id int64) (string, error) {
+	token, hash, err := auth.NewMailToken()
+	if err != nil {
+		return "", err
+	}
+
+	if err := app.Query.DeletePasswordResets(app.Ctx, id); err != nil {
+		return "", err
+	}
+
+	err = app.Query.CreatePasswordReset(app.Ctx, database.CreatePasswordResetParams{
+		UserID:    id,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(auth.ResetTokenTTL).UTC(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// resetLink is the web client page where a reset token is used.
+func (app *app) resetLink(token string) string {
+	return app.PublicURL + "/reset-password?token=" + url.QueryEscape(token)
+}
+
+func (app *app) sendResetMail(login, email, token string) {
+	err := app.Mail.Send(app.Ctx, mail.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"a password reset was requested for your account. Open the link below\n"+
+			"to choose a new password:\n\n%s\n\n"+
+			"The link is valid for %d minutes and can be used once. If you did not ask\n"+
+			"for this, ignore this message and your password stays the same.\n",
+			login, app.resetLink(token), int(auth.ResetTokenTTL.Minutes())),
+	})
+	if err != nil {
+		log.Printf("Password: -- Could not send reset mail to %s: %v", email, err)
+	}
+}
+
+// resetPassword sets a new password with a token from forgotPassword. The
+// token works once, and every session of the user is ended.
+func (app *app) resetPassword(w http.ResponseWriter, r *http.Request) {
+	prepareResponse(w)
+
+	input := struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	// Reject weak passwords before the token is used up
+	if len(input.NewPassword) < auth.MinPasswordLength {
+		sendError(w, Error{400, fmt.Sprintf("Password must have at least %d characters", auth.MinPasswordLength), "Bad Request"}, nil)
+		return
+	}
+
+	reset, err := app.Query.UsePasswordReset(app.Ctx, auth.HashMailToken(input.Token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			sendError(w, Error{400, "Invalid or expired reset token", "Bad Request"}, err)
+			return
+		}
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if time.Now().After(reset.ExpiresAt) {
+		sendError(w, Error{400, "Invalid or expired reset token", "Bad Request"}, nil)
+		return
+	}
+
+	if !app.setPassword(w, reset.UserID, input.NewPassword, "") {
+		return
+	}
+
+	// Whoever reset the password may have been locked out by an attacker
+	login, err := app.Query.GetLogin(app.Ctx, reset.UserID)
+	if err == nil {
+		_, err = auth.Unlock(app.CACHE, auth.LockoutLogin, login)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
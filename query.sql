@@ -1,9 +1,9 @@
--- name: CreateUser :exec
+-- name: CreateUser :one
 INSERT INTO users (
-  login, password, email
+  login, password, email, active
 ) VALUES(
-  ?, ?, ?
-);
+  ?, ?, ?, ?
+) RETURNING id;
 
 -- name: UpdateUser :one
 UPDATE users
@@ -18,11 +18,11 @@ WHERE id = ?
 RETURNING *;
 
 -- name: GetUser :one
-SELECT id, login, password, is_admin FROM users 
+SELECT id, login, password, is_admin, active, disabled FROM users 
 WHERE id = ? LIMIT 1;
 
 -- name: GetUserByLogin :one
-SELECT id, login, password, is_admin FROM users 
+SELECT id, login, password, is_admin, active, disabled FROM users 
 WHERE login = ? LIMIT 1;
 
 -- name: GetLogin :one
@@ -33,6 +33,33 @@ WHERE id = ? LIMIT 1;
 SELECT password FROM users 
 WHERE id = ? LIMIT 1;
 
+-- name: UpdatePassword :exec
+UPDATE users
+SET password = ?
+WHERE id = ?;
+
+-- name: GetUsersByEmail :many
+SELECT id, login, email, active, email_verified, disabled FROM users
+WHERE email = ?;
+
+-- name: ActivateUser :execrows
+UPDATE users
+SET active = 1, email_verified = 1
+WHERE id = ? AND email_verified = 0 AND disabled = 0;
+
+-- name: GetUsers :many
+SELECT id, login, email, is_admin, active, email_verified, quota, disabled FROM users
+ORDER BY id;
+
+-- name: SetUserDisabled :execrows
+UPDATE users
+SET disabled = ?
+WHERE id = ?;
+
+-- name: DeleteUser :execrows
+DELETE FROM users
+WHERE id = ?;
+
 -- name: GetRole :one
 SELECT is_admin FROM users 
 WHERE id = ? LIMIT 1;
@@ -51,35 +78,86 @@ WHERE id = ? LIMIT 1;
 
 -- name: AddFile :one
 INSERT INTO files (
-  owner_id, file_name, title, description, coordinates, checksum
+  owner_id, file_name, title, description, coordinates, checksum, size, latitude, longitude
 ) VALUES(
-  ?, ?, ?, ?, ?, ?
+  ?, ?, ?, ?, ?, ?, ?, ?, ?
 ) RETURNING id;
 
 -- name: AddTag :one
 INSERT INTO tags (
-  name
+  owner_id, parent_id, name
 ) VALUES (
-  ?
+  ?, ?, ?
 )
 RETURNING id;
 
 -- name: GetTags :many
-select id, name
-FROM tags;
+WITH RECURSIVE tagPaths (id, path) AS (
+  SELECT id, name FROM tags
+  WHERE parent_id = 0 AND (owner_id = ?1 OR owner_id = 0)
+  UNION ALL
+  SELECT tags.id, tagPaths.path || '/' || tags.name FROM tags
+  JOIN tagPaths ON tags.parent_id = tagPaths.id
+  WHERE tags.owner_id = ?1 OR tags.owner_id = 0
+)
+SELECT tags.id, tags.owner_id, tags.parent_id, tags.name, CAST(tagPaths.path AS TEXT) AS path, COUNT(files.id) AS file_count
+FROM tags
+JOIN tagPaths ON tagPaths.id = tags.id
+LEFT JOIN fileTags ON fileTags.tag_id = tags.id
+LEFT JOIN files ON files.id = fileTags.file_id AND files.owner_id = ?1
+GROUP BY tags.id
+ORDER BY path;
+
+-- name: GetChildTag :one
+SELECT id, owner_id, parent_id, name
+FROM tags
+WHERE parent_id = ? AND name = ? AND (owner_id = ? OR owner_id = 0)
+ORDER BY owner_id DESC
+LIMIT 1;
 
--- name: GetTagByName :one
-SELECT id, name
+-- name: GetChildTags :many
+SELECT id, owner_id, parent_id, name
 FROM tags
-WHERE name = ?;
+WHERE parent_id = ?;
 
 -- name: GetTagById :one
-SELECT id, name
+SELECT id, owner_id, parent_id, name
 FROM tags
 WHERE id = ?;
 
+-- name: GetTagPath :one
+WITH RECURSIVE ancestors (id, parent_id, path) AS (
+  SELECT id, parent_id, name FROM tags
+  WHERE tags.id = ?
+  UNION ALL
+  SELECT tags.id, tags.parent_id, tags.name || '/' || ancestors.path FROM tags
+  JOIN ancestors ON tags.id = ancestors.parent_id
+)
+SELECT CAST(path AS TEXT) AS path FROM ancestors
+WHERE parent_id = 0;
+
+-- name: GetTagDescendants :many
+WITH RECURSIVE subtree (id) AS (
+  SELECT tags.id FROM tags
+  WHERE tags.id = ?
+  UNION
+  SELECT tags.id FROM tags
+  JOIN subtree ON tags.parent_id = subtree.id
+)
+SELECT id FROM subtree;
+
+-- name: SetTagParent :exec
+UPDATE tags
+SET parent_id = ?
+WHERE id = ?;
+
+-- name: GetSlashTags :many
+SELECT id, owner_id, parent_id, name
+FROM tags
+WHERE name LIKE '%/%';
+
 -- name: TagsConnect :exec
-INSERT INTO fileTags (
+INSERT OR IGNORE INTO fileTags (
   file_id, tag_id
 ) VALUES (
   ?, ?
@@ -91,10 +169,24 @@ FROM fileTags
 WHERE file_id = ?;
 
 -- name: GetFilesByTag :many
-SELECT files.* FROM fileTags
-LEFT JOIN files ON files.id = fileTags.file_id
+SELECT files.* FROM files
+JOIN fileTags ON fileTags.file_id = files.id
 WHERE fileTags.tag_id = ? AND files.owner_id = ?;
 
+-- name: GetFilesByTagTree :many
+WITH RECURSIVE subtree (id) AS (
+  SELECT tags.id FROM tags
+  WHERE tags.id = ?
+  UNION
+  SELECT tags.id FROM tags
+  JOIN subtree ON tags.parent_id = subtree.id
+)
+SELECT files.* FROM files
+WHERE files.owner_id = ? AND files.id IN (
+  SELECT file_id FROM fileTags
+  WHERE tag_id IN (SELECT id FROM subtree)
+);
+
 
 -- name: GetFiles :many
 SELECT id, file_name, checksum, created_at FROM files 
@@ -108,6 +200,14 @@ WHERE id = ?;
 SELECT owner_id FROM files
 WHERE id = ?;
 
+-- name: GetFileChecksums :many
+SELECT checksum FROM files
+WHERE owner_id = ?;
+
+-- name: DeleteUserFiles :exec
+DELETE FROM files
+WHERE owner_id = ?;
+
 -- name: DeleteFile :exec
 DELETE FROM files
 WHERE id = ?;
@@ -123,7 +223,7 @@ RETURNING *;
 -- name: GetSharedFiles :many
 SELECT fileGuestShares.* FROM fileGuestShares
 LEFT JOIN files ON files.id = fileGuestShares.file_id
-WHERE files.owner_id = ? OR ? = 1;
+WHERE files.owner_id = sqlc.arg(owner_id) OR sqlc.arg(is_admin) = 1;
 
 -- name: GetShareDownload :one
 SELECT files.* FROM fileGuestShares
@@ -166,3 +266,299 @@ INSERT OR IGNORE INTO fileAlbum (
 SELECT file_id
 FROM fileAlbum
 WHERE album_id = ?;
+
+-- name: CreateUpload :one
+INSERT INTO uploads (
+  owner_id, file_name, title, description, coordinates, tags, checksum, length
+) VALUES (
+  ?, ?, ?, ?, ?, ?, ?, ?
+)
+RETURNING *;
+
+-- name: GetUpload :one
+SELECT * FROM uploads
+WHERE id = ?;
+
+-- name: SetUploadOffset :exec
+UPDATE uploads
+SET upload_offset = ?, updated_at = CURRENT_TIMESTAMP
+WHERE id = ?;
+
+-- name: DeleteUpload :exec
+DELETE FROM uploads
+WHERE id = ?;
+
+-- name: GetStaleUploads :many
+SELECT id FROM uploads
+WHERE updated_at < datetime('now', sqlc.arg(age));
+
+-- name: GetBlobRefs :one
+SELECT refs FROM blobs
+WHERE checksum = ?;
+
+-- name: GetUnusedBlobs :many
+SELECT checksum FROM blobs
+WHERE refs <= 0;
+
+-- name: DeleteUnusedBlob :exec
+DELETE FROM blobs
+WHERE checksum = ? AND refs <= 0;
+
+-- name: HasFileWithChecksum :one
+SELECT COUNT(*) FROM files
+WHERE owner_id = ? AND checksum = ?;
+
+-- name: CreateAPIKey :one
+INSERT INTO apiKeys (
+  user_id, name, key_hash, scopes, expires_at
+) VALUES (
+  ?, ?, ?, ?, ?
+)
+RETURNING *;
+
+-- name: GetAPIKeyByHash :one
+SELECT * FROM apiKeys
+WHERE key_hash = ?;
+
+-- name: GetAPIKeys :many
+SELECT id, name, scopes, created_at, expires_at, last_used_at FROM apiKeys
+WHERE user_id = ?;
+
+-- name: TouchAPIKey :exec
+UPDATE apikeys
+SET last_used_at = CURRENT_TIMESTAMP
+WHERE id = ?;
+
+-- name: DeleteAPIKey :execrows
+DELETE FROM apiKeys
+WHERE id = ? AND user_id = ?;
+
+-- name: SetTOTPSecret :exec
+INSERT INTO totp (
+  user_id, secret
+) VALUES (
+  ?, ?
+)
+ON CONFLICT (user_id) DO UPDATE SET secret = excluded.secret, confirmed = 0, last_step = 0;
+
+-- name: GetTOTP :one
+SELECT * FROM totp
+WHERE user_id = ?;
+
+-- name: ConfirmTOTP :exec
+UPDATE totp
+SET confirmed = 1, last_step = ?
+WHERE user_id = ?;
+
+-- name: UseTOTPStep :execrows
+UPDATE totp
+SET last_step = ?
+WHERE user_id = ? AND last_step < ?;
+
+-- name: DeleteTOTP :execrows
+DELETE FROM totp
+WHERE user_id = ?;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO recoveryCodes (
+  user_id, code_hash
+) VALUES (
+  ?, ?
+);
+
+-- name: UseRecoveryCode :execrows
+UPDATE recoverycodes
+SET used_at = CURRENT_TIMESTAMP
+WHERE user_id = ? AND code_hash = ? AND used_at IS NULL;
+
+-- name: CountRecoveryCodes :one
+SELECT COUNT(*) FROM recoveryCodes
+WHERE user_id = ? AND used_at IS NULL;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recoveryCodes
+WHERE user_id = ?;
+
+-- name: CreatePasswordReset :exec
+INSERT INTO passwordResets (
+  user_id, token_hash, expires_at
+) VALUES (
+  ?, ?, ?
+);
+
+-- name: UsePasswordReset :one
+UPDATE passwordresets
+SET used_at = CURRENT_TIMESTAMP
+WHERE token_hash = ? AND used_at IS NULL
+RETURNING user_id, expires_at;
+
+-- name: DeletePasswordResets :exec
+DELETE FROM passwordResets
+WHERE user_id = ?;
+
+-- name: CreateEmailVerification :exec
+INSERT INTO emailVerifications (
+  user_id, token_hash, expires_at
+) VALUES (
+  ?, ?, ?
+);
+
+-- name: UseEmailVerification :one
+DELETE FROM emailVerifications
+WHERE token_hash = ?
+RETURNING user_id, expires_at;
+
+-- name: DeleteEmailVerifications :exec
+DELETE FROM emailVerifications
+WHERE user_id = ?;
+
+-- name: CreateInvite :one
+INSERT INTO invites (
+  code_hash, created_by, note, max_uses, expires_at
+) VALUES (
+  ?, ?, ?, ?, ?
+)
+RETURNING *;
+
+-- name: UseInvite :one
+UPDATE invites
+SET uses = uses + 1
+WHERE code_hash = ? AND uses < max_uses
+RETURNING id, expires_at;
+
+-- name: GetInvites :many
+SELECT id, created_by, note, max_uses, uses, created_at, expires_at FROM invites
+ORDER BY id DESC;
+
+-- name: DeleteInvite :execrows
+DELETE FROM invites
+WHERE id = ?;
+
+-- name: GetUserFiles :many
+SELECT * FROM files
+WHERE owner_id = ?;
+
+-- name: GetUserUploads :many
+SELECT id FROM uploads
+WHERE owner_id = ?;
+
+-- name: DeleteUserShares :exec
+DELETE FROM fileGuestShares
+WHERE file_id IN (SELECT id FROM files WHERE owner_id = ?);
+
+-- name: DeleteUserFileTags :exec
+DELETE FROM fileTags
+WHERE file_id IN (SELECT id FROM files WHERE owner_id = ?);
+
+-- name: DeleteUserAlbumFiles :exec
+DELETE FROM fileAlbum
+WHERE file_id IN (SELECT files.id FROM files WHERE files.owner_id = ?1)
+   OR album_id IN (SELECT album.id FROM album WHERE album.owner_id = ?1);
+
+-- name: DeleteUserAlbums :exec
+DELETE FROM album
+WHERE owner_id = ?;
+
+-- name: DeleteUserUploads :exec
+DELETE FROM uploads
+WHERE owner_id = ?;
+
+-- name: DeleteUserAPIKeys :exec
+DELETE FROM apiKeys
+WHERE user_id = ?;
+
+-- name: ClearInviteCreator :exec
+UPDATE invites
+SET created_by = NULL
+WHERE created_by = ?;
+
+-- name: GetStorageUsage :one
+SELECT COUNT(*) AS files, CAST(COALESCE(SUM(size), 0) AS INTEGER) AS bytes FROM files
+WHERE owner_id = ?;
+
+-- name: GetPendingUploadBytes :one
+SELECT CAST(COALESCE(SUM(length), 0) AS INTEGER) AS bytes FROM uploads
+WHERE owner_id = ?;
+
+-- name: GetQuota :one
+SELECT quota FROM users
+WHERE id = ?;
+
+-- name: SetQuota :execrows
+UPDATE users
+SET quota = ?
+WHERE id = ?;
+
+-- name: GetUnsizedChecksums :many
+SELECT DISTINCT checksum FROM files
+WHERE size < 0;
+
+-- name: SetSizeByChecksum :exec
+UPDATE files
+SET size = ?
+WHERE checksum = ?;
+
+-- name: DeleteUserTags :exec
+DELETE FROM tags
+WHERE owner_id = ? AND owner_id <> 0;
+
+-- name: UntagFile :execrows
+DELETE FROM fileTags
+WHERE file_id = ? AND tag_id = ?;
+
+-- name: RenameTag :execrows
+UPDATE tags
+SET name = ?
+WHERE id = ? AND owner_id = ?;
+
+-- name: MoveFileTags :exec
+UPDATE OR IGNORE filetags
+SET tag_id = sqlc.arg(into_id)
+WHERE tag_id = sqlc.arg(from_id);
+
+-- name: DeleteTagFiles :exec
+DELETE FROM fileTags
+WHERE tag_id = ?;
+
+-- name: DeleteTag :execrows
+DELETE FROM tags
+WHERE id = ? AND owner_id = ?;
+
+-- name: DeleteUnusedTags :execrows
+DELETE FROM tags
+WHERE tags.owner_id = ? AND tags.owner_id <> 0
+  AND tags.id NOT IN (SELECT fileTags.tag_id FROM fileTags JOIN files ON files.id = fileTags.file_id)
+  AND tags.id NOT IN (SELECT parents.parent_id FROM tags AS parents);
+
+-- name: GetUnlocatedFiles :many
+SELECT id, coordinates FROM files
+WHERE latitude IS NULL AND coordinates IS NOT NULL AND coordinates <> '';
+
+-- name: SetFileLocation :exec
+UPDATE files
+SET latitude = ?, longitude = ?
+WHERE id = ?;
+
+-- name: GetLocatedFiles :many
+SELECT * FROM files
+WHERE owner_id = ? AND latitude IS NOT NULL AND longitude IS NOT NULL
+ORDER BY created_at, id;
+
+-- name: AddFileMetadata :exec
+INSERT INTO fileMetadata (file_id, taken_at, camera_make, camera_model, lens, exposure_time, f_number, iso, focal_length, orientation, latitude, longitude)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: GetFileMetadata :one
+SELECT * FROM fileMetadata
+WHERE file_id = ?;
+
+-- name: GetFilesMetadata :many
+SELECT * FROM fileMetadata
+WHERE file_id IN (sqlc.slice(file_ids));
+
+-- name: GetMetadataByChecksum :one
+SELECT fileMetadata.* FROM fileMetadata
+JOIN files ON files.id = fileMetadata.file_id
+WHERE files.owner_id = ? AND files.checksum = ?
+ORDER BY files.id
+LIMIT 1;
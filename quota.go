@@ -0,0 +1,208 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+
+	"server/database"
+	"server/storage"
+	"server/types"
+)
+
+// Returned by addFile and linkFile when the file does not fit into the
+// storage quota of its owner.
+var (
+	errQuotaExceeded = errors.New("storage quota exceeded")
+	errFileTooLarge  = errors.New("file is larger than the storage quota")
+)
+
+// quotaLocks holds a *sync.Mutex per user id. Checking the quota and adding
+// the file happen under it, so parallel uploads cannot overshoot together.
+var quotaLocks sync.Map
+
+func quotaLock(id int64) *sync.Mutex {
+	lock, _ := quotaLocks.LoadOrStore(id, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+// quotaRoom returns the quota of the user in bytes and how much of it is
+// left. A quota of 0 means the user has no limit.
+func (app *app) quotaRoom(id int64) (int64, int64, error) {
+	quota, err := app.Query.GetQuota(app.Ctx, id)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	limit := app.DefaultQuota
+	if quota.Valid {
+		limit = quota.Int64
+	}
+
+	if limit == 0 {
+		return 0, 0, nil
+	}
+
+	usage, err := app.Query.GetStorageUsage(app.Ctx, id)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return limit, max(limit-usage.Bytes, 0), nil
+}
+
+// checkQuota returns errFileTooLarge or errQuotaExceeded if size more bytes
+// do not fit into the quota of the user.
+func (app *app) checkQuota(id, size int64) error {
+	quota, room, err := app.quotaRoom(id)
+	if err != nil {
+		return err
+	}
+
+	return quotaError(quota, room, size)
+}
+
+func quotaError(quota, room, size int64) error {
+	switch {
+	case quota == 0:
+		return nil
+	case size > quota:
+		return errFileTooLarge
+	case size > room:
+		return errQuotaExceeded
+	}
+
+	return nil
+}
+
+// sendQuotaError answers uploads rejected by checkQuota and reports whether
+// err was such a rejection.
+func sendQuotaError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, errFileTooLarge):
+		sendError(w, Error{413, "File is larger than the storage quota", "Request Entity Too Large"}, err)
+	case errors.Is(err, errQuotaExceeded):
+		sendError(w, Error{507, "Storage quota exceeded", "Insufficient Storage"}, err)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// measureFiles fills in the size of files stored before sizes were recorded.
+func (app *app) measureFiles() error {
+	checksums, err := app.Query.GetUnsizedChecksums(app.Ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, checksum := range checksums {
+		var size int64
+
+		info, err := app.Store.Stat(app.Ctx, blobKey(checksum))
+		switch {
+		case err == nil:
+			size = info.Size
+		case errors.Is(err, storage.ErrNotExist):
+			log.Printf("Missing blob for checksum %s", checksum)
+		default:
+			return err
+		}
+
+		err = app.Query.SetSizeByChecksum(app.Ctx, database.SetSizeByChecksumParams{Size: size, Checksum: checksum})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(checksums) > 0 {
+		log.Printf("Recorded the size of %d stored files", len(checksums))
+	}
+
+	return nil
+}
+
+// getStorageUsage reports how much the user stores and how much room their
+// quota leaves. quota and remaining are null when there is no limit.
+func (app *app) getStorageUsage(w http.ResponseWriter, r *http.Request) {
+	id := r.Context().Value("id").(int64)
+
+	usage, err := app.Query.GetStorageUsage(app.Ctx, id)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	quota, room, err := app.quotaRoom(id)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	output := struct {
+		Files     int64  `json:"files"`
+		Bytes     int64  `json:"bytes"`
+		Quota     *int64 `json:"quota"`
+		Remaining *int64 `json:"remaining"`
+	}{
+		Files: usage.Files,
+		Bytes: usage.Bytes,
+	}
+
+	if quota > 0 {
+		output.Quota = &quota
+		output.Remaining = &room
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// adminSetQuota sets the quota of a user in bytes. 0 removes the limit and
+// null goes back to the server default.
+func (app *app) adminSetQuota(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		UserID int64  `json:"user_id"`
+		Quota  *int64 `json:"quota"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	params := database.SetQuotaParams{ID: input.UserID}
+	if input.Quota != nil {
+		if *input.Quota < 0 {
+			sendError(w, Error{400, "Quota must not be negative", "Bad Request"}, nil)
+			return
+		}
+		params.Quota = types.JSONNullInt64{NullInt64: sql.NullInt64{Int64: *input.Quota, Valid: true}}
+	}
+
+	updated, err := app.Query.SetQuota(app.Ctx, params)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if updated == 0 {
+		sendError(w, Error{404, "User not found", "Not Found"}, nil)
+		return
+	}
+
+	if input.Quota == nil {
+		log.Printf("Admin: -- Reset quota of user %d to the default", input.UserID)
+	} else {
+		log.Printf("Admin: -- Set quota of user %d to %d bytes", input.UserID, *input.Quota)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
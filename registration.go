@@ -0,0 +1,287 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"server/auth"
+	"server/database"
+	"server/mail"
+	"server/types"
+)
+
+// Registration modes, selected with REGISTRATION_MODE.
+const (
+	registrationOpen   = "open"   // anyone can register
+	registrationVerify = "verify" // accounts stay inactive until the email is confirmed
+	registrationInvite = "invite" // an invite code minted by an admin is required
+	registrationClosed = "closed" // only existing accounts
+)
+
+func validRegistrationMode(mode string) bool {
+	switch mode {
+	case registrationOpen, registrationVerify, registrationInvite, registrationClosed:
+		return true
+	}
+
+	return false
+}
+
+// getRegistrationMode lets clients show the right sign-up form.
+func (app *app) getRegistrationMode(w http.ResponseWriter, r *http.Request) {
+	prepareResponse(w)
+
+	output := struct {
+		Mode string `json:"mode"`
+	}{
+		Mode: app.Registration,
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (app *app) sendVerificationMail(login, email, token string) {
+	link := app.PublicURL + "/verify-email?token=" + url.QueryEscape(token)
+
+	err := app.Mail.Send(app.Ctx, mail.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"open the link below to confirm your email address and activate\n"+
+			"your account:\n\n%s\n\n"+
+			"The link is valid for %d hours. If you did not register, ignore\n"+
+			"this message.\n",
+			login, link, int(auth.VerifyTokenTTL.Hours())),
+	})
+	if err != nil {
+		log.Printf("Register: -- Could not send verification mail to %s: %v", email, err)
+	}
+}
+
+// verifyEmail activates the account with a token from the verification mail.
+func (app *app) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	prepareResponse(w)
+
+	input := struct {
+		Token string `json:"token"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	verification, err := app.Query.UseEmailVerification(app.Ctx, auth.HashMailToken(input.Token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			sendError(w, Error{400, "Invalid or expired verification token", "Bad Request"}, err)
+			return
+		}
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if time.Now().After(verification.ExpiresAt) {
+		sendError(w, Error{400, "Invalid or expired verification token", "Bad Request"}, nil)
+		return
+	}
+
+	// Verified or disabled accounts are left as they are
+	activated, err := app.Query.ActivateUser(app.Ctx, verification.UserID)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if activated == 0 {
+		sendError(w, Error{400, "Invalid or expired verification token", "Bad Request"}, nil)
+		return
+	}
+
+	log.Printf("Register: -- Verified email of user %d", verification.UserID)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// resendVerification mails a new verification link to the unverified
+// accounts with the address, except disabled ones. Like forgotPassword it
+// always answers 200.
+func (app *app) resendVerification(w http.ResponseWriter, r *http.Request) {
+	prepareResponse(w)
+
+	input := struct {
+		Email string `json:"email"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	if input.Email == "" {
+		sendError(w, Error{400, "Missing email", "Bad Request"}, nil)
+		return
+	}
+
+	users, err := app.Query.GetUsersByEmail(app.Ctx, types.JSONNullString{NullString: sql.NullString{String: input.Email, Valid: true}})
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	for _, user := range users {
+		if user.EmailVerified == 1 || user.Disabled == 1 {
+			continue
+		}
+
+		token, hash, err := auth.NewMailToken()
+		if err != nil {
+			sendError(w, Error{500, "Could not generate a new token", "Internal Server Error"}, err)
+			return
+		}
+
+		if err := app.Query.DeleteEmailVerifications(app.Ctx, user.ID); err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+
+		err = app.Query.CreateEmailVerification(app.Ctx, database.CreateEmailVerificationParams{
+			UserID:    user.ID,
+			TokenHash: hash,
+			ExpiresAt: time.Now().Add(auth.VerifyTokenTTL).UTC(),
+		})
+		if err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+
+		go app.sendVerificationMail(user.Login, input.Email, token)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// adminCreateInvite mints an invite code for invite-only registration. The
+// code is only returned here, the server keeps its hash.
+func (app *app) adminCreateInvite(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		Note      string    `json:"note"`
+		MaxUses   int64     `json:"max_uses"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	if input.MaxUses == 0 {
+		input.MaxUses = 1
+	}
+
+	if input.MaxUses < 0 {
+		sendError(w, Error{400, "max_uses must be positive", "Bad Request"}, nil)
+		return
+	}
+
+	if !input.ExpiresAt.IsZero() && input.ExpiresAt.Before(time.Now()) {
+		sendError(w, Error{400, "Expiration is in the past", "Bad Request"}, nil)
+		return
+	}
+
+	code, hash, err := auth.NewMailToken()
+	if err != nil {
+		sendError(w, Error{500, "Could not generate a new token", "Internal Server Error"}, err)
+		return
+	}
+
+	id := r.Context().Value("id").(int64)
+
+	invite, err := app.Query.CreateInvite(app.Ctx, database.CreateInviteParams{
+		CodeHash:  hash,
+		CreatedBy: types.JSONNullInt64{NullInt64: sql.NullInt64{Int64: id, Valid: true}},
+		Note:      nullString(input.Note),
+		MaxUses:   input.MaxUses,
+		ExpiresAt: types.JSONNullTime{NullTime: sql.NullTime{Time: input.ExpiresAt.UTC(), Valid: !input.ExpiresAt.IsZero()}},
+	})
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	log.Printf("Admin: -- User %d created invite %d", id, invite.ID)
+
+	output := struct {
+		ID        int64                `json:"id"`
+		Code      string               `json:"code"`
+		Note      types.JSONNullString `json:"note"`
+		MaxUses   int64                `json:"max_uses"`
+		ExpiresAt types.JSONNullTime   `json:"expires_at"`
+	}{
+		ID:        invite.ID,
+		Code:      code,
+		Note:      invite.Note,
+		MaxUses:   invite.MaxUses,
+		ExpiresAt: invite.ExpiresAt,
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (app *app) adminListInvites(w http.ResponseWriter, r *http.Request) {
+	invites, err := app.Query.GetInvites(app.Ctx)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	output := struct {
+		Invites []database.GetInvitesRow `json:"invites"`
+	}{
+		Invites: invites,
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (app *app) adminRevokeInvite(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		InviteID int64 `json:"invite_id"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	deleted, err := app.Query.DeleteInvite(app.Ctx, input.InviteID)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if deleted == 0 {
+		sendError(w, Error{404, "Invite not found", "Not Found"}, nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"server/database"
+)
+
+// Incomplete uploads are kept on local disk until the last chunk arrives
+// and are then copied into the blob store.
+const uploadDir = "../storage/uploads/"
+
+// uploadLocks holds a *sync.Mutex per upload id so that concurrent PATCH
+// requests cannot interleave writes to the same partial file, nor remove it
+// while it is written.
+var uploadLocks sync.Map
+
+// lockUpload takes the lock of the upload without waiting, reporting false
+// when another request holds it. The caller unlocks it.
+func lockUpload(id int64) (*sync.Mutex, bool) {
+	lock, _ := uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	if !lock.(*sync.Mutex).TryLock() {
+		return nil, false
+	}
+	return lock.(*sync.Mutex), true
+}
+
+func uploadPath(id int64) string {
+	return filepath.Join(uploadDir, strconv.FormatInt(id, 16))
+}
+
+func setUploadHeaders(w http.ResponseWriter, upload database.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.UploadOffset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// ownedUpload loads the upload named in the request path and checks that it
+// belongs to the authenticated user.
+func (app *app) ownedUpload(w http.ResponseWriter, r *http.Request) (database.Upload, bool) {
+	uploadID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		sendError(w, Error{400, "Invalid upload id", "Bad Request"}, err)
+		return database.Upload{}, false
+	}
+
+	upload, err := app.Query.GetUpload(app.Ctx, uploadID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			sendError(w, Error{404, "Upload not found", "Not Found"}, err)
+			return database.Upload{}, false
+		}
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return database.Upload{}, false
+	}
+
+	if upload.OwnerID != r.Context().Value("id").(int64) {
+		sendError(w, Error{404, "Upload not found", "Not Found"}, nil)
+		return database.Upload{}, false
+	}
+
+	return upload, true
+}
+
+func (app *app) createUpload(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		Length   int64                  `json:"length"`
+		Checksum string                 `json:"checksum"`
+		Metadata database.AddFileParams `json:"metadata"`
+		Tags     []string               `json:"tags"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	if input.Length <= 0 {
+		sendError(w, Error{400, "Upload length must be positive", "Bad Request"}, nil)
+		return
+	}
+
+	if input.Metadata.FileName == "" {
+		sendError(w, Error{400, "Missing file name", "Bad Request"}, nil)
+		return
+	}
+
+	if input.Checksum != "" && !validChecksum(input.Checksum) {
+		sendError(w, Error{400, "Invalid checksum", "Bad Request"}, nil)
+		return
+	}
+
+	if !validCoordinates(input.Metadata.Coordinates) {
+		sendError(w, Error{400, "Invalid coordinates", "Bad Request"}, nil)
+		return
+	}
+
+	id := r.Context().Value("id").(int64)
+
+	// Content the user already stores does not need to be sent again
+	if input.Checksum != "" {
+		input.Metadata.OwnerID = id
+		input.Metadata.Checksum = input.Checksum
+
+		fileID, linked, err := app.linkFile(input.Metadata)
+		if sendQuotaError(w, err) {
+			return
+		}
+		if err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+
+		if linked {
+			if err := app.tagFile(id, fileID, input.Tags); err != nil {
+				sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+				return
+			}
+
+			output := struct {
+				FileID int64 `json:"file_id"`
+			}{
+				FileID: fileID,
+			}
+
+			if err := json.NewEncoder(w).Encode(&output); err != nil {
+				log.Println(err)
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+	}
+
+	// Refuse uploads that cannot fit before any data is sent, counting the
+	// unfinished ones of the user as well
+	quota, room, err := app.quotaRoom(id)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	pending, err := app.Query.GetPendingUploadBytes(app.Ctx, id)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if sendQuotaError(w, quotaError(quota, room-pending, input.Length)) {
+		return
+	}
+
+	tags, err := json.Marshal(input.Tags)
+	if err != nil {
+		sendError(w, Error{400, "Could not encode tags", "Bad Request"}, err)
+		return
+	}
+
+	upload, err := app.Query.CreateUpload(app.Ctx, database.CreateUploadParams{
+		OwnerID:     id,
+		FileName:    input.Metadata.FileName,
+		Title:       input.Metadata.Title,
+		Description: input.Metadata.Description,
+		Coordinates: input.Metadata.Coordinates,
+		Tags:        nullString(string(tags)),
+		Checksum:    nullString(input.Checksum),
+		Length:      input.Length,
+	})
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	f, err := os.OpenFile(uploadPath(upload.ID), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		if err := app.Query.DeleteUpload(app.Ctx, upload.ID); err != nil {
+			log.Println(err)
+		}
+		sendError(w, Error{500, "Could not acquire file path", "Internal Server Error"}, err)
+		return
+	}
+	f.Close()
+
+	output := struct {
+		ID     int64 `json:"id"`
+		Offset int64 `json:"offset"`
+	}{
+		ID:     upload.ID,
+		Offset: upload.UploadOffset,
+	}
+
+	setUploadHeaders(w, upload)
+	w.Header().Set("Location", "/upload/"+strconv.FormatInt(upload.ID, 10))
+	w.WriteHeader(http.StatusCreated)
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		return
+	}
+}
+
+func (app *app) getUploadOffset(w http.ResponseWriter, r *http.Request) {
+	upload, ok := app.ownedUpload(w, r)
+	if !ok {
+		return
+	}
+
+	setUploadHeaders(w, upload)
+	w.WriteHeader(http.StatusOK)
+}
+
+// patchUpload appends the request body to the upload at the offset given in
+// the Upload-Offset header. Whatever was received before a dropped connection
+// is kept, so the client can resume from the offset reported by HEAD. The
+// upload is turned into a file once the declared length has been reached.
+func (app *app) patchUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		sendError(w, Error{415, "Expected application/offset+octet-stream body", "Unsupported Media Type"}, nil)
+		return
+	}
+
+	upload, ok := app.ownedUpload(w, r)
+	if !ok {
+		return
+	}
+
+	lock, ok := lockUpload(upload.ID)
+	if !ok {
+		sendError(w, Error{423, "Upload is being written by another request", "Locked"}, nil)
+		return
+	}
+	defer lock.Unlock()
+
+	// Reload under the lock, the offset may have moved since the first read
+	upload, err := app.Query.GetUpload(app.Ctx, upload.ID)
+	if err != nil {
+		sendError(w, Error{404, "Upload not found", "Not Found"}, err)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		sendError(w, Error{400, "Invalid Upload-Offset header", "Bad Request"}, err)
+		return
+	}
+
+	if offset != upload.UploadOffset {
+		setUploadHeaders(w, upload)
+		sendError(w, Error{409, fmt.Sprintf("Upload offset is %d", upload.UploadOffset), "Conflict"}, nil)
+		return
+	}
+
+	f, err := os.OpenFile(uploadPath(upload.ID), os.O_WRONLY, 0644)
+	if err != nil {
+		sendError(w, Error{500, "Could not acquire file path", "Internal Server Error"}, err)
+		return
+	}
+
+	// Drop anything past the recorded offset left behind by a crash
+	if err := f.Truncate(upload.UploadOffset); err != nil {
+		f.Close()
+		sendError(w, Error{500, "Could not write upload", "Internal Server Error"}, err)
+		return
+	}
+
+	if _, err := f.Seek(upload.UploadOffset, io.SeekStart); err != nil {
+		f.Close()
+		sendError(w, Error{500, "Could not write upload", "Internal Server Error"}, err)
+		return
+	}
+
+	written, copyErr := io.Copy(f, io.LimitReader(r.Body, upload.Length-upload.UploadOffset))
+	if err := f.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	upload.UploadOffset += written
+	if err := app.Query.SetUploadOffset(app.Ctx, database.SetUploadOffsetParams{UploadOffset: upload.UploadOffset, ID: upload.ID}); err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	setUploadHeaders(w, upload)
+
+	if copyErr != nil {
+		sendError(w, Error{400, "Could not read request body", "Bad Request"}, copyErr)
+		return
+	}
+
+	if upload.UploadOffset < upload.Length {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	fileID, err := app.finishUpload(upload)
+	if err != nil {
+		if errors.Is(err, errChecksumMismatch) {
+			sendError(w, Error{400, "Checksum mismatch for file: " + upload.FileName, "Bad Request"}, err)
+			return
+		}
+		if sendQuotaError(w, err) {
+			return
+		}
+		sendError(w, Error{500, "Could not store file: " + upload.FileName, "Internal Server Error"}, err)
+		return
+	}
+
+	output := struct {
+		FileID int64 `json:"file_id"`
+	}{
+		FileID: fileID,
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// finishUpload verifies a complete upload and moves it into the files table.
+// The upload session is removed whether or not the checksum matches.
+func (app *app) finishUpload(upload database.Upload) (int64, error) {
+	defer app.removeUpload(upload.ID)
+
+	f, err := os.Open(uploadPath(upload.ID))
+	if err != nil {
+		return 0, err
+	}
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, f)
+	f.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	checksum := hex.EncodeToString(hash.Sum(nil))
+	if upload.Checksum.Valid && upload.Checksum.String != checksum {
+		return 0, errChecksumMismatch
+	}
+
+	var tags []string
+	if upload.Tags.Valid {
+		if err := json.Unmarshal([]byte(upload.Tags.String), &tags); err != nil {
+			return 0, err
+		}
+	}
+
+	fileID, err := app.commitFile(database.AddFileParams{
+		OwnerID:     upload.OwnerID,
+		FileName:    upload.FileName,
+		Title:       upload.Title,
+		Description: upload.Description,
+		Coordinates: upload.Coordinates,
+		Checksum:    checksum,
+	}, uploadPath(upload.ID))
+	if err != nil {
+		return 0, err
+	}
+
+	return fileID, app.tagFile(upload.OwnerID, fileID, tags)
+}
+
+// removeUpload deletes the upload session and its partial data. The caller
+// must hold the lock of the upload. Requests waiting for it afterwards find
+// the upload gone when they reload it.
+func (app *app) removeUpload(id int64) {
+	if err := os.Remove(uploadPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Println(err)
+	}
+
+	if err := app.Query.DeleteUpload(app.Ctx, id); err != nil {
+		log.Println(err)
+	}
+
+	uploadLocks.Delete(id)
+}
+
+func (app *app) deleteUpload(w http.ResponseWriter, r *http.Request) {
+	upload, ok := app.ownedUpload(w, r)
+	if !ok {
+		return
+	}
+
+	lock, ok := lockUpload(upload.ID)
+	if !ok {
+		sendError(w, Error{423, "Upload is being written by another request", "Locked"}, nil)
+		return
+	}
+	defer lock.Unlock()
+
+	app.removeUpload(upload.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// cleanupUploads periodically removes uploads that have not received any data
+// for longer than maxAge.
+func (app *app) cleanupUploads(interval, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		stale, err := app.Query.GetStaleUploads(app.Ctx, fmt.Sprintf("-%d seconds", int64(maxAge.Seconds())))
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		removed := 0
+		for _, id := range stale {
+			if app.removeStaleUpload(id, maxAge) {
+				removed++
+			}
+		}
+
+		if removed > 0 {
+			log.Printf("Cleanup: -- Removed %d stale uploads", removed)
+		}
+	}
+}
+
+// removeStaleUpload removes the upload unless a request is writing to it or
+// wrote to it since it was found stale.
+func (app *app) removeStaleUpload(id int64, maxAge time.Duration) bool {
+	lock, ok := lockUpload(id)
+	if !ok {
+		return false
+	}
+	defer lock.Unlock()
+
+	upload, err := app.Query.GetUpload(app.Ctx, id)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Println(err)
+		}
+		return false
+	}
+
+	if upload.UpdatedAt.Time.After(time.Now().Add(-maxAge)) {
+		return false
+	}
+
+	app.removeUpload(id)
+	return true
+}
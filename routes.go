@@ -2,30 +2,98 @@ package main
 
 import (
 	"net/http"
+
+	"server/auth"
 )
 
 func (app *app) routes() http.Handler {
 	router := http.NewServeMux()
 
+	router.HandleFunc("GET /register/mode", app.getRegistrationMode)
 	router.HandleFunc("POST /register", app.register)
+	router.HandleFunc("POST /register/verify", app.verifyEmail)
+	router.HandleFunc("POST /register/verify/resend", app.resendVerification)
 	router.Handle("PUT /register", app.authenticate(http.HandlerFunc(app.updateUser)))
 	router.HandleFunc("POST /login", app.login)
+	router.HandleFunc("POST /login/2fa", app.loginTwoFactor)
 	router.HandleFunc("POST /logout", app.logout)
+	router.Handle("GET /account/export", app.authenticate(http.HandlerFunc(app.exportOwnAccount)))
+	router.Handle("POST /account/delete", app.authenticate(http.HandlerFunc(app.deleteAccount)))
+	router.Handle("POST /password/change", app.authenticate(http.HandlerFunc(app.changePassword)))
+	router.HandleFunc("POST /password/forgot", app.forgotPassword)
+	router.HandleFunc("POST /password/reset", app.resetPassword)
+
+	router.Handle("GET /2fa/status", app.authenticate(http.HandlerFunc(app.getTwoFactorStatus)))
+	router.Handle("POST /2fa/setup", app.authenticate(http.HandlerFunc(app.setupTwoFactor)))
+	router.Handle("POST /2fa/confirm", app.authenticate(http.HandlerFunc(app.confirmTwoFactor)))
+	router.Handle("POST /2fa/disable", app.authenticate(http.HandlerFunc(app.disableTwoFactor)))
+	router.Handle("POST /2fa/recovery", app.authenticate(http.HandlerFunc(app.regenerateRecoveryCodes)))
+	router.Handle("POST /admin/invite/create", app.authenticate(app.requireAdmin(http.HandlerFunc(app.adminCreateInvite))))
+	router.Handle("GET /admin/invite/list", app.authenticate(app.requireAdmin(http.HandlerFunc(app.adminListInvites))))
+	router.Handle("POST /admin/invite/revoke", app.authenticate(app.requireAdmin(http.HandlerFunc(app.adminRevokeInvite))))
+	router.Handle("POST /admin/tag/shared", app.authenticate(app.requireAdmin(http.HandlerFunc(app.adminAddSharedTag))))
+	router.Handle("GET /admin/lockout/list", app.authenticate(app.requireAdmin(http.HandlerFunc(app.adminListLockouts))))
+	router.Handle("POST /admin/lockout/unlock", app.authenticate(app.requireAdmin(http.HandlerFunc(app.adminUnlock))))
+	router.Handle("GET /admin/user/list", app.authenticate(app.requireAdmin(http.HandlerFunc(app.adminListUsers))))
+	router.Handle("POST /admin/user/role", app.authenticate(app.requireAdmin(http.HandlerFunc(app.adminSetRole))))
+	router.Handle("POST /admin/user/disable", app.authenticate(app.requireAdmin(http.HandlerFunc(app.adminDisableUser))))
+	router.Handle("POST /admin/user/enable", app.authenticate(app.requireAdmin(http.HandlerFunc(app.adminEnableUser))))
+	router.Handle("POST /admin/user/resetPassword", app.authenticate(app.requireAdmin(http.HandlerFunc(app.adminForcePasswordReset))))
+	router.Handle("POST /admin/user/quota", app.authenticate(app.requireAdmin(http.HandlerFunc(app.adminSetQuota))))
+	router.Handle("POST /admin/user/delete", app.authenticate(app.requireAdmin(http.HandlerFunc(app.adminDeleteUser))))
+	router.Handle("POST /admin/user/2fa/reset", app.authenticate(app.requireAdmin(http.HandlerFunc(app.adminResetTwoFactor))))
+
+	router.Handle("GET /session/list", app.authenticate(http.HandlerFunc(app.listSessions)))
+	router.Handle("POST /session/list", app.authenticate(http.HandlerFunc(app.listSessions)))
+	router.Handle("POST /session/revoke", app.authenticate(http.HandlerFunc(app.revokeSession)))
+	router.Handle("POST /session/revokeAll", app.authenticate(http.HandlerFunc(app.revokeAllSessions)))
+	router.Handle("POST /admin/session/list", app.authenticate(app.requireAdmin(http.HandlerFunc(app.adminListSessions))))
+	router.Handle("POST /admin/session/revoke", app.authenticate(app.requireAdmin(http.HandlerFunc(app.adminRevokeSessions))))
 
-	router.Handle("POST /file/upload", app.authenticate(http.HandlerFunc(app.uploadFile)))
-	router.Handle("POST /file/share/add", app.authenticate(http.HandlerFunc(app.shareFile)))
-	router.Handle("POST /file/share/get", app.authenticate(http.HandlerFunc(app.getShareFile)))
-	router.Handle("POST /file/download", app.authenticate(http.HandlerFunc(app.fileDownload)))
-	router.Handle("POST /file/delete", app.authenticate(http.HandlerFunc(app.deleteFile)))
-	router.Handle("POST /file/list", app.authenticate(http.HandlerFunc(app.getFileList)))
-	router.Handle("POST /file/tags", app.authenticate(http.HandlerFunc(app.getTags)))
+	router.Handle("POST /apikey/create", app.authenticate(http.HandlerFunc(app.createAPIKey)))
+	router.Handle("GET /apikey/list", app.authenticate(http.HandlerFunc(app.listAPIKeys)))
+	router.Handle("POST /apikey/revoke", app.authenticate(http.HandlerFunc(app.revokeAPIKey)))
+
+	router.Handle("POST /file/upload", app.authenticate(scoped(auth.ScopeFilesUpload, http.HandlerFunc(app.uploadFile))))
+	router.Handle("POST /file/upload/stream", app.authenticateHeader(scoped(auth.ScopeFilesUpload, http.HandlerFunc(app.uploadFileStream))))
+	router.Handle("POST /file/upload/link", app.authenticate(scoped(auth.ScopeFilesUpload, http.HandlerFunc(app.linkUpload))))
+	router.Handle("POST /file/share/add", app.authenticate(scoped(auth.ScopeShares, http.HandlerFunc(app.shareFile))))
+	router.Handle("GET /file/share/get", app.authenticate(scoped(auth.ScopeShares, http.HandlerFunc(app.getShareFile))))
+	router.Handle("POST /file/share/get", app.authenticate(scoped(auth.ScopeShares, http.HandlerFunc(app.getShareFile))))
+	router.Handle("POST /file/download", app.authenticate(scoped(auth.ScopeFilesRead, http.HandlerFunc(app.fileDownload))))
+	router.Handle("GET /file/{id}", app.authenticate(scoped(auth.ScopeFilesRead, http.HandlerFunc(app.serveFile))))
+	router.Handle("GET /file/{id}/thumb/{size}", app.authenticate(scoped(auth.ScopeFilesRead, http.HandlerFunc(app.serveThumbnail))))
+	router.Handle("POST /file/delete", app.authenticate(scoped(auth.ScopeFilesDelete, http.HandlerFunc(app.deleteFile))))
+	router.Handle("GET /file/list", app.authenticate(scoped(auth.ScopeFilesRead, http.HandlerFunc(app.getFileList))))
+	router.Handle("POST /file/list", app.authenticate(scoped(auth.ScopeFilesRead, http.HandlerFunc(app.getFileList))))
+	router.Handle("GET /file/search", app.authenticate(scoped(auth.ScopeFilesRead, http.HandlerFunc(app.searchFiles))))
+	router.Handle("GET /file/geo/search", app.authenticate(scoped(auth.ScopeFilesRead, http.HandlerFunc(app.searchLocation))))
+	router.Handle("GET /file/geo/export", app.authenticate(scoped(auth.ScopeFilesRead, http.HandlerFunc(app.exportLocations))))
+	router.Handle("GET /file/usage", app.authenticate(scoped(auth.ScopeFilesRead, http.HandlerFunc(app.getStorageUsage))))
+	router.Handle("GET /file/tags", app.authenticate(scoped(auth.ScopeFilesRead, http.HandlerFunc(app.getTags))))
+	router.Handle("POST /file/tags", app.authenticate(scoped(auth.ScopeFilesRead, http.HandlerFunc(app.getTags))))
+	router.Handle("POST /file/tags/add", app.authenticate(scoped(auth.ScopeTags, http.HandlerFunc(app.addFileTags))))
+	router.Handle("POST /file/tags/remove", app.authenticate(scoped(auth.ScopeTags, http.HandlerFunc(app.removeFileTags))))
+	router.Handle("GET /tag/files", app.authenticate(scoped(auth.ScopeFilesRead, http.HandlerFunc(app.getTagFiles))))
+	router.Handle("GET /tag/search", app.authenticate(scoped(auth.ScopeFilesRead, http.HandlerFunc(app.searchTags))))
+	router.Handle("POST /tag/rename", app.authenticate(scoped(auth.ScopeTags, http.HandlerFunc(app.renameTag))))
+	router.Handle("POST /tag/merge", app.authenticate(scoped(auth.ScopeTags, http.HandlerFunc(app.mergeTags))))
+	router.Handle("POST /tag/delete", app.authenticate(scoped(auth.ScopeTags, http.HandlerFunc(app.deleteTag))))
+	router.Handle("POST /tag/prune", app.authenticate(scoped(auth.ScopeTags, http.HandlerFunc(app.pruneTags))))
 	router.Handle("GET /shared/{id}/{pass}", http.HandlerFunc(app.downloadSharedFile))
 
-	router.Handle("POST /album/add", app.authenticate(http.HandlerFunc(app.addAlbum)))
-	router.Handle("POST /album/list", app.authenticate(http.HandlerFunc(app.getAlbums)))
-	router.Handle("POST /album/addFile", app.authenticate(http.HandlerFunc(app.addFileToAlbum)))
-	router.Handle("POST /album/getFile", app.authenticate(http.HandlerFunc(app.getFileFromAlbum)))
-	router.Handle("POST /album/addFileByTag", app.authenticate(http.HandlerFunc(app.addFileToAlbumByTag)))
+	router.Handle("POST /upload", app.authenticateHeader(scoped(auth.ScopeFilesUpload, http.HandlerFunc(app.createUpload))))
+	router.Handle("HEAD /upload/{id}", app.authenticateHeader(scoped(auth.ScopeFilesUpload, http.HandlerFunc(app.getUploadOffset))))
+	router.Handle("PATCH /upload/{id}", app.authenticateHeader(scoped(auth.ScopeFilesUpload, http.HandlerFunc(app.patchUpload))))
+	router.Handle("DELETE /upload/{id}", app.authenticateHeader(scoped(auth.ScopeFilesUpload, http.HandlerFunc(app.deleteUpload))))
+
+	router.Handle("POST /album/add", app.authenticate(scoped(auth.ScopeAlbums, http.HandlerFunc(app.addAlbum))))
+	router.Handle("GET /album/list", app.authenticate(scoped(auth.ScopeAlbums, http.HandlerFunc(app.getAlbums))))
+	router.Handle("POST /album/list", app.authenticate(scoped(auth.ScopeAlbums, http.HandlerFunc(app.getAlbums))))
+	router.Handle("POST /album/addFile", app.authenticate(scoped(auth.ScopeAlbums, http.HandlerFunc(app.addFileToAlbum))))
+	router.Handle("GET /album/{id}/files", app.authenticate(scoped(auth.ScopeAlbums, http.HandlerFunc(app.getFileFromAlbum))))
+	router.Handle("POST /album/getFile", app.authenticate(scoped(auth.ScopeAlbums, http.HandlerFunc(app.getFileFromAlbum))))
+	router.Handle("POST /album/addFileByTag", app.authenticate(scoped(auth.ScopeAlbums, http.HandlerFunc(app.addFileToAlbumByTag))))
 
 	return router
 }
@@ -4,7 +4,11 @@ CREATE TABLE users (
   password TEXT NOT NULL,
   email TEXT,
   profile TEXT,
-  is_admin INTEGER NOT NULL DEFAULT 0
+  is_admin INTEGER NOT NULL DEFAULT 0,
+  active INTEGER NOT NULL DEFAULT 1,         -- 0 until the email address is verified
+  email_verified INTEGER NOT NULL DEFAULT 0,
+  quota INTEGER,                            -- Storage limit in bytes, NULL for the server default, 0 for none
+  disabled INTEGER NOT NULL DEFAULT 0       -- 1 while an admin keeps the account disabled
 );
 
 CREATE TABLE files (
@@ -16,9 +20,90 @@ CREATE TABLE files (
   coordinates TEXT,
   checksum TEXT NOT NULL, -- SHA-256 checksum of the file
   created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  size INTEGER NOT NULL DEFAULT 0, -- Size in bytes, -1 until measured after an upgrade
+  latitude REAL,                   -- Parsed from coordinates, NULL when there are none
+  longitude REAL,
   FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE
 );
 
+-- Full-text index over the searchable columns of files
+CREATE VIRTUAL TABLE fileSearch USING fts5(
+  title, description, file_name,
+  content = 'files', content_rowid = 'id',
+  tokenize = 'unicode61 remove_diacritics 2',
+  prefix = '2 3'
+);
+
+-- Keep fileSearch in sync with files, it only stores the index
+CREATE TRIGGER files_search_insert AFTER INSERT ON files
+BEGIN
+  INSERT INTO fileSearch (rowid, title, description, file_name)
+  VALUES (NEW.id, NEW.title, NEW.description, NEW.file_name);
+END;
+
+CREATE TRIGGER files_search_delete AFTER DELETE ON files
+BEGIN
+  INSERT INTO fileSearch (fileSearch, rowid, title, description, file_name)
+  VALUES ('delete', OLD.id, OLD.title, OLD.description, OLD.file_name);
+END;
+
+CREATE TRIGGER files_search_update AFTER UPDATE OF title, description, file_name ON files
+BEGIN
+  INSERT INTO fileSearch (fileSearch, rowid, title, description, file_name)
+  VALUES ('delete', OLD.id, OLD.title, OLD.description, OLD.file_name);
+  INSERT INTO fileSearch (rowid, title, description, file_name)
+  VALUES (NEW.id, NEW.title, NEW.description, NEW.file_name);
+END;
+
+-- Spatial index over the located files, a point is a box of size 0
+CREATE VIRTUAL TABLE fileLocations USING rtree(
+  id,
+  min_lat, max_lat,
+  min_lon, max_lon
+);
+
+CREATE TRIGGER files_location_insert AFTER INSERT ON files
+WHEN NEW.latitude IS NOT NULL AND NEW.longitude IS NOT NULL
+BEGIN
+  INSERT INTO fileLocations (id, min_lat, max_lat, min_lon, max_lon)
+  VALUES (NEW.id, NEW.latitude, NEW.latitude, NEW.longitude, NEW.longitude);
+END;
+
+CREATE TRIGGER files_location_update AFTER UPDATE OF latitude, longitude ON files
+BEGIN
+  DELETE FROM fileLocations WHERE id = OLD.id;
+  INSERT INTO fileLocations (id, min_lat, max_lat, min_lon, max_lon)
+  SELECT NEW.id, NEW.latitude, NEW.latitude, NEW.longitude, NEW.longitude
+  WHERE NEW.latitude IS NOT NULL AND NEW.longitude IS NOT NULL;
+END;
+
+CREATE TRIGGER files_location_delete AFTER DELETE ON files
+BEGIN
+  DELETE FROM fileLocations WHERE id = OLD.id;
+END;
+
+-- Camera metadata read from EXIF and XMP on upload, only for files that had any
+CREATE TABLE fileMetadata (
+  file_id INTEGER PRIMARY KEY NOT NULL,
+  taken_at DATETIME,                -- UTC when the camera recorded its offset, else its clock
+  camera_make TEXT,
+  camera_model TEXT,
+  lens TEXT,
+  exposure_time REAL,               -- Seconds
+  f_number REAL,
+  iso INTEGER,
+  focal_length REAL,                -- Millimeters
+  orientation INTEGER,              -- EXIF orientation, 1 to 8
+  latitude REAL,                    -- GPS position, copied to files linked to the same content
+  longitude REAL,
+  FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE CASCADE
+);
+
+CREATE TRIGGER files_metadata_delete AFTER DELETE ON files
+BEGIN
+  DELETE FROM fileMetadata WHERE file_id = OLD.id;
+END;
+
 CREATE TABLE fileGuestShares (
   id INTEGER PRIMARY KEY AUTOINCREMENT,
   file_id INTEGER NOT NULL,
@@ -31,7 +116,10 @@ CREATE TABLE fileGuestShares (
 
 CREATE TABLE tags (
   id INTEGER PRIMARY KEY AUTOINCREMENT,
-  name TEXT NOT NULL UNIQUE
+  owner_id INTEGER NOT NULL DEFAULT 0,  -- 0 for shared tags every user can see
+  parent_id INTEGER NOT NULL DEFAULT 0, -- 0 for top level tags
+  name TEXT NOT NULL,                   -- Last segment of the path, without slashes
+  UNIQUE (owner_id, parent_id, name)
 );
 
 CREATE TABLE fileTags (
@@ -59,6 +147,108 @@ CREATE TABLE album (
   FOREIGN KEY (cover_id) REFERENCES files(id) ON DELETE CASCADE -- ID of the cover file
 );
 
+-- Foreign keys are not enforced, remove what refers to a deleted file
+CREATE TRIGGER files_links_delete AFTER DELETE ON files
+BEGIN
+  DELETE FROM fileGuestShares WHERE file_id = OLD.id;
+  DELETE FROM fileTags WHERE file_id = OLD.id;
+  DELETE FROM fileAlbum WHERE file_id = OLD.id;
+  UPDATE album SET cover_id = NULL WHERE cover_id = OLD.id;
+END;
+
+CREATE TABLE uploads (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  owner_id INTEGER NOT NULL,
+  file_name TEXT NOT NULL,
+  title TEXT,
+  description TEXT,
+  coordinates TEXT,
+  tags TEXT,                        -- JSON array of tag names applied on completion
+  checksum TEXT,                    -- Optional SHA-256 declared by the client
+  length INTEGER NOT NULL,          -- Total size in bytes
+  upload_offset INTEGER NOT NULL DEFAULT 0,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE blobs (
+  checksum TEXT PRIMARY KEY NOT NULL, -- SHA-256 of the content, names the stored blob
+  refs INTEGER NOT NULL DEFAULT 0     -- Number of files using this blob
+);
+
+-- Keep blobs.refs in sync with files, including cascading deletes
+CREATE TRIGGER files_blob_ref AFTER INSERT ON files
+BEGIN
+  INSERT INTO blobs (checksum, refs) VALUES (NEW.checksum, 1)
+  ON CONFLICT (checksum) DO UPDATE SET refs = refs + 1;
+END;
+
+CREATE TRIGGER files_blob_unref AFTER DELETE ON files
+BEGIN
+  UPDATE blobs SET refs = refs - 1 WHERE checksum = OLD.checksum;
+END;
+
+CREATE TABLE apiKeys (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL,
+  name TEXT NOT NULL,
+  key_hash TEXT NOT NULL UNIQUE,    -- SHA-256 of the key, the key itself is only shown once
+  scopes TEXT NOT NULL,             -- Space separated list of granted scopes
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  expires_at DATETIME,              -- Optional expiration
+  last_used_at DATETIME,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE totp (
+  user_id INTEGER PRIMARY KEY NOT NULL,
+  secret TEXT NOT NULL,             -- Base32 encoded shared secret
+  confirmed INTEGER NOT NULL DEFAULT 0, -- Set once the user proved the app works
+  last_step INTEGER NOT NULL DEFAULT 0, -- Last accepted time step, codes cannot be reused
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE recoveryCodes (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL,
+  code_hash TEXT NOT NULL,          -- SHA-256 of the code
+  used_at DATETIME,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE passwordResets (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL,
+  token_hash TEXT NOT NULL UNIQUE,  -- SHA-256 of the token sent by mail
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  expires_at DATETIME NOT NULL,
+  used_at DATETIME,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE emailVerifications (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  user_id INTEGER NOT NULL,
+  token_hash TEXT NOT NULL UNIQUE,  -- SHA-256 of the token sent by mail
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  expires_at DATETIME NOT NULL,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE invites (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  code_hash TEXT NOT NULL UNIQUE,   -- SHA-256 of the code, the code itself is only shown once
+  created_by INTEGER,
+  note TEXT,
+  max_uses INTEGER NOT NULL DEFAULT 1,
+  uses INTEGER NOT NULL DEFAULT 0,
+  created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+  expires_at DATETIME,              -- Optional expiration
+  FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
 INSERT INTO users (login, password) VALUES
 ('Tako', '$2a$12$owvRo/QyIoq1n4rfXx2D/uLA8i5cSpFNrjHY6KWx5ijU/oXe2c.1G'), -- password: Tako1234
 ('aa', '$2a$12$YRpJ.CFCxfv6i/3RMzzdTOl3T/EeYEL5nHKqVDcXTHFoQs3qdE9xG');   -- password: aa
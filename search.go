@@ -0,0 +1,267 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"server/database"
+	"server/tagquery"
+)
+
+// Search results are returned in pages of searchLimit, clients may ask for
+// up to maxSearchLimit.
+const (
+	searchLimit    = 50
+	maxSearchLimit = 200
+)
+
+var errEmptySearch = errors.New("empty search")
+
+// tagCondition compiles a tag query like "(cat OR dog) -blurry" into a
+// condition on files.id. Tags are resolved like in resolveTagPath, unknown
+// tags match no file. With descendants set a tag also matches the tags below
+// it.
+func (app *app) tagCondition(ownerID int64, query string, descendants bool) (string, []any, error) {
+	expr, err := tagquery.Parse(query)
+	if err != nil {
+		return "", nil, err
+	}
+
+	ids := map[string][]int64{}
+	for _, path := range tagquery.Paths(expr) {
+		tag, err := app.resolveTagPath(app.Query, ownerID, path, false)
+		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errEmptyTagPath) {
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+
+		ids[path] = []int64{tag.ID}
+		if descendants {
+			if ids[path], err = app.Query.GetTagDescendants(app.Ctx, tag.ID); err != nil {
+				return "", nil, err
+			}
+		}
+	}
+
+	cond, args := tagquery.SQL(expr, ids)
+	return cond, args, nil
+}
+
+// filesByTagQuery returns the files of the user matching a tag query.
+func (app *app) filesByTagQuery(ownerID int64, query string, descendants bool) ([]database.File, error) {
+	cond, args, err := app.tagCondition(ownerID, query, descendants)
+	if err != nil {
+		return nil, err
+	}
+
+	return app.Query.GetFilesByCondition(app.Ctx, ownerID, cond, args...)
+}
+
+// matchQuery turns the words typed by a user into an FTS5 query. Every word
+// is quoted, so nothing is read as FTS5 syntax, and matched as a prefix.
+func matchQuery(search string) (string, error) {
+	words := strings.FieldsFunc(search, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	if len(words) == 0 {
+		return "", errEmptySearch
+	}
+
+	for i, word := range words {
+		words[i] = `"` + word + `"*`
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+// timeParam reads an optional date (2006-01-02) or RFC 3339 time. With
+// endOfDay set a date stands for the end of that day, so it can be used as
+// an exclusive upper bound that still includes the day.
+func timeParam(w http.ResponseWriter, r *http.Request, name string, endOfDay bool) (time.Time, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, true
+	}
+
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, true
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		sendError(w, Error{400, "Invalid " + name + " time, use 2006-01-02 or RFC 3339", "Bad Request"}, err)
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// intParam reads an optional non-negative integer.
+func intParam(w http.ResponseWriter, r *http.Request, name string, fallback int64) (int64, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, true
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		sendError(w, Error{400, "Invalid " + name, "Bad Request"}, err)
+		return 0, false
+	}
+
+	return n, true
+}
+
+// boolParam reads an optional true/false query parameter, answering with 400
+// if it is not one.
+func boolParam(w http.ResponseWriter, r *http.Request, name string) (bool, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false, true
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		sendError(w, Error{400, "Invalid " + name + " flag", "Bad Request"}, err)
+		return false, false
+	}
+
+	return b, true
+}
+
+// sendTagQueryError answers queries tagquery.Parse rejected and reports
+// whether err was such a rejection.
+func sendTagQueryError(w http.ResponseWriter, err error) bool {
+	var syntaxErr *tagquery.SyntaxError
+	switch {
+	case errors.As(err, &syntaxErr):
+		sendError(w, Error{400, syntaxErr.Error(), "Bad Request"}, err)
+	case errors.Is(err, tagquery.ErrEmpty):
+		sendError(w, Error{400, "Missing tag query", "Bad Request"}, err)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// searchTags lists the files of the user matching the tag query in q, e.g.
+// GET /tag/search?q=beach+AND+2024+AND+NOT+work
+func (app *app) searchTags(w http.ResponseWriter, r *http.Request) {
+	id := r.Context().Value("id").(int64)
+
+	descendants, ok := boolParam(w, r, "descendants")
+	if !ok {
+		return
+	}
+
+	files, err := app.filesByTagQuery(id, r.URL.Query().Get("q"), descendants)
+	if err != nil {
+		if sendTagQueryError(w, err) {
+			return
+		}
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	withMetadata, err := app.withMetadata(files)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	output := struct {
+		Files []fileWithMetadata `json:"files"`
+	}{
+		Files: withMetadata,
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// searchFiles is the full-text search over the titles, descriptions and file
+// names of the files of the user, e.g.
+// GET /file/search?q=krak&tags=places/poland&descendants=true&from=2024-01-01
+// Words match as prefixes and all of them have to match. tags is a tag query
+// like for searchTags, from and to limit when the files were added.
+func (app *app) searchFiles(w http.ResponseWriter, r *http.Request) {
+	id := r.Context().Value("id").(int64)
+
+	match, err := matchQuery(r.URL.Query().Get("q"))
+	if err != nil {
+		sendError(w, Error{400, "Missing search terms", "Bad Request"}, err)
+		return
+	}
+
+	params := database.SearchFilesParams{OwnerID: id, Match: match}
+
+	var ok bool
+	if params.From, ok = timeParam(w, r, "from", false); !ok {
+		return
+	}
+	if params.To, ok = timeParam(w, r, "to", true); !ok {
+		return
+	}
+	if params.Limit, ok = intParam(w, r, "limit", searchLimit); !ok {
+		return
+	}
+	if params.Offset, ok = intParam(w, r, "offset", 0); !ok {
+		return
+	}
+	params.Limit = min(max(params.Limit, 1), maxSearchLimit)
+
+	descendants, ok := boolParam(w, r, "descendants")
+	if !ok {
+		return
+	}
+
+	if tags := r.URL.Query().Get("tags"); tags != "" {
+		params.Cond, params.Args, err = app.tagCondition(id, tags, descendants)
+		if err != nil {
+			if sendTagQueryError(w, err) {
+				return
+			}
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+	}
+
+	results, err := app.Query.SearchFiles(app.Ctx, params)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	output := struct {
+		Results []database.SearchFilesRow `json:"results"`
+	}{
+		Results: results,
+	}
+
+	if output.Results == nil {
+		output.Results = []database.SearchFilesRow{}
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"server/auth"
+	"server/database"
+)
+
+// sweepSessions periodically removes expired sessions from the cache.
+func (app *app) sweepSessions(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		removed, err := auth.SweepSessions(app.CACHE)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		if removed > 0 {
+			log.Printf("Cleanup: -- Removed %d expired sessions", removed)
+		}
+	}
+}
+
+func (app *app) listSessions(w http.ResponseWriter, r *http.Request) {
+	id := r.Context().Value("id").(int64)
+	token := r.Context().Value("token").(string)
+
+	sessions, err := auth.ListSessions(app.CACHE, id, token)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	output := struct {
+		Sessions []database.Session `json:"sessions"`
+	}{
+		Sessions: sessions,
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (app *app) revokeSession(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		SessionID int64 `json:"session_id"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	id := r.Context().Value("id").(int64)
+
+	deleted, err := database.DeleteTokenByID(app.CACHE, input.SessionID, id)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if !deleted {
+		sendError(w, Error{404, "Session not found", "Not Found"}, nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// revokeAllSessions logs the user out everywhere. With keep_current set the
+// session making the request stays valid. The body is optional.
+func (app *app) revokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		KeepCurrent bool `json:"keep_current"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil && !errors.Is(err, io.EOF) {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	id := r.Context().Value("id").(int64)
+
+	keep := ""
+	if input.KeepCurrent {
+		keep = r.Context().Value("token").(string)
+	}
+
+	removed, err := database.DeleteUserTokens(app.CACHE, id, keep)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	log.Printf("Logout: -- Removed %d sessions of user %d", removed, id)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (app *app) adminListSessions(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		UserID int64 `json:"user_id"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	sessions, err := auth.ListSessions(app.CACHE, input.UserID, r.Context().Value("token").(string))
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	output := struct {
+		Sessions []database.Session `json:"sessions"`
+	}{
+		Sessions: sessions,
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// adminRevokeSessions revokes one session of any user, or all of them when
+// session_id is omitted. Without a user_id it revokes the admin's own.
+func (app *app) adminRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		UserID    int64 `json:"user_id"`
+		SessionID int64 `json:"session_id"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil && !errors.Is(err, io.EOF) {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	if input.UserID == 0 {
+		input.UserID = r.Context().Value("id").(int64)
+	}
+
+	if input.SessionID == 0 {
+		removed, err := database.DeleteUserTokens(app.CACHE, input.UserID, "")
+		if err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+
+		log.Printf("Admin: -- Removed %d sessions of user %d", removed, input.UserID)
+
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	deleted, err := database.DeleteTokenByID(app.CACHE, input.SessionID, input.UserID)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if !deleted {
+		sendError(w, Error{404, "Session not found", "Not Found"}, nil)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
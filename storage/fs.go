@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Blobs are written to a temporary file first, List skips those.
+const tempPrefix = ".put-"
+
+// FS stores blobs as regular files below a root directory.
+type FS struct {
+	root string
+}
+
+func NewFS(root string) (*FS, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	return &FS{root: root}, nil
+}
+
+func (s *FS) path(key string) (string, error) {
+	key = filepath.FromSlash(key)
+	if !filepath.IsLocal(key) {
+		return "", fmt.Errorf("invalid blob key: %q", key)
+	}
+
+	return filepath.Join(s.root, key), nil
+}
+
+func (s *FS) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if written != size {
+		tmp.Close()
+		return fmt.Errorf("short write for %q: %d of %d bytes", key, written, size)
+	}
+
+	// CreateTemp uses 0600, keep the permissions of the old upload code
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *FS) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotExist
+	}
+
+	return f, err
+}
+
+func (s *FS) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ErrNotExist
+	}
+
+	return err
+}
+
+func (s *FS) Stat(ctx context.Context, key string) (Info, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+
+	stat, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Info{}, ErrNotExist
+	}
+	if err != nil {
+		return Info{}, err
+	}
+
+	return Info{Key: key, Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+func (s *FS) List(ctx context.Context, prefix string) ([]Info, error) {
+	var blobs []Info
+
+	err := filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || strings.HasPrefix(d.Name(), tempPrefix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		stat, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		blobs = append(blobs, Info{Key: key, Size: stat.Size(), ModTime: stat.ModTime()})
+		return nil
+	})
+
+	return blobs, err
+}
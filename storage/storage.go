@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrNotExist = errors.New("blob does not exist")
+
+type Info struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Blob is a flat key/value store for file contents. Keys are slash
+// separated paths such as "blobs/xx/<checksum>" for contents and
+// "thumbs/xx/<checksum>/<size>.jpg" for thumbnails, xx being the first two
+// characters of the checksum.
+type Blob interface {
+	// Put stores size bytes read from r under key, replacing any previous
+	// content. The blob only becomes visible once it has been fully written.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get opens the blob for reading. The returned reader supports seeking
+	// so callers can serve byte ranges without reading the whole blob.
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Delete(ctx context.Context, key string) error
+	Stat(ctx context.Context, key string) (Info, error)
+	// List returns every blob whose key starts with prefix.
+	List(ctx context.Context, prefix string) ([]Info, error)
+}
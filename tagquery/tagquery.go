@@ -0,0 +1,355 @@
+// Package tagquery parses boolean tag expressions such as
+// "beach AND 2024 AND NOT work" or "(cat OR dog) -blurry" and turns them into
+// an SQL condition on files.id. Tag names never end up in the SQL, only the
+// ids they resolve to are passed as arguments.
+package tagquery
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Limits keeping the generated SQL small.
+const (
+	MaxTerms = 32
+	MaxDepth = 16
+)
+
+var ErrEmpty = errors.New("empty tag query")
+
+// SyntaxError reports where a query could not be parsed. Pos counts bytes
+// from the start of the query.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("tag query: %s at position %d", e.Msg, e.Pos)
+}
+
+// Expr is a parsed query, one of Tag, Not, And and Or.
+type Expr interface {
+	expr()
+}
+
+// Tag matches files with the tag at Path, a slash-separated tag path.
+type Tag struct {
+	Path string
+}
+
+type Not struct {
+	X Expr
+}
+
+type And struct {
+	X []Expr
+}
+
+type Or struct {
+	X []Expr
+}
+
+func (Tag) expr() {}
+func (Not) expr() {}
+func (And) expr() {}
+func (Or) expr()  {}
+
+// Parse reads a query. Terms are tag paths, quoted with " when they contain
+// spaces, parentheses or would be read as an operator. NOT binds tighter than
+// AND, which binds tighter than OR. Operators are not case sensitive, terms
+// next to each other are joined with AND and -term is short for NOT term.
+func Parse(query string) (Expr, error) {
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(tokens) == 1 {
+		return nil, ErrEmpty
+	}
+
+	p := &parser{tokens: tokens}
+
+	expr, err := p.or(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokEnd {
+		return nil, &SyntaxError{t.pos, fmt.Sprintf("unexpected %s", t)}
+	}
+
+	return expr, nil
+}
+
+// Paths returns every tag path used in the query, each once.
+func Paths(expr Expr) []string {
+	var paths []string
+	seen := map[string]bool{}
+
+	var walk func(Expr)
+	walk = func(expr Expr) {
+		switch e := expr.(type) {
+		case Tag:
+			if !seen[e.Path] {
+				seen[e.Path] = true
+				paths = append(paths, e.Path)
+			}
+		case Not:
+			walk(e.X)
+		case And:
+			for _, x := range e.X {
+				walk(x)
+			}
+		case Or:
+			for _, x := range e.X {
+				walk(x)
+			}
+		}
+	}
+	walk(expr)
+
+	return paths
+}
+
+// SQL compiles the query into a condition on files.id and its arguments.
+// ids holds the tag ids every path stands for, a path without ids matches no
+// file.
+func SQL(expr Expr, ids map[string][]int64) (string, []any) {
+	var b strings.Builder
+	var args []any
+
+	var write func(Expr)
+	write = func(expr Expr) {
+		switch e := expr.(type) {
+		case Tag:
+			tagIDs := ids[e.Path]
+			if len(tagIDs) == 0 {
+				b.WriteString("0")
+				return
+			}
+
+			b.WriteString("files.id IN (SELECT file_id FROM fileTags WHERE tag_id IN (")
+			for i, id := range tagIDs {
+				if i > 0 {
+					b.WriteString(", ")
+				}
+				b.WriteString("?")
+				args = append(args, id)
+			}
+			b.WriteString("))")
+		case Not:
+			b.WriteString("NOT ")
+			write(e.X)
+		case And:
+			join(&b, e.X, " AND ", write)
+		case Or:
+			join(&b, e.X, " OR ", write)
+		}
+	}
+	write(expr)
+
+	return b.String(), args
+}
+
+func join(b *strings.Builder, exprs []Expr, op string, write func(Expr)) {
+	b.WriteString("(")
+	for i, x := range exprs {
+		if i > 0 {
+			b.WriteString(op)
+		}
+		write(x)
+	}
+	b.WriteString(")")
+}
+
+type tokenKind int
+
+const (
+	tokEnd tokenKind = iota
+	tokTerm
+	tokAnd
+	tokOr
+	tokNot
+	tokOpen
+	tokClose
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEnd:
+		return "end of query"
+	case tokTerm:
+		return fmt.Sprintf("%q", t.text)
+	}
+	return t.text
+}
+
+func lex(query string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokOpen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokClose, ")", i})
+			i++
+		case c == '-':
+			tokens = append(tokens, token{tokNot, "-", i})
+			i++
+		case c == '"':
+			end := strings.IndexByte(query[i+1:], '"')
+			if end < 0 {
+				return nil, &SyntaxError{i, "unterminated quote"}
+			}
+			tokens = append(tokens, token{tokTerm, query[i+1 : i+1+end], i})
+			i += end + 2
+		default:
+			start := i
+			for i < len(query) && !strings.ContainsRune(" \t\n\r()\"", rune(query[i])) {
+				i++
+			}
+
+			word := query[start:i]
+			switch strings.ToUpper(word) {
+			case "AND":
+				tokens = append(tokens, token{tokAnd, "AND", start})
+			case "OR":
+				tokens = append(tokens, token{tokOr, "OR", start})
+			case "NOT":
+				tokens = append(tokens, token{tokNot, "NOT", start})
+			default:
+				tokens = append(tokens, token{tokTerm, word, start})
+			}
+		}
+	}
+
+	return append(tokens, token{tokEnd, "", len(query)}), nil
+}
+
+type parser struct {
+	tokens []token
+	next   int
+	terms  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) take() token {
+	t := p.tokens[p.next]
+	if t.kind != tokEnd {
+		p.next++
+	}
+	return t
+}
+
+func (p *parser) or(depth int) (Expr, error) {
+	x, err := p.and(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	exprs := []Expr{x}
+	for p.peek().kind == tokOr {
+		p.take()
+
+		x, err := p.and(depth)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, x)
+	}
+
+	if len(exprs) == 1 {
+		return exprs[0], nil
+	}
+	return Or{exprs}, nil
+}
+
+func (p *parser) and(depth int) (Expr, error) {
+	x, err := p.unary(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	exprs := []Expr{x}
+	for {
+		switch p.peek().kind {
+		case tokAnd:
+			p.take()
+		case tokTerm, tokNot, tokOpen:
+			// Terms next to each other are joined with AND
+		default:
+			if len(exprs) == 1 {
+				return exprs[0], nil
+			}
+			return And{exprs}, nil
+		}
+
+		x, err := p.unary(depth)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, x)
+	}
+}
+
+func (p *parser) unary(depth int) (Expr, error) {
+	t := p.take()
+
+	switch t.kind {
+	case tokNot:
+		if depth >= MaxDepth {
+			return nil, &SyntaxError{t.pos, "too deeply nested"}
+		}
+
+		x, err := p.unary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return Not{x}, nil
+	case tokOpen:
+		if depth >= MaxDepth {
+			return nil, &SyntaxError{t.pos, "too deeply nested"}
+		}
+
+		x, err := p.or(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		if c := p.take(); c.kind != tokClose {
+			return nil, &SyntaxError{c.pos, fmt.Sprintf("expected ) instead of %s", c)}
+		}
+		return x, nil
+	case tokTerm:
+		path := strings.TrimFunc(t.text, unicode.IsSpace)
+		if path == "" {
+			return nil, &SyntaxError{t.pos, "empty tag"}
+		}
+
+		p.terms++
+		if p.terms > MaxTerms {
+			return nil, &SyntaxError{t.pos, fmt.Sprintf("more than %d tags", MaxTerms)}
+		}
+		return Tag{path}, nil
+	}
+
+	return nil, &SyntaxError{t.pos, fmt.Sprintf("expected a tag instead of %s", t)}
+}
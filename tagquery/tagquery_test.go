@@ -0,0 +1,191 @@
+package tagquery
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	a, b, c := Tag{"a"}, Tag{"b"}, Tag{"c"}
+
+	tests := []struct {
+		query string
+		want  Expr
+	}{
+		{"a", a},
+		{"places/poland", Tag{"places/poland"}},
+
+		// NOT binds tighter than AND, AND tighter than OR
+		{"a OR b AND c", Or{[]Expr{a, And{[]Expr{b, c}}}}},
+		{"a AND b OR c", Or{[]Expr{And{[]Expr{a, b}}, c}}},
+		{"NOT a AND b", And{[]Expr{Not{a}, b}}},
+		{"NOT a OR b", Or{[]Expr{Not{a}, b}}},
+		{"a OR b OR c", Or{[]Expr{a, b, c}}},
+		{"(a OR b) AND c", And{[]Expr{Or{[]Expr{a, b}}, c}}},
+		{"NOT (a OR b)", Not{Or{[]Expr{a, b}}}},
+		{"a and b or not c", Or{[]Expr{And{[]Expr{a, b}}, Not{c}}}},
+
+		// Terms next to each other are joined with AND
+		{"a b", And{[]Expr{a, b}}},
+		{"a b OR c", Or{[]Expr{And{[]Expr{a, b}}, c}}},
+		{"a (b OR c)", And{[]Expr{a, Or{[]Expr{b, c}}}}},
+
+		// -term is short for NOT term
+		{"-a", Not{a}},
+		{"a -b", And{[]Expr{a, Not{b}}}},
+		{"(a OR b) -c", And{[]Expr{Or{[]Expr{a, b}}, Not{c}}}},
+		{"--a", Not{Not{a}}},
+		{"a-b", Tag{"a-b"}},
+
+		// Quoted terms may hold anything but quotes
+		{`"new york"`, Tag{"new york"}},
+		{`"new york" OR paris`, Or{[]Expr{Tag{"new york"}, Tag{"paris"}}}},
+		{`"and"`, Tag{"and"}},
+		{`"(a)"`, Tag{"(a)"}},
+		{`" a "`, a},
+		{`a"b"`, And{[]Expr{a, b}}},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.query)
+		if err != nil {
+			t.Errorf("Parse(%q) error: %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %#v, want %#v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		query string
+		pos   int
+	}{
+		{`"abc`, 0},
+		{`a "b`, 2},
+		{`""`, 0},
+		{"a AND", 5},
+		{"a OR OR b", 5},
+		{"OR a", 0},
+		{"NOT", 3},
+		{"(a", 2},
+		{"()", 1},
+		{"a)", 1},
+		{"a (b) )", 6},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.query)
+
+		var syntax *SyntaxError
+		if !errors.As(err, &syntax) {
+			t.Errorf("Parse(%q) error = %v, want a SyntaxError", tt.query, err)
+			continue
+		}
+		if syntax.Pos != tt.pos {
+			t.Errorf("Parse(%q) error at %d, want %d: %v", tt.query, syntax.Pos, tt.pos, err)
+		}
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	for _, query := range []string{"", " ", "\t\n"} {
+		if _, err := Parse(query); !errors.Is(err, ErrEmpty) {
+			t.Errorf("Parse(%q) error = %v, want ErrEmpty", query, err)
+		}
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		pos   int // -1 when the query is within the limits
+	}{
+		{"MaxTerms tags", strings.Repeat("a ", MaxTerms), -1},
+		{"MaxTerms+1 tags", strings.Repeat("a ", MaxTerms+1), 2 * MaxTerms},
+		{"MaxTerms+1 tags with OR", strings.Repeat("a OR ", MaxTerms) + "a", 5 * MaxTerms},
+		{"MaxDepth parentheses", strings.Repeat("(", MaxDepth) + "a" + strings.Repeat(")", MaxDepth), -1},
+		{"MaxDepth+1 parentheses", strings.Repeat("(", MaxDepth+1) + "a" + strings.Repeat(")", MaxDepth+1), MaxDepth},
+		{"MaxDepth NOT", strings.Repeat("-", MaxDepth) + "a", -1},
+		{"MaxDepth+1 NOT", strings.Repeat("-", MaxDepth+1) + "a", MaxDepth},
+		{"MaxDepth+1 mixed", strings.Repeat("-(", MaxDepth/2) + "-a" + strings.Repeat(")", MaxDepth/2), MaxDepth},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.query)
+
+		if tt.pos < 0 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.name, err)
+			}
+			continue
+		}
+
+		var syntax *SyntaxError
+		if !errors.As(err, &syntax) {
+			t.Errorf("%s: error = %v, want a SyntaxError", tt.name, err)
+			continue
+		}
+		if syntax.Pos != tt.pos {
+			t.Errorf("%s: error at %d, want %d: %v", tt.name, syntax.Pos, tt.pos, err)
+		}
+	}
+}
+
+func TestPaths(t *testing.T) {
+	expr, err := Parse("b (a OR -b) c/d a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"b", "a", "c/d"}
+	if got := Paths(expr); !reflect.DeepEqual(got, want) {
+		t.Errorf("Paths = %q, want %q", got, want)
+	}
+}
+
+func TestSQL(t *testing.T) {
+	const in = "files.id IN (SELECT file_id FROM fileTags WHERE tag_id IN ("
+
+	ids := map[string][]int64{
+		"a": {1},
+		"b": {2, 3},
+	}
+
+	tests := []struct {
+		query string
+		want  string
+		args  []any
+	}{
+		{"a", in + "?))", []any{int64(1)}},
+		{"b", in + "?, ?))", []any{int64(2), int64(3)}},
+		{"a -b", "(" + in + "?)) AND NOT " + in + "?, ?)))", []any{int64(1), int64(2), int64(3)}},
+		{"a OR b c", "(" + in + "?)) OR (" + in + "?, ?)) AND 0))", []any{int64(1), int64(2), int64(3)}},
+
+		// Unknown tags match no file
+		{"unknown", "0", nil},
+		{"-unknown", "NOT 0", nil},
+		{"a OR unknown", "(" + in + "?)) OR 0)", []any{int64(1)}},
+	}
+
+	for _, tt := range tests {
+		expr, err := Parse(tt.query)
+		if err != nil {
+			t.Errorf("Parse(%q) error: %v", tt.query, err)
+			continue
+		}
+
+		got, args := SQL(expr, ids)
+		if got != tt.want {
+			t.Errorf("SQL(%q) = %s, want %s", tt.query, got, tt.want)
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("SQL(%q) args = %v, want %v", tt.query, args, tt.args)
+		}
+	}
+}
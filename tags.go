@@ -0,0 +1,578 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"server/database"
+)
+
+// sharedTagOwner is the owner_id of shared tags. They are visible to every
+// user and used when the user has no tag of the same name.
+const sharedTagOwner = 0
+
+// errEmptyTagPath is returned by resolveTagPath for paths without a name.
+var errEmptyTagPath = errors.New("empty tag path")
+
+// splitTagPath splits a path like places/poland/krakow into the names of its
+// levels. Empty levels and surrounding spaces are dropped.
+func splitTagPath(path string) []string {
+	var names []string
+	for _, name := range strings.Split(path, "/") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// resolveTagPath walks path from the top level down. On every level a tag of
+// the owner is preferred over a shared one. With create set, missing levels
+// become tags of the owner, otherwise sql.ErrNoRows is returned.
+func (app *app) resolveTagPath(query *database.Queries, ownerID int64, path string, create bool) (database.Tag, error) {
+	names := splitTagPath(path)
+	if len(names) == 0 {
+		return database.Tag{}, errEmptyTagPath
+	}
+
+	var tag database.Tag
+	for _, name := range names {
+		child, err := query.GetChildTag(app.Ctx, database.GetChildTagParams{ParentID: tag.ID, Name: name, OwnerID: ownerID})
+		if err != nil && (!errors.Is(err, sql.ErrNoRows) || !create) {
+			return database.Tag{}, err
+		}
+
+		if err != nil {
+			child = database.Tag{OwnerID: ownerID, ParentID: tag.ID, Name: name}
+			child.ID, err = query.AddTag(app.Ctx, database.AddTagParams{OwnerID: ownerID, ParentID: tag.ID, Name: name})
+			if err != nil {
+				return database.Tag{}, err
+			}
+		}
+
+		tag = child
+	}
+
+	return tag, nil
+}
+
+// mergeTag moves the files of the tag fromID to intoID and deletes it. Its
+// children move below intoID, or are merged into the child of the same name
+// if intoID has one already.
+func (app *app) mergeTag(query *database.Queries, ownerID, fromID, intoID int64) error {
+	children, err := query.GetChildTags(app.Ctx, fromID)
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		existing, err := query.GetChildTag(app.Ctx, database.GetChildTagParams{ParentID: intoID, Name: child.Name, OwnerID: ownerID})
+		switch {
+		case err == nil:
+			err = app.mergeTag(query, ownerID, child.ID, existing.ID)
+		case errors.Is(err, sql.ErrNoRows):
+			err = query.SetTagParent(app.Ctx, database.SetTagParentParams{ParentID: intoID, ID: child.ID})
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Files that already have the target keep their row on the old tag, it
+	// goes away with the tag
+	if err := query.MoveFileTags(app.Ctx, database.MoveFileTagsParams{IntoID: intoID, FromID: fromID}); err != nil {
+		return err
+	}
+
+	if err := query.DeleteTagFiles(app.Ctx, fromID); err != nil {
+		return err
+	}
+
+	_, err = query.DeleteTag(app.Ctx, database.DeleteTagParams{ID: fromID, OwnerID: ownerID})
+	return err
+}
+
+// nestSlashTags turns tags created before tags had parents, whose names are
+// whole paths, into a tag per level. Their files move to the last level.
+func (app *app) nestSlashTags() error {
+	tags, err := app.Query.GetSlashTags(app.Ctx)
+	if err != nil {
+		return err
+	}
+
+	nested := 0
+	for _, tag := range tags {
+		// Names like "a/" have a single level, they are kept as they are
+		if len(splitTagPath(tag.Name)) < 2 {
+			continue
+		}
+
+		if err := app.nestSlashTag(tag); err != nil {
+			return err
+		}
+		nested++
+	}
+
+	if nested > 0 {
+		log.Printf("Nested %d tags with slashes in their names", nested)
+	}
+
+	return nil
+}
+
+func (app *app) nestSlashTag(tag database.Tag) error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := app.Query.WithTx(tx)
+
+	into, err := app.resolveTagPath(query, tag.OwnerID, tag.Name, true)
+	if err != nil {
+		return err
+	}
+
+	if err := app.mergeTag(query, tag.OwnerID, tag.ID, into.ID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// adminAddSharedTag creates a shared tag, e.g. for a set of categories all
+// users are meant to use. name may be a path, missing levels are created as
+// shared tags too.
+func (app *app) adminAddSharedTag(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		Name string `json:"name"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	if len(splitTagPath(input.Name)) == 0 {
+		sendError(w, Error{400, "Missing tag name", "Bad Request"}, nil)
+		return
+	}
+
+	_, err := app.resolveTagPath(app.Query, sharedTagOwner, input.Name, false)
+	if err == nil {
+		sendError(w, Error{409, "Shared tag already exists", "Conflict"}, nil)
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	output, err := app.resolveTagPath(app.Query, sharedTagOwner, input.Name, true)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	log.Printf("Admin: -- Added shared tag %d %q", output.ID, strings.Join(splitTagPath(input.Name), "/"))
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// checkFileOwner answers with 404 unless the user owns every file.
+func (app *app) checkFileOwner(w http.ResponseWriter, id int64, fileIDs []int64) bool {
+	for _, fileID := range fileIDs {
+		owner, err := app.Query.GetFileOwner(app.Ctx, fileID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return false
+		}
+
+		if err != nil || owner != id {
+			sendError(w, Error{404, fmt.Sprintf("File not found: %d", fileID), "Not Found"}, err)
+			return false
+		}
+	}
+
+	return true
+}
+
+// ownTag loads a tag of the user. Shared tags can be used but not changed,
+// they are answered with 404 like the tags of other users.
+func (app *app) ownTag(w http.ResponseWriter, id, tagID int64) (database.Tag, bool) {
+	tag, err := app.Query.GetTagById(app.Ctx, tagID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return database.Tag{}, false
+	}
+
+	if err != nil || tag.OwnerID != id {
+		sendError(w, Error{404, fmt.Sprintf("Tag not found: %d", tagID), "Not Found"}, err)
+		return database.Tag{}, false
+	}
+
+	return tag, true
+}
+
+// addFileTags tags existing files, creating missing tags like uploads do.
+func (app *app) addFileTags(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		FileIDs []int64  `json:"file_ids"`
+		Tags    []string `json:"tags"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	for _, tag := range input.Tags {
+		if len(splitTagPath(tag)) == 0 {
+			sendError(w, Error{400, "Missing tag name", "Bad Request"}, nil)
+			return
+		}
+	}
+
+	id := r.Context().Value("id").(int64)
+
+	if !app.checkFileOwner(w, id, input.FileIDs) {
+		return
+	}
+
+	for _, fileID := range input.FileIDs {
+		if err := app.tagFile(id, fileID, input.Tags); err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// removeFileTags detaches tags from files. The tags themselves are kept,
+// pruneTags removes the ones left unused.
+func (app *app) removeFileTags(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		FileIDs []int64  `json:"file_ids"`
+		Tags    []string `json:"tags"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	id := r.Context().Value("id").(int64)
+
+	if !app.checkFileOwner(w, id, input.FileIDs) {
+		return
+	}
+
+	output := struct {
+		Removed int64 `json:"removed"`
+	}{}
+
+	for _, name := range input.Tags {
+		tag, err := app.resolveTagPath(app.Query, id, name, false)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errEmptyTagPath) {
+				sendError(w, Error{404, "Tag not found: " + name, "Not Found"}, err)
+				return
+			}
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+
+		for _, fileID := range input.FileIDs {
+			removed, err := app.Query.UntagFile(app.Ctx, database.UntagFileParams{FileID: fileID, TagID: tag.ID})
+			if err != nil {
+				sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+				return
+			}
+			output.Removed += removed
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// renameTag renames a tag in place, its children keep their names and move
+// along with it.
+func (app *app) renameTag(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		TagID int64  `json:"tag_id"`
+		Name  string `json:"name"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" {
+		sendError(w, Error{400, "Missing tag name", "Bad Request"}, nil)
+		return
+	}
+
+	if strings.Contains(input.Name, "/") {
+		sendError(w, Error{400, "Tag names cannot contain a slash", "Bad Request"}, nil)
+		return
+	}
+
+	id := r.Context().Value("id").(int64)
+
+	tag, ok := app.ownTag(w, id, input.TagID)
+	if !ok {
+		return
+	}
+
+	existing, err := app.Query.GetChildTag(app.Ctx, database.GetChildTagParams{ParentID: tag.ParentID, Name: input.Name, OwnerID: id})
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if err == nil && existing.OwnerID == id && existing.ID != input.TagID {
+		sendError(w, Error{409, "A tag with this name exists, merge the tags instead", "Conflict"}, nil)
+		return
+	}
+
+	if _, err := app.Query.RenameTag(app.Ctx, database.RenameTagParams{Name: input.Name, ID: input.TagID, OwnerID: id}); err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// mergeTags moves the files and children of every tag in tag_ids to into_id
+// and deletes those tags. The target may be a shared tag, but not one below
+// a tag being merged.
+func (app *app) mergeTags(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		TagIDs []int64 `json:"tag_ids"`
+		IntoID int64   `json:"into_id"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	id := r.Context().Value("id").(int64)
+
+	into, err := app.Query.GetTagById(app.Ctx, input.IntoID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if err != nil || (into.OwnerID != id && into.OwnerID != sharedTagOwner) {
+		sendError(w, Error{404, fmt.Sprintf("Tag not found: %d", input.IntoID), "Not Found"}, err)
+		return
+	}
+
+	for _, tagID := range input.TagIDs {
+		if tagID == input.IntoID {
+			sendError(w, Error{400, "Cannot merge a tag into itself", "Bad Request"}, nil)
+			return
+		}
+
+		if _, ok := app.ownTag(w, id, tagID); !ok {
+			return
+		}
+
+		descendants, err := app.Query.GetTagDescendants(app.Ctx, tagID)
+		if err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+
+		if slices.Contains(descendants, input.IntoID) {
+			sendError(w, Error{400, "Cannot merge a tag into a tag below it", "Bad Request"}, nil)
+			return
+		}
+	}
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+	defer tx.Rollback()
+
+	query := app.Query.WithTx(tx)
+
+	for _, tagID := range input.TagIDs {
+		if err := app.mergeTag(query, id, tagID, input.IntoID); err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// deleteTag removes a tag of the user and every tag below it from all of
+// their files.
+func (app *app) deleteTag(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		TagID int64 `json:"tag_id"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	id := r.Context().Value("id").(int64)
+
+	if _, ok := app.ownTag(w, id, input.TagID); !ok {
+		return
+	}
+
+	tagIDs, err := app.Query.GetTagDescendants(app.Ctx, input.TagID)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+	defer tx.Rollback()
+
+	query := app.Query.WithTx(tx)
+
+	for _, tagID := range tagIDs {
+		if err := query.DeleteTagFiles(app.Ctx, tagID); err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+
+		if _, err := query.DeleteTag(app.Ctx, database.DeleteTagParams{ID: tagID, OwnerID: id}); err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// pruneTags deletes the tags of the user that are on none of their files and
+// have no tags below them.
+func (app *app) pruneTags(w http.ResponseWriter, r *http.Request) {
+	id := r.Context().Value("id").(int64)
+
+	output := struct {
+		Deleted int64 `json:"deleted"`
+	}{}
+
+	// A parent becomes unused once its last child is gone, so repeat until
+	// nothing is left to delete
+	for {
+		deleted, err := app.Query.DeleteUnusedTags(app.Ctx, id)
+		if err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+
+		if deleted == 0 {
+			break
+		}
+		output.Deleted += deleted
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// getTagFiles lists the files of the user with the tag at path. With
+// descendants set, files with a tag below it are included, so places/poland
+// also finds places/poland/krakow.
+func (app *app) getTagFiles(w http.ResponseWriter, r *http.Request) {
+	id := r.Context().Value("id").(int64)
+
+	descendants, ok := boolParam(w, r, "descendants")
+	if !ok {
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+
+	tag, err := app.resolveTagPath(app.Query, id, path, false)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) || errors.Is(err, errEmptyTagPath) {
+			sendError(w, Error{404, "Tag not found: " + path, "Not Found"}, err)
+			return
+		}
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	files, err := app.filesByTag(id, tag.ID, descendants)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	withMetadata, err := app.withMetadata(files)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	output := struct {
+		Files []fileWithMetadata `json:"files"`
+	}{
+		Files: withMetadata,
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// filesByTag returns the files of the user with the tag, and with
+// descendants set also those with a tag below it.
+func (app *app) filesByTag(ownerID, tagID int64, descendants bool) ([]database.File, error) {
+	if descendants {
+		return app.Query.GetFilesByTagTree(app.Ctx, database.GetFilesByTagTreeParams{ID: tagID, OwnerID: ownerID})
+	}
+
+	return app.Query.GetFilesByTag(app.Ctx, database.GetFilesByTagParams{TagID: tagID, OwnerID: ownerID})
+}
@@ -0,0 +1,223 @@
+// Package thumbnail scales images down for previews. Thumbnails are JPEG
+// encoded and turned upright as the EXIF orientation of the image says.
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+
+	"server/exif"
+)
+
+// Sizes are the thumbnail sizes by name, the longest edge in pixels.
+var Sizes = map[string]int{
+	"small":  256,
+	"medium": 640,
+	"large":  1280,
+}
+
+// MaxPixels limits the size of the images that are decoded, a 40 megapixel
+// image takes up to 160 MB of memory.
+const MaxPixels = 40_000_000
+
+const quality = 85
+
+var (
+	ErrUnsupported = errors.New("thumbnail: unsupported image format")
+	ErrTooLarge    = errors.New("thumbnail: image too large")
+)
+
+// Make decodes the JPEG, PNG or GIF image in r and returns it scaled to fit
+// each of the edges, in the same order. Images are never scaled up.
+// Transparent parts become white.
+func Make(r io.ReadSeeker, edges []int) ([][]byte, error) {
+	// Metadata is optional, a broken one only loses the orientation
+	metadata, _ := exif.Read(r)
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	config, _, err := image.DecodeConfig(r)
+	if err != nil || config.Width == 0 || config.Height == 0 {
+		return nil, ErrUnsupported
+	}
+	if int64(config.Width)*int64(config.Height) > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+
+	thumbs := make([][]byte, len(edges))
+	for i, img := range scale(src, edges) {
+		thumb := orient(img, metadata.Orientation)
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
+		}
+		thumbs[i] = buf.Bytes()
+	}
+
+	return thumbs, nil
+}
+
+// scale returns src scaled down to fit each of the edges. The source is read
+// one row at a time, converted to RGBA on a white background, so the decoded
+// image is never copied whole.
+func scale(src image.Image, edges []int) []*image.RGBA {
+	b := src.Bounds()
+
+	scalers := make([]*scaler, len(edges))
+	for i, edge := range edges {
+		scalers[i] = newScaler(b.Dx(), b.Dy(), edge)
+	}
+
+	opaque := false
+	if o, ok := src.(interface{ Opaque() bool }); ok {
+		opaque = o.Opaque()
+	}
+
+	row := image.NewRGBA(image.Rect(0, 0, b.Dx(), 1))
+	white := image.NewUniform(color.White)
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		if opaque {
+			draw.Draw(row, row.Rect, src, image.Pt(b.Min.X, y), draw.Src)
+		} else {
+			draw.Draw(row, row.Rect, white, image.Point{}, draw.Src)
+			draw.Draw(row, row.Rect, src, image.Pt(b.Min.X, y), draw.Over)
+		}
+
+		for _, s := range scalers {
+			s.add(row.Pix)
+		}
+	}
+
+	imgs := make([]*image.RGBA, len(scalers))
+	for i, s := range scalers {
+		imgs[i] = s.dst
+	}
+	return imgs
+}
+
+// scaler shrinks an image fed to it row by row until its longest edge is at
+// most edge pixels. Every pixel of the result is the average of the pixels
+// it covers.
+type scaler struct {
+	dst    *image.RGBA
+	sw, sh int   // Source size
+	sy     int   // Source rows added so far
+	y      int   // Destination row being summed
+	sums   []int // Channel sums of the destination row
+}
+
+func newScaler(sw, sh, edge int) *scaler {
+	w, h := sw, sh
+	if sw > edge || sh > edge {
+		w, h = edge, max(1, sh*edge/sw)
+		if sh > sw {
+			w, h = max(1, sw*edge/sh), edge
+		}
+	}
+
+	return &scaler{
+		dst:  image.NewRGBA(image.Rect(0, 0, w, h)),
+		sw:   sw,
+		sh:   sh,
+		sums: make([]int, w*4),
+	}
+}
+
+// add sums the next source row, RGBA pixels, into the destination row it
+// belongs to, writing that row once its last source row was added. The
+// destination is never larger than the source, so source columns and rows
+// x*sw/w up to (x+1)*sw/w belong to destination column x, likewise for rows.
+func (s *scaler) add(row []uint8) {
+	w, h := s.dst.Rect.Dx(), s.dst.Rect.Dy()
+
+	for x := 0; x < w; x++ {
+		sum := s.sums[x*4 : x*4+4]
+		for i := x * s.sw / w * 4; i < (x+1)*s.sw/w*4; i += 4 {
+			sum[0] += int(row[i])
+			sum[1] += int(row[i+1])
+			sum[2] += int(row[i+2])
+			sum[3] += int(row[i+3])
+		}
+	}
+	s.sy++
+
+	y0, y1 := s.y*s.sh/h, (s.y+1)*s.sh/h
+	if s.sy < y1 {
+		return
+	}
+
+	p := s.dst.Pix[s.y*s.dst.Stride:]
+	for x := 0; x < w; x++ {
+		n := (y1 - y0) * ((x+1)*s.sw/w - x*s.sw/w)
+		for i, sum := range s.sums[x*4 : x*4+4] {
+			p[x*4+i] = uint8((sum + n/2) / n)
+		}
+	}
+
+	clear(s.sums)
+	s.y++
+}
+
+// orient turns img upright for the EXIF orientation o. Orientations 5 to 8
+// swap width and height.
+func orient(img *image.RGBA, o int) *image.RGBA {
+	if o < 2 || o > 8 {
+		return img
+	}
+
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+
+	dw, dh := w, h
+	if o >= 5 {
+		dw, dh = h, w
+	}
+
+	// source returns the pixel of img shown at x, y
+	var source func(x, y int) (int, int)
+	switch o {
+	case 2: // flipped horizontally
+		source = func(x, y int) (int, int) { return w - 1 - x, y }
+	case 3: // rotated by 180°
+		source = func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }
+	case 4: // flipped vertically
+		source = func(x, y int) (int, int) { return x, h - 1 - y }
+	case 5: // transposed
+		source = func(x, y int) (int, int) { return y, x }
+	case 6: // needs a clockwise rotation
+		source = func(x, y int) (int, int) { return y, h - 1 - x }
+	case 7: // transversed
+		source = func(x, y int) (int, int) { return w - 1 - y, h - 1 - x }
+	case 8: // needs a counterclockwise rotation
+		source = func(x, y int) (int, int) { return w - 1 - y, x }
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := source(x, y)
+			copy(dst.Pix[y*dst.Stride+x*4:y*dst.Stride+x*4+4], img.Pix[sy*img.Stride+sx*4:])
+		}
+	}
+
+	return dst
+}
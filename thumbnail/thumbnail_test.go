@@ -0,0 +1,83 @@
+package thumbnail
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// gifOfSize returns a GIF whose screen and only frame are w×h pixels. The
+// frame holds no pixel data, which decodes when w or h is 0.
+func gifOfSize(w, h uint16) []byte {
+	b := []byte("GIF89a")
+	b = binary.LittleEndian.AppendUint16(b, w)
+	b = binary.LittleEndian.AppendUint16(b, h)
+	b = append(b, 0x80, 0, 0, 0, 0, 0, 0xFF, 0xFF, 0xFF) // Black and white
+
+	b = append(b, 0x2C, 0, 0, 0, 0)
+	b = binary.LittleEndian.AppendUint16(b, w)
+	b = binary.LittleEndian.AppendUint16(b, h)
+	b = append(b, 0, 0x02, 0x01, 0x2C, 0) // Clear and end codes only
+	b = append(b, 0x3B)
+	return b
+}
+
+func pngOfSize(t *testing.T, w, h int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMakeEmpty(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"0×300": gifOfSize(0, 300),
+		"300×0": gifOfSize(300, 0),
+		"0×0":   gifOfSize(0, 0),
+	} {
+		_, err := Make(bytes.NewReader(data), []int{256, 1280})
+		if !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%s: error = %v, want ErrUnsupported", name, err)
+		}
+	}
+}
+
+func TestMakeNarrow(t *testing.T) {
+	tests := []struct {
+		w, h  int
+		edge  int
+		wantW int
+		wantH int
+	}{
+		{1, 1, 256, 1, 1},
+		{1, 300, 256, 1, 256},
+		{300, 1, 256, 256, 1},
+		{2, 1000, 256, 1, 256},
+		{1000, 3, 256, 256, 1},
+		{100, 50, 256, 100, 50},
+	}
+
+	for _, tt := range tests {
+		thumbs, err := Make(bytes.NewReader(pngOfSize(t, tt.w, tt.h)), []int{tt.edge})
+		if err != nil {
+			t.Errorf("%d×%d: %v", tt.w, tt.h, err)
+			continue
+		}
+
+		config, err := jpeg.DecodeConfig(bytes.NewReader(thumbs[0]))
+		if err != nil {
+			t.Errorf("%d×%d: thumbnail: %v", tt.w, tt.h, err)
+			continue
+		}
+		if config.Width != tt.wantW || config.Height != tt.wantH {
+			t.Errorf("%d×%d: thumbnail is %d×%d, want %d×%d", tt.w, tt.h, config.Width, config.Height, tt.wantW, tt.wantH)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+
+	"server/storage"
+	"server/thumbnail"
+)
+
+// thumbLocks keep thumbnails of the same content from being generated twice
+// at the same time, thumbSlots limit how many are generated at once as a
+// decoded image takes up to 160 MB of memory.
+var (
+	thumbLocks [64]sync.Mutex
+	thumbSlots = make(chan struct{}, 2)
+)
+
+func thumbLock(checksum string) *sync.Mutex {
+	n, _ := strconv.ParseUint(checksum[:2], 16, 8)
+	return &thumbLocks[int(n)%len(thumbLocks)]
+}
+
+// thumbKey names the stored thumbnail of the given size. Thumbnails belong
+// to the content like blobs, files sharing a blob share its thumbnails.
+func thumbKey(checksum, size string) string {
+	return "thumbs/" + checksum[:2] + "/" + checksum + "/" + size + ".jpg"
+}
+
+// thumbURL is where clients fetch a thumbnail of the file.
+func thumbURL(fileID int64, size string) string {
+	return "/file/" + strconv.FormatInt(fileID, 10) + "/thumb/" + size
+}
+
+// thumbURLs returns the URLs of every thumbnail size of the file.
+func thumbURLs(fileID int64) map[string]string {
+	urls := make(map[string]string, len(thumbnail.Sizes))
+	for size := range thumbnail.Sizes {
+		urls[size] = thumbURL(fileID, size)
+	}
+	return urls
+}
+
+// makeThumbnails generates the missing thumbnails of the content. Content
+// that is not an image gives thumbnail.ErrUnsupported.
+func (app *app) makeThumbnails(checksum string) error {
+	lock := thumbLock(checksum)
+	lock.Lock()
+	defer lock.Unlock()
+
+	var sizes []string
+	for size := range thumbnail.Sizes {
+		_, err := app.Store.Stat(app.Ctx, thumbKey(checksum, size))
+		if errors.Is(err, storage.ErrNotExist) {
+			sizes = append(sizes, size)
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if len(sizes) == 0 {
+		return nil
+	}
+	slices.Sort(sizes)
+
+	thumbSlots <- struct{}{}
+	defer func() { <-thumbSlots }()
+
+	blob, err := app.Store.Get(app.Ctx, blobKey(checksum))
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	edges := make([]int, len(sizes))
+	for i, size := range sizes {
+		edges[i] = thumbnail.Sizes[size]
+	}
+
+	thumbs, err := thumbnail.Make(blob, edges)
+	if err != nil {
+		return err
+	}
+
+	// The blob may have been released while the thumbnails were generated,
+	// removeUnusedBlob deletes them while holding the same lock
+	blobLock := blobLock(checksum)
+	blobLock.Lock()
+	defer blobLock.Unlock()
+
+	if _, err := app.Store.Stat(app.Ctx, blobKey(checksum)); err != nil {
+		return err
+	}
+
+	for i, size := range sizes {
+		err := app.Store.Put(app.Ctx, thumbKey(checksum, size), bytes.NewReader(thumbs[i]), int64(len(thumbs[i])))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// makeThumbnailsLater generates the thumbnails of new content in the
+// background so they are ready when the file is first shown.
+func (app *app) makeThumbnailsLater(checksum string) {
+	go func() {
+		// Nothing recovers panics of this goroutine, a bad image would stop
+		// the server
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("Could not generate thumbnails for %s: panic: %v", checksum, p)
+			}
+		}()
+
+		err := app.makeThumbnails(checksum)
+		if err != nil && !errors.Is(err, thumbnail.ErrUnsupported) {
+			log.Printf("Could not generate thumbnails for %s: %v", checksum, err)
+		}
+	}()
+}
+
+// removeThumbnails deletes the thumbnails of the content. The caller must
+// hold blobLock(checksum).
+func (app *app) removeThumbnails(checksum string) error {
+	for size := range thumbnail.Sizes {
+		err := app.Store.Delete(app.Ctx, thumbKey(checksum, size))
+		if err != nil && !errors.Is(err, storage.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// serveThumbnail streams a thumbnail of an image, generating it when it is
+// not stored yet. size is small, medium or large, the longest edge being
+// 256, 640 or 1280 pixels. Files that are not images have none.
+func (app *app) serveThumbnail(w http.ResponseWriter, r *http.Request) {
+	fileID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		sendError(w, Error{400, "Invalid file id", "Bad Request"}, err)
+		return
+	}
+
+	size := r.PathValue("size")
+	if _, ok := thumbnail.Sizes[size]; !ok {
+		sendError(w, Error{400, "Invalid size, use small, medium or large", "Bad Request"}, nil)
+		return
+	}
+
+	id := r.Context().Value("id").(int64)
+
+	isAdmin, err := app.Query.GetIsAdmin(app.Ctx, id)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	file, err := app.Query.GetFile(app.Ctx, fileID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			sendError(w, Error{404, "File not found", "Not Found"}, err)
+			return
+		}
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if file.OwnerID != id && isAdmin == 0 {
+		sendError(w, Error{404, "File not found", "Not Found"}, nil)
+		return
+	}
+
+	thumb, err := app.Store.Get(app.Ctx, thumbKey(file.Checksum, size))
+	if errors.Is(err, storage.ErrNotExist) {
+		if err = app.makeThumbnails(file.Checksum); err == nil {
+			thumb, err = app.Store.Get(app.Ctx, thumbKey(file.Checksum, size))
+		}
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, thumbnail.ErrUnsupported):
+			sendError(w, Error{404, "File has no thumbnail", "Not Found"}, err)
+		case errors.Is(err, thumbnail.ErrTooLarge):
+			sendError(w, Error{404, "Image too large for a thumbnail", "Not Found"}, err)
+		default:
+			sendError(w, Error{500, "Error creating thumbnail:" + file.FileName, "Internal Server Error"}, err)
+		}
+		return
+	}
+	defer thumb.Close()
+
+	// Thumbnails only change with the content
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("ETag", `"`+file.Checksum+"-"+size+`"`)
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	http.ServeContent(w, r, "", file.CreatedAt.Time, thumb)
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"server/auth"
+	"server/database"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// recoveryCodeCount is how many recovery codes a user gets at a time.
+const recoveryCodeCount = 10
+
+// hasTwoFactor reports whether the user has confirmed TOTP enrolment.
+func (app *app) hasTwoFactor(id int64) (bool, error) {
+	totp, err := app.Query.GetTOTP(app.Ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return totp.Confirmed == 1, nil
+}
+
+// checkTOTP validates the code and consumes its time step.
+func (app *app) checkTOTP(totp database.Totp, code string) (bool, error) {
+	step, ok := auth.ValidateTOTP(totp.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	used, err := app.Query.UseTOTPStep(app.Ctx, database.UseTOTPStepParams{
+		LastStep:   step,
+		UserID:     totp.UserID,
+		LastStep_2: step,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return used > 0, nil
+}
+
+// replaceRecoveryCodes invalidates the user's recovery codes and returns a
+// fresh set. Only their hashes are stored.
+func (app *app) replaceRecoveryCodes(id int64) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := app.Query.WithTx(tx)
+	if err := query.DeleteRecoveryCodes(app.Ctx, id); err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		err := query.CreateRecoveryCode(app.Ctx, database.CreateRecoveryCodeParams{
+			UserID:   id,
+			CodeHash: auth.HashRecoveryCode(code),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, tx.Commit()
+}
+
+// setupTwoFactor starts enrolment with a new secret. Two-factor login is only
+// enforced after the user proves their app works with confirmTwoFactor.
+func (app *app) setupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id := r.Context().Value("id").(int64)
+
+	enabled, err := app.hasTwoFactor(id)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if enabled {
+		sendError(w, Error{409, "Two-factor authentication is already enabled", "Conflict"}, nil)
+		return
+	}
+
+	login, err := app.Query.GetLogin(app.Ctx, id)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		sendError(w, Error{500, "Could not generate a secret", "Internal Server Error"}, err)
+		return
+	}
+
+	if err := app.Query.SetTOTPSecret(app.Ctx, database.SetTOTPSecretParams{UserID: id, Secret: secret}); err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	output := struct {
+		Secret string `json:"secret"`
+		URI    string `json:"uri"`
+	}{
+		Secret: secret,
+		URI:    auth.TOTPURI(login, secret),
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// confirmTwoFactor enables two-factor login once the user enters a valid code
+// and hands out the recovery codes.
+func (app *app) confirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		Code string `json:"code"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	id := r.Context().Value("id").(int64)
+
+	totp, err := app.Query.GetTOTP(app.Ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			sendError(w, Error{404, "Two-factor setup was not started", "Not Found"}, err)
+			return
+		}
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if totp.Confirmed == 1 {
+		sendError(w, Error{409, "Two-factor authentication is already enabled", "Conflict"}, nil)
+		return
+	}
+
+	step, ok := auth.ValidateTOTP(totp.Secret, input.Code, time.Now())
+	if !ok {
+		sendError(w, Error{400, "Invalid code", "Bad Request"}, nil)
+		return
+	}
+
+	if err := app.Query.ConfirmTOTP(app.Ctx, database.ConfirmTOTPParams{LastStep: step, UserID: id}); err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	codes, err := app.replaceRecoveryCodes(id)
+	if err != nil {
+		sendError(w, Error{500, "Could not generate recovery codes", "Internal Server Error"}, err)
+		return
+	}
+
+	log.Printf("2FA: -- Enabled for user %d", id)
+
+	output := struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: codes,
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// disableTwoFactor turns two-factor login off. The password is asked again
+// so a stolen session cannot weaken the account.
+func (app *app) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		Password string `json:"password"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	id := r.Context().Value("id").(int64)
+
+	password, err := app.Query.GetPassword(app.Ctx, id)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(password), []byte(input.Password)); err != nil {
+		sendError(w, Error{401, "Wrong password", "Unauthorized"}, err)
+		return
+	}
+
+	if !app.removeTwoFactor(w, id) {
+		return
+	}
+
+	log.Printf("2FA: -- Disabled for user %d", id)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// regenerateRecoveryCodes replaces the recovery codes, e.g. after most of
+// them were used. A current code is required.
+func (app *app) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		Code string `json:"code"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	id := r.Context().Value("id").(int64)
+
+	totp, err := app.Query.GetTOTP(app.Ctx, id)
+	if err != nil || totp.Confirmed == 0 {
+		sendError(w, Error{404, "Two-factor authentication is not enabled", "Not Found"}, err)
+		return
+	}
+
+	ok, err := app.checkTOTP(totp, input.Code)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if !ok {
+		sendError(w, Error{400, "Invalid code", "Bad Request"}, nil)
+		return
+	}
+
+	codes, err := app.replaceRecoveryCodes(id)
+	if err != nil {
+		sendError(w, Error{500, "Could not generate recovery codes", "Internal Server Error"}, err)
+		return
+	}
+
+	output := struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: codes,
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (app *app) getTwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	id := r.Context().Value("id").(int64)
+
+	enabled, err := app.hasTwoFactor(id)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	left, err := app.Query.CountRecoveryCodes(app.Ctx, id)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	output := struct {
+		Enabled       bool  `json:"enabled"`
+		RecoveryCodes int64 `json:"recovery_codes_left"`
+	}{
+		Enabled:       enabled,
+		RecoveryCodes: left,
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// loginTwoFactor is the second login step. It exchanges the challenge from
+// login and a TOTP or recovery code for a session.
+func (app *app) loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	prepareResponse(w)
+
+	input := struct {
+		Challenge    string `json:"challenge"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+		SetCookie    bool   `json:"set_cookie"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	id, err := auth.ValidateChallenge(app.CACHE, input.Challenge)
+	if err != nil {
+		sendError(w, Error{401, "Login expired, enter the password again", "Unauthorized"}, err)
+		return
+	}
+
+	user, err := app.Query.GetUser(app.Ctx, id)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	// The account may have been disabled since the password was accepted
+	if user.Disabled == 1 {
+		sendError(w, Error{403, "Account is disabled", "Forbidden"}, nil)
+		return
+	}
+
+	ip := clientIP(r)
+
+	lockedUntil, err := auth.LoginLockedUntil(app.CACHE, user.Login, ip)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if !lockedUntil.IsZero() {
+		rejectLogin(w, lockedUntil, nil)
+		return
+	}
+
+	totp, err := app.Query.GetTOTP(app.Ctx, id)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	var ok bool
+	if input.RecoveryCode != "" {
+		used, err := app.Query.UseRecoveryCode(app.Ctx, database.UseRecoveryCodeParams{
+			UserID:   id,
+			CodeHash: auth.HashRecoveryCode(input.RecoveryCode),
+		})
+		if err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+		ok = used > 0
+	} else {
+		ok, err = app.checkTOTP(totp, input.Code)
+		if err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+	}
+
+	if !ok {
+		app.loginFailed(w, user.Login, ip, nil)
+		return
+	}
+
+	auth.EndChallenge(app.CACHE, input.Challenge)
+
+	if input.RecoveryCode != "" {
+		log.Printf("2FA: -- User %d logged in with a recovery code", id)
+	}
+
+	app.startSession(w, r, user, input.SetCookie)
+}
+
+// removeTwoFactor deletes the user's secret and recovery codes, answering
+// 404 when two-factor authentication was never set up.
+func (app *app) removeTwoFactor(w http.ResponseWriter, id int64) bool {
+	removed, err := app.Query.DeleteTOTP(app.Ctx, id)
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return false
+	}
+
+	if removed == 0 {
+		sendError(w, Error{404, "Two-factor authentication is not enabled", "Not Found"}, nil)
+		return false
+	}
+
+	if err := app.Query.DeleteRecoveryCodes(app.Ctx, id); err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return false
+	}
+
+	return true
+}
+
+// adminResetTwoFactor removes two-factor authentication from an account whose
+// owner lost both the phone and the recovery codes.
+func (app *app) adminResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		UserID int64 `json:"user_id"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	if !app.removeTwoFactor(w, input.UserID) {
+		return
+	}
+
+	log.Printf("Admin: -- Reset two-factor authentication of user %d", input.UserID)
+
+	w.WriteHeader(http.StatusOK)
+}
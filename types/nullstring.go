@@ -68,3 +68,32 @@ func (j *JSONNullTime) MarshalJSON() ([]byte, error) {
 	json, err := json.Marshal(j.Time)
 	return json, err
 }
+
+// JSONNullFloat64 is encoded as null when it is not valid, unlike the types
+// above, since 0 is a meaningful coordinate.
+type JSONNullFloat64 struct {
+	sql.NullFloat64
+}
+
+func (j *JSONNullFloat64) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		j.Valid = false
+		j.Float64 = 0
+		return nil
+	}
+
+	var f float64
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	j.Float64 = f
+	j.Valid = true
+	return nil
+}
+
+func (j JSONNullFloat64) MarshalJSON() ([]byte, error) {
+	if !j.Valid {
+		return []byte("null"), nil
+	}
+	return json.Marshal(j.Float64)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"server/database"
+	"server/types"
+)
+
+// Form fields are small text values, anything bigger is a malformed request.
+const maxFormFieldSize = 64 << 10
+
+func nullString(s string) types.JSONNullString {
+	return types.JSONNullString{NullString: sql.NullString{String: s, Valid: s != ""}}
+}
+
+// tagFile connects the file to every tag in tags. Tags are slash-separated
+// paths like places/poland/krakow, every level is looked up among the tags of
+// the owner and the shared tags and missing ones become tags of the owner.
+func (app *app) tagFile(ownerID, fileID int64, tags []string) error {
+	for _, tag := range tags {
+		if len(splitTagPath(tag)) == 0 {
+			continue
+		}
+
+		tagDB, err := app.resolveTagPath(app.Query, ownerID, tag, true)
+		if err != nil {
+			return err
+		}
+
+		err = app.Query.TagsConnect(app.Ctx, database.TagsConnectParams{FileID: fileID, TagID: tagDB.ID})
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// storeUpload spools src to a temporary file while computing its SHA-256
+// checksum, then stores it with commitFile. Reading stops once src no longer
+// fits into the quota of the owner.
+func (app *app) storeUpload(metadata database.AddFileParams, src io.Reader) (int64, error) {
+	quota, room, err := app.quotaRoom(metadata.OwnerID)
+	if err != nil {
+		return 0, err
+	}
+
+	if quota > 0 {
+		src = io.LimitReader(src, room+1)
+	}
+
+	tmp, err := os.CreateTemp(uploadDir, ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tmp, hash), src)
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+
+	// The rest was not read, so the checksum is not the one of the file
+	if err := quotaError(quota, room, written); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+
+	metadata.Checksum = hex.EncodeToString(hash.Sum(nil))
+
+	return app.commitFile(metadata, tmp.Name())
+}
+
+// commitFile records the file in the database and copies the data at path
+// into the blob store.
+func (app *app) commitFile(metadata database.AddFileParams, path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	return app.addFile(metadata, f, stat.Size())
+}
+
+// linkUpload adds a file for content the user has uploaded before, so only
+// the checksum needs to be sent. Unknown checksums are answered with 404 and
+// the client falls back to a regular upload.
+func (app *app) linkUpload(w http.ResponseWriter, r *http.Request) {
+	input := struct {
+		Metadata database.AddFileParams `json:"metadata"`
+		Tags     []string               `json:"tags"`
+	}{}
+
+	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+		sendError(w, Error{400, "Could not acquire json data", "Bad Request"}, err)
+		return
+	}
+
+	if !validChecksum(input.Metadata.Checksum) {
+		sendError(w, Error{400, "Invalid checksum", "Bad Request"}, nil)
+		return
+	}
+
+	if !validCoordinates(input.Metadata.Coordinates) {
+		sendError(w, Error{400, "Invalid coordinates", "Bad Request"}, nil)
+		return
+	}
+
+	input.Metadata.OwnerID = r.Context().Value("id").(int64)
+
+	fileID, linked, err := app.linkFile(input.Metadata)
+	if sendQuotaError(w, err) {
+		return
+	}
+	if err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	if !linked {
+		sendError(w, Error{404, "Unknown checksum", "Not Found"}, nil)
+		return
+	}
+
+	if err := app.tagFile(input.Metadata.OwnerID, fileID, input.Tags); err != nil {
+		sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+		return
+	}
+
+	output := struct {
+		FileID int64 `json:"file_id"`
+	}{
+		FileID: fileID,
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// uploadFileStream accepts a multipart/form-data body. Text fields describe
+// the file part that follows them: file_name, title, description, coordinates
+// and any number of tags. Each file part is streamed straight to disk, so
+// metadata fields must be sent before the file they belong to.
+func (app *app) uploadFileStream(w http.ResponseWriter, r *http.Request) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		sendError(w, Error{400, "Expected multipart/form-data body", "Bad Request"}, err)
+		return
+	}
+
+	id := r.Context().Value("id").(int64)
+
+	output := struct {
+		FileIds []int64 `json:"file_ids"`
+	}{}
+
+	metadata := database.AddFileParams{OwnerID: id}
+	var tags []string
+
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			sendError(w, Error{400, "Could not read multipart body", "Bad Request"}, err)
+			return
+		}
+
+		if part.FileName() == "" {
+			// One byte more tells a field at the limit from a longer one
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
+			part.Close()
+			if err != nil {
+				sendError(w, Error{400, "Could not read form field", "Bad Request"}, err)
+				return
+			}
+			if len(value) > maxFormFieldSize {
+				sendError(w, Error{400, "Form field too long: " + part.FormName(), "Bad Request"}, nil)
+				return
+			}
+
+			switch part.FormName() {
+			case "file_name":
+				metadata.FileName = string(value)
+			case "title":
+				metadata.Title = nullString(string(value))
+			case "description":
+				metadata.Description = nullString(string(value))
+			case "coordinates":
+				metadata.Coordinates = nullString(string(value))
+			case "tags":
+				tags = append(tags, string(value))
+			}
+			continue
+		}
+
+		if metadata.FileName == "" {
+			metadata.FileName = part.FileName()
+		}
+
+		if !validCoordinates(metadata.Coordinates) {
+			part.Close()
+			sendError(w, Error{400, "Invalid coordinates", "Bad Request"}, nil)
+			return
+		}
+
+		fileID, err := app.storeUpload(metadata, part)
+		part.Close()
+		if sendQuotaError(w, err) {
+			return
+		}
+		if err != nil {
+			sendError(w, Error{500, "Could not store file: " + metadata.FileName, "Internal Server Error"}, err)
+			return
+		}
+
+		if err := app.tagFile(id, fileID, tags); err != nil {
+			sendError(w, Error{500, "Database", "Internal Server Error"}, err)
+			return
+		}
+
+		output.FileIds = append(output.FileIds, fileID)
+
+		metadata = database.AddFileParams{OwnerID: id}
+		tags = nil
+	}
+
+	if err := json.NewEncoder(w).Encode(&output); err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -4,21 +4,21 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"os"
-	"path/filepath"
 	"server/types"
 	"strings"
 
 	"server/database"
 )
 
-func AddUser(query *database.Queries, login, passwordHash, email string) (string, error) {
+// AddUser creates the account and returns the sanitized login and the new
+// user id. Inactive accounts cannot log in until they are activated.
+func AddUser(query *database.Queries, login, passwordHash, email string, active bool) (string, int64, error) {
 	// Sanitize user input
 	login = strings.ReplaceAll(login, "/", "∕")
 	login = strings.TrimSpace(login)
 
 	if login == "" || passwordHash == "" {
-		return "", errors.New("Empty login data")
+		return "", 0, errors.New("Empty login data")
 	}
 
 	user := database.CreateUserParams{
@@ -26,22 +26,14 @@ func AddUser(query *database.Queries, login, passwordHash, email string) (string
 		Password: passwordHash,
 		Email:    types.JSONNullString{NullString: sql.NullString{String: email, Valid: email != ""}},
 	}
-
-	err := query.CreateUser(context.Background(), user)
-	if err != nil {
-		return "", err
-	}
-
-	// Create user directory
-	userdir := filepath.Join("../storage/users/", login)
-	if _, err := os.Stat(userdir); !os.IsNotExist(err) {
-		return login, nil
+	if active {
+		user.Active = 1
 	}
 
-	err = os.MkdirAll(userdir, 0755)
+	id, err := query.CreateUser(context.Background(), user)
 	if err != nil {
-		return login, err
+		return "", 0, err
 	}
 
-	return login, nil
+	return login, id, nil
 }